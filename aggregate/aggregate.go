@@ -0,0 +1,345 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aggregate provides a zoekt.Streamer that fans searches out to
+// several backends, eg. zoekt-webservers that each serve a part of the
+// corpus, and merges their results.
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/query"
+	"github.com/google/zoekt/rpc"
+	"github.com/google/zoekt/stream"
+)
+
+// Dial returns a Streamer for the zoekt-webservers at addrs (host:port),
+// which must be started with -rpc. Searches use the stream API, and List
+// uses RPC.
+func Dial(addrs []string) zoekt.Streamer {
+	backends := make([]zoekt.Streamer, 0, len(addrs))
+	for _, addr := range addrs {
+		backends = append(backends, &remote{
+			Searcher: rpc.Client(addr),
+			client:   stream.NewClient("http://"+addr, nil),
+		})
+	}
+	return New(backends...)
+}
+
+// remote is a zoekt-webserver.
+type remote struct {
+	zoekt.Searcher
+	client *stream.Client
+}
+
+func (r *remote) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	return r.client.StreamSearch(ctx, q, opts, sender)
+}
+
+// New returns a Streamer that searches all backends. Backends that fail are
+// counted in Stats.Crashes; the search only fails if all of them do.
+//
+// StreamSearch sends results as they arrive, with the MaxPendingPriority of
+// all backends. If SearchOptions.MaxDocDisplayCount is set, it holds results
+// until no backend can send results of a higher Progress.Priority, so that
+// the limit keeps the files of the highest priority. TotalMaxMatchCount and
+// MaxDocDisplayCount apply to the merged results. Sorting by
+// SortByCommitDate isn't supported, since the commit dates of the
+// repositories are only known to their backend.
+func New(backends ...zoekt.Streamer) zoekt.Streamer {
+	return &aggregateSearcher{backends: backends}
+}
+
+type aggregateSearcher struct {
+	backends []zoekt.Streamer
+}
+
+func (s *aggregateSearcher) String() string {
+	names := make([]string, 0, len(s.backends))
+	for _, b := range s.backends {
+		names = append(names, b.String())
+	}
+	return fmt.Sprintf("aggregate(%s)", strings.Join(names, ", "))
+}
+
+func (s *aggregateSearcher) Close() {
+	for _, b := range s.backends {
+		b.Close()
+	}
+}
+
+func (s *aggregateSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	order, err := zoekt.ParseSortOrder(string(opts.SortBy))
+	if err != nil {
+		return nil, err
+	}
+	if order == zoekt.SortByCommitDate {
+		return nil, fmt.Errorf("sort order %q isn't supported across backends", order)
+	}
+
+	start := time.Now()
+	aggregate := &zoekt.SearchResult{
+		RepoURLs:      map[string]string{},
+		LineFragments: map[string]string{},
+	}
+	err = s.fanOut(ctx, q, opts, func(r *zoekt.SearchResult) bool {
+		aggregate.Stats.Add(r.Stats)
+		aggregate.Explanations = append(aggregate.Explanations, r.Explanations...)
+		if r.Facets != nil {
+			if aggregate.Facets == nil {
+				aggregate.Facets = &zoekt.Facets{}
+			}
+			aggregate.Facets.Add(r.Facets)
+		}
+		zoekt.AddCaptures(&aggregate.Captures, r.Captures)
+
+		aggregate.Files = append(aggregate.Files, r.Files...)
+		for k, v := range r.RepoURLs {
+			aggregate.RepoURLs[k] = v
+		}
+		for k, v := range r.LineFragments {
+			aggregate.LineFragments[k] = v
+		}
+		for k, v := range r.CommitURLs {
+			if aggregate.CommitURLs == nil {
+				aggregate.CommitURLs = map[string]string{}
+			}
+			aggregate.CommitURLs[k] = v
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	zoekt.SortFiles(aggregate.Files, order, nil)
+	if opts.CollapseDuplicates {
+		aggregate.Files = zoekt.CollapseDuplicates(aggregate.Files)
+	}
+	if max := opts.MaxDocDisplayCount; max > 0 && len(aggregate.Files) > max {
+		aggregate.Files = aggregate.Files[:max]
+		aggregate.NextCursor = zoekt.NewCursor(&aggregate.Files[max-1], order).String()
+	}
+	aggregate.Duration = time.Since(start)
+	return aggregate, nil
+}
+
+func (s *aggregateSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	if order, err := zoekt.ParseSortOrder(string(opts.SortBy)); err != nil {
+		return err
+	} else if order != zoekt.SortByScore {
+		// Batches can only be sorted by score as they arrive.
+		sr, err := s.Search(ctx, q, opts)
+		if err != nil {
+			return err
+		}
+		sender.Send(sr)
+		return nil
+	}
+
+	max := opts.MaxDocDisplayCount
+	if max <= 0 {
+		return s.fanOut(ctx, q, opts, func(r *zoekt.SearchResult) bool {
+			sender.Send(r)
+			return false
+		})
+	}
+
+	var (
+		// held are the results that a backend may still outrank.
+		held []*zoekt.SearchResult
+		sent int
+	)
+	// release sends the held results whose priority is at least
+	// maxPending, highest first, and reports whether the limit is
+	// reached. Results past the limit only carry their stats.
+	release := func(maxPending float64, all bool) bool {
+		sort.SliceStable(held, func(i, j int) bool { return held[i].Priority > held[j].Priority })
+		n := 0
+		for ; n < len(held) && (all || held[n].Priority >= maxPending); n++ {
+			r := held[n]
+			if sent+len(r.Files) > max {
+				r.Files = r.Files[:max-sent]
+			}
+			sent += len(r.Files)
+			r.MaxPendingPriority = maxPending
+			sender.Send(r)
+		}
+		held = held[n:]
+		return sent >= max
+	}
+	err := s.fanOut(ctx, q, opts, func(r *zoekt.SearchResult) bool {
+		held = append(held, r)
+		return release(r.MaxPendingPriority, false)
+	})
+	// All backends are done.
+	release(0, true)
+	return err
+}
+
+// fanOut runs the search on all backends and calls send with their results,
+// one at a time. The MaxPendingPriority of the results is the maximum over
+// the backends that are still searching, which is +Inf until all of them
+// have reported one. If send returns true, or the results exceed
+// opts.TotalMaxMatchCount, the remaining searches are canceled, and later
+// results only carry their stats.
+func (s *aggregateSearcher) fanOut(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, send func(*zoekt.SearchResult) bool) error {
+	if len(s.backends) == 0 {
+		return nil
+	}
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu sync.Mutex // protects the following and serializes send
+
+		// pending is the last MaxPendingPriority of each backend that
+		// is still searching. Backends that haven't reported one yet may
+		// send results of any priority.
+		pending    = make(map[int]float64, len(s.backends))
+		matchCount int
+		stopped    bool
+	)
+
+	maxPending := func() float64 {
+		max := 0.0
+		for _, p := range pending {
+			if p > max {
+				max = p
+			}
+		}
+		return max
+	}
+
+	for i := range s.backends {
+		pending[i] = math.Inf(1)
+	}
+
+	errs := make(chan error, len(s.backends))
+	for i, b := range s.backends {
+		go func(i int, b zoekt.Streamer) {
+			err := b.StreamSearch(searchCtx, q, opts, stream.SenderFunc(func(r *zoekt.SearchResult) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				pending[i] = r.MaxPendingPriority
+				r.MaxPendingPriority = maxPending()
+				if stopped {
+					r = &zoekt.SearchResult{Stats: r.Stats, Progress: r.Progress}
+				}
+
+				matchCount += r.Stats.MatchCount
+				if send(r) || (opts.TotalMaxMatchCount > 0 && matchCount > opts.TotalMaxMatchCount) {
+					stopped = true
+					cancel()
+				}
+			}))
+
+			mu.Lock()
+			delete(pending, i)
+			if err != nil && !stopped && ctx.Err() == nil {
+				log.Printf("aggregate: backend %s failed: %v", b, err)
+				send(&zoekt.SearchResult{
+					Stats:    zoekt.Stats{Crashes: 1},
+					Progress: zoekt.Progress{MaxPendingPriority: maxPending()},
+				})
+			} else {
+				err = nil
+			}
+			mu.Unlock()
+
+			errs <- err
+		}(i, b)
+	}
+
+	var failed []error
+	for range s.backends {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failed) == len(s.backends) {
+		return fmt.Errorf("all %d backends failed, first error: %w", len(failed), failed[0])
+	}
+	return nil
+}
+
+func (s *aggregateSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	type listResult struct {
+		rl  *zoekt.RepoList
+		err error
+	}
+	results := make(chan listResult, len(s.backends))
+	for _, b := range s.backends {
+		go func(b zoekt.Streamer) {
+			rl, err := b.List(ctx, q, opts)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("aggregate: backend %s failed: %v", b, err)
+			}
+			results <- listResult{rl, err}
+		}(b)
+	}
+
+	agg := &zoekt.RepoList{
+		Minimal: map[uint32]*zoekt.MinimalRepoListEntry{},
+	}
+	uniq := map[string]*zoekt.RepoListEntry{}
+	var failed []error
+	for range s.backends {
+		r := <-results
+		if r.err != nil {
+			failed = append(failed, r.err)
+			agg.Crashes++
+			continue
+		}
+
+		agg.Crashes += r.rl.Crashes
+		agg.Stats.Add(&r.rl.Stats)
+		for _, e := range r.rl.Repos {
+			if prev, ok := uniq[e.Repository.Name]; ok {
+				prev.Stats.Add(&e.Stats)
+				continue
+			}
+			cp := *e
+			uniq[e.Repository.Name] = &cp
+			agg.Repos = append(agg.Repos, &cp)
+		}
+		for id, e := range r.rl.Minimal {
+			if _, ok := agg.Minimal[id]; !ok {
+				agg.Minimal[id] = e
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.backends) > 0 && len(failed) == len(s.backends) {
+		return nil, fmt.Errorf("all %d backends failed, first error: %w", len(failed), failed[0])
+	}
+	return agg, nil
+}
@@ -0,0 +1,279 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/query"
+	"github.com/google/zoekt/stream"
+)
+
+// fakeBackend sends results in order, and then fails with err.
+type fakeBackend struct {
+	name    string
+	results []*zoekt.SearchResult
+	repos   []*zoekt.RepoListEntry
+	err     error
+}
+
+func (b *fakeBackend) String() string { return b.name }
+func (b *fakeBackend) Close()         {}
+
+func (b *fakeBackend) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	panic("not used")
+}
+
+func (b *fakeBackend) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	for _, r := range b.results {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cp := *r
+		sender.Send(&cp)
+	}
+	return b.err
+}
+
+func (b *fakeBackend) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &zoekt.RepoList{Repos: b.repos}, nil
+}
+
+func file(repo, name string, score float64) zoekt.FileMatch {
+	return zoekt.FileMatch{Repository: repo, FileName: name, Score: score}
+}
+
+func result(priority float64, files ...zoekt.FileMatch) *zoekt.SearchResult {
+	return &zoekt.SearchResult{
+		Files:    files,
+		Stats:    zoekt.Stats{FileCount: len(files), MatchCount: len(files)},
+		Progress: zoekt.Progress{Priority: priority, MaxPendingPriority: priority},
+	}
+}
+
+func fileNames(fms []zoekt.FileMatch) []string {
+	var names []string
+	for _, f := range fms {
+		names = append(names, f.Repository+"/"+f.FileName)
+	}
+	return names
+}
+
+func TestSearch(t *testing.T) {
+	s := New(
+		&fakeBackend{name: "a", results: []*zoekt.SearchResult{
+			result(2, file("r1", "a.go", 10)),
+			result(1, file("r1", "b.go", 3)),
+		}},
+		&fakeBackend{name: "b", results: []*zoekt.SearchResult{
+			result(2, file("r2", "c.go", 5)),
+		}},
+		&fakeBackend{name: "broken", err: errors.New("connection refused")},
+	)
+	defer s.Close()
+
+	sr, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(sr.Files), []string{"r1/a.go", "r2/c.go", "r1/b.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+	if sr.Stats.FileCount != 3 || sr.Stats.MatchCount != 3 || sr.Stats.Crashes != 1 {
+		t.Errorf("got stats %+v, want 3 files, 3 matches and 1 crash", sr.Stats)
+	}
+
+	sr, err = s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{MaxDocDisplayCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(sr.Files), []string{"r1/a.go", "r2/c.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+	if sr.NextCursor == "" {
+		t.Error("want a NextCursor for the truncated results")
+	}
+
+	sr, err = s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{SortBy: zoekt.SortByPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(sr.Files), []string{"r1/a.go", "r1/b.go", "r2/c.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+
+	if _, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{SortBy: zoekt.SortByCommitDate}); err == nil {
+		t.Error("want an error for SortByCommitDate")
+	}
+}
+
+func TestSearchAllFailed(t *testing.T) {
+	s := New(
+		&fakeBackend{name: "a", err: errors.New("a")},
+		&fakeBackend{name: "b", err: errors.New("b")},
+	)
+	if _, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}); err == nil {
+		t.Error("want an error if all backends fail")
+	}
+}
+
+func TestStreamSearchLimits(t *testing.T) {
+	s := New(
+		&fakeBackend{name: "a", results: []*zoekt.SearchResult{
+			result(3, file("r1", "a.go", 10), file("r1", "b.go", 9)),
+			result(2, file("r1", "c.go", 8), file("r1", "d.go", 7)),
+		}},
+	)
+
+	var files []zoekt.FileMatch
+	err := s.StreamSearch(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{MaxDocDisplayCount: 3}, stream.SenderFunc(func(r *zoekt.SearchResult) {
+		files = append(files, r.Files...)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(files), []string{"r1/a.go", "r1/b.go", "r1/c.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+
+	files = nil
+	err = s.StreamSearch(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{TotalMaxMatchCount: 1}, stream.SenderFunc(func(r *zoekt.SearchResult) {
+		files = append(files, r.Files...)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(files), []string{"r1/a.go", "r1/b.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+}
+
+func TestStreamSearchPriority(t *testing.T) {
+	// b blocks until a is done, so the priorities are deterministic.
+	aDone := make(chan struct{})
+	a := &fakeBackend{name: "a", results: []*zoekt.SearchResult{result(5, file("r1", "a.go", 1))}}
+	b := &blockingBackend{
+		fakeBackend: fakeBackend{name: "b", results: []*zoekt.SearchResult{result(1, file("r2", "b.go", 1))}},
+		wait:        aDone,
+	}
+	s := New(&doneBackend{fakeBackend: a, done: aDone}, b)
+
+	var mu sync.Mutex
+	got := map[string]float64{}
+	err := s.StreamSearch(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}, stream.SenderFunc(func(r *zoekt.SearchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, f := range r.Files {
+			got[f.Repository] = r.MaxPendingPriority
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b hasn't reported a priority when a sends its result, so it may
+	// still send results of any priority.
+	want := map[string]float64{"r1": math.Inf(1), "r2": 1}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("MaxPendingPriority mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestStreamSearchLimitByPriority(t *testing.T) {
+	// a sends a low priority file before b sends a higher one.
+	aDone := make(chan struct{})
+	a := &fakeBackend{name: "a", results: []*zoekt.SearchResult{result(1, file("r1", "a.go", 1))}}
+	b := &blockingBackend{
+		fakeBackend: fakeBackend{name: "b", results: []*zoekt.SearchResult{result(5, file("r2", "b.go", 1))}},
+		wait:        aDone,
+	}
+	s := New(&doneBackend{fakeBackend: a, done: aDone}, b)
+
+	var files []zoekt.FileMatch
+	var stats zoekt.Stats
+	err := s.StreamSearch(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{MaxDocDisplayCount: 1}, stream.SenderFunc(func(r *zoekt.SearchResult) {
+		files = append(files, r.Files...)
+		stats.Add(r.Stats)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(files), []string{"r2/b.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+	// The stats of the dropped file are still sent.
+	if stats.FileCount != 2 {
+		t.Errorf("got %d files in stats, want 2", stats.FileCount)
+	}
+}
+
+// doneBackend closes done after its search.
+type doneBackend struct {
+	*fakeBackend
+	done chan struct{}
+}
+
+func (b *doneBackend) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	defer close(b.done)
+	return b.fakeBackend.StreamSearch(ctx, q, opts, sender)
+}
+
+// blockingBackend waits for wait before its search.
+type blockingBackend struct {
+	fakeBackend
+	wait chan struct{}
+}
+
+func (b *blockingBackend) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	<-b.wait
+	return b.fakeBackend.StreamSearch(ctx, q, opts, sender)
+}
+
+func TestList(t *testing.T) {
+	s := New(
+		&fakeBackend{name: "a", repos: []*zoekt.RepoListEntry{
+			{Repository: zoekt.Repository{Name: "r1"}, Stats: zoekt.RepoStats{Documents: 1}},
+		}},
+		&fakeBackend{name: "b", repos: []*zoekt.RepoListEntry{
+			{Repository: zoekt.Repository{Name: "r1"}, Stats: zoekt.RepoStats{Documents: 2}},
+			{Repository: zoekt.Repository{Name: "r2"}, Stats: zoekt.RepoStats{Documents: 3}},
+		}},
+		&fakeBackend{name: "broken", err: errors.New("connection refused")},
+	)
+
+	rl, err := s.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, e := range rl.Repos {
+		got[e.Repository.Name] = e.Stats.Documents
+	}
+	if d := cmp.Diff(map[string]int{"r1": 3, "r2": 3}, got); d != "" {
+		t.Errorf("repos mismatch (-want +got):\n%s", d)
+	}
+	if rl.Crashes != 1 {
+		t.Errorf("got %d crashes, want 1", rl.Crashes)
+	}
+}
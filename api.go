@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/zoekt/query"
@@ -74,6 +75,37 @@ type FileMatch struct {
 
 	// Commit SHA1 (hex) of the (sub)repo holding the file.
 	Version string
+
+	// Commit is set if the match is a commit message or diff rather than a
+	// file.
+	Commit *Commit `json:",omitempty"`
+
+	// Duplicates lists the other files with the same content, if
+	// SearchOptions.CollapseDuplicates is set.
+	Duplicates []DuplicateFile `json:",omitempty"`
+}
+
+// DuplicateFile is a file whose content equals that of a FileMatch.
+type DuplicateFile struct {
+	FileName     string
+	Repository   string
+	RepositoryID uint32
+	Branches     []string
+
+	// SubRepositoryName and SubRepositoryPath are as in FileMatch.
+	SubRepositoryName string
+	SubRepositoryPath string
+
+	// Commit SHA1 (hex) of the (sub)repo holding the file.
+	Version string
+}
+
+// Commit describes the commit of a commit message document.
+type Commit struct {
+	// SHA1 (hex) of the commit.
+	SHA    string
+	Author string
+	Date   time.Time
 }
 
 // ChunkMatch is a set of non-overlapping matches within a contiguous range of
@@ -98,6 +130,11 @@ type ChunkMatch struct {
 	// its length will equal that of Ranges. Any of its elements may be nil.
 	SymbolInfo []*Symbol
 
+	// Submatches are the captured groups of each of Ranges, if
+	// SearchOptions.CaptureGroups is set. If it is non-nil, its length
+	// will equal that of Ranges.
+	Submatches [][]Submatch `json:",omitempty"`
+
 	Score      float64
 	DebugScore string
 }
@@ -160,6 +197,22 @@ type LineFragmentMatch struct {
 	MatchLength int
 
 	SymbolInfo *Symbol
+
+	// Submatches are the parts of the match captured by the groups of a
+	// regexp, if SearchOptions.CaptureGroups is set.
+	Submatches []Submatch `json:",omitempty"`
+}
+
+// Submatch is the text captured by a group of a regular expression.
+type Submatch struct {
+	// Group is the number of the capture group, starting at 1.
+	Group int
+
+	// Offset from file start, in bytes.
+	Offset uint32
+
+	// Value is the captured text.
+	Value string
 }
 
 // Stats contains interesting numbers on the search
@@ -257,6 +310,75 @@ func (s *Stats) Zero() bool {
 		s.RegexpsConsidered > 0)
 }
 
+// Facets counts the matching files by some of their properties, see
+// SearchOptions.Facets.
+type Facets struct {
+	Languages    map[string]int
+	Repositories map[string]int
+	Branches     map[string]int
+
+	// Directories counts files by their top-level directory. Files at the
+	// root of the repository are counted under "".
+	Directories map[string]int
+}
+
+// Add adds the counts of o to f.
+func (f *Facets) Add(o *Facets) {
+	if o == nil {
+		return
+	}
+	addCounts(&f.Languages, o.Languages)
+	addCounts(&f.Repositories, o.Repositories)
+	addCounts(&f.Branches, o.Branches)
+	addCounts(&f.Directories, o.Directories)
+}
+
+func addCounts(dst *map[string]int, src map[string]int) {
+	if len(src) == 0 {
+		return
+	}
+	if *dst == nil {
+		*dst = make(map[string]int, len(src))
+	}
+	for k, v := range src {
+		(*dst)[k] += v
+	}
+}
+
+// AddCaptures adds the capture counts of src, see SearchResult.Captures, to
+// dst.
+func AddCaptures(dst *map[int]map[string]int, src map[int]map[string]int) {
+	for group, counts := range src {
+		if *dst == nil {
+			*dst = make(map[int]map[string]int, len(src))
+		}
+		m := (*dst)[group]
+		addCounts(&m, counts)
+		(*dst)[group] = m
+	}
+}
+
+// addFile counts a file in f.
+func (f *Facets) addFile(repository, fileName, language string, branches []string) {
+	incCount(&f.Languages, language)
+	incCount(&f.Repositories, repository)
+	for _, b := range branches {
+		incCount(&f.Branches, b)
+	}
+	dir, _, found := strings.Cut(fileName, "/")
+	if !found {
+		dir = ""
+	}
+	incCount(&f.Directories, dir)
+}
+
+func incCount(m *map[string]int, k string) {
+	if *m == nil {
+		*m = map[string]int{}
+	}
+	(*m)[k]++
+}
+
 // Progress contains information about the global progress of the running search query.
 // This is used by the frontend to reorder results and emit them when stable.
 // Sourcegraph specific: this is used when querying multiple zoekt-webserver instances.
@@ -284,6 +406,64 @@ type SearchResult struct {
 	// FragmentNames holds a repo => template string map, for
 	// the line number fragment.
 	LineFragments map[string]string
+
+	// CommitURLs holds a repo => template string map, for linking
+	// commit message matches.
+	CommitURLs map[string]string `json:",omitempty"`
+
+	// Explanations describes how each shard evaluated the query. It is only
+	// set if SearchOptions.Explain is true.
+	Explanations []ShardExplanation `json:",omitempty"`
+
+	// Facets is only set if SearchOptions.Facets is true.
+	Facets *Facets `json:",omitempty"`
+
+	// Captures counts the distinct values captured by each group of the
+	// regexps in the query, by group number. It is only set if
+	// SearchOptions.CaptureGroups is true.
+	Captures map[int]map[string]int `json:",omitempty"`
+
+	// NextCursor is set if Files was cut off by
+	// SearchOptions.MaxDocDisplayCount. Passing it as SearchOptions.Cursor
+	// returns the next page of files.
+	NextCursor string `json:",omitempty"`
+}
+
+// ShardExplanation describes how a shard evaluated a query.
+type ShardExplanation struct {
+	// Shard names the index file.
+	Shard string
+
+	// Query is the query after simplification for this shard.
+	Query string
+
+	// MatchTree is the pruned match tree used to find documents. It is
+	// empty if the shard was skipped.
+	MatchTree string
+
+	// Ngrams describes the ngram lookups for the substrings in the query.
+	Ngrams []NgramExplanation
+
+	// Skipped is the reason the shard was skipped without looking at any
+	// document, eg. "pruned by bloomfilter". It is empty if the shard was
+	// searched.
+	Skipped string
+}
+
+// NgramExplanation describes how candidate matches for a substring are found.
+type NgramExplanation struct {
+	Pattern  string
+	FileName bool
+
+	// Ngrams are the least frequent ngrams of Pattern whose postings are
+	// intersected to find candidates, and Frequencies are their sizes.
+	Ngrams      []string
+	Frequencies []uint32
+
+	// NoMatch is set if the substring can't match in the shard. It is
+	// "bloomfilter" if the bloom filter ruled it out, or "freq=0" if one
+	// of its ngrams doesn't occur.
+	NoMatch string
 }
 
 // RepositoryBranch describes an indexed branch, which is a name
@@ -463,6 +643,12 @@ type RepoStats struct {
 	// ContentBytes is the amount of RAM used for raw content.
 	ContentBytes int64
 
+	// CompressedContentBytes is the part of ContentBytes holding block
+	// compressed file contents, and UncompressedContentBytes is the size of
+	// those contents after decompression.
+	CompressedContentBytes   int64
+	UncompressedContentBytes int64
+
 	// Sourcegraph specific stats below. These are not as efficient to calculate
 	// as the above statistics. We experimentally measured about a 10% slower
 	// shard load time. However, we find these values very useful to track and
@@ -494,6 +680,8 @@ func (s *RepoStats) Add(o *RepoStats) {
 	s.IndexBytes += o.IndexBytes
 	s.Documents += o.Documents
 	s.ContentBytes += o.ContentBytes
+	s.CompressedContentBytes += o.CompressedContentBytes
+	s.UncompressedContentBytes += o.UncompressedContentBytes
 
 	// Sourcegraph specific
 	s.NewLinesCount += o.NewLinesCount
@@ -602,6 +790,57 @@ type SearchOptions struct {
 	// If set, the search results will contain debug information for scoring.
 	DebugScore bool
 
+	// If set, files are scored with BM25, based on how often the query terms
+	// occur in the file and how rare they are in the shard, instead of the
+	// match features and the order of the documents.
+	UseBM25Scoring bool
+
+	// If set, SearchResult.Explanations describes how each shard evaluated
+	// the query, eg. to understand why a query is slow.
+	Explain bool
+
+	// If set, files with the same content, eg. in forks or vendored
+	// copies, are returned as a single FileMatch, the one with the highest
+	// score, which lists the others in Duplicates. Only Search collapses
+	// duplicates, as StreamSearch can't know whether later results
+	// duplicate the ones it has sent.
+	CollapseDuplicates bool
+
+	// If set, matches of regexps with groups carry the captured text in
+	// Submatches, and SearchResult.Captures counts the captured values.
+	// query.Parse optimizes the groups away; parse the query with
+	// query.ParseWithCaptures to keep them.
+	CaptureGroups bool
+
+	// If set, SearchResult.Facets counts the matching files per language,
+	// repository, branch and top-level directory. Files over
+	// ShardMaxMatchCount, ShardRepoMaxMatchCount and ShardMaxImportantMatch
+	// are counted too, at the cost of matching them, but shards that
+	// TotalMaxMatchCount keeps from being searched are not.
+	Facets bool
+
+	// Cursor is a token from SearchResult.NextCursor or Cursor.String. If
+	// set, only files after the cursor are returned. Streamed results have
+	// no NextCursor; clients make one from the last file they kept with
+	// NewCursor.
+	Cursor string
+
+	// SortBy is the order of the files in the results. The default is
+	// SortByScore. Other orders are only honoured across all results by
+	// Search; StreamSearch then sends all files at the end, in a single
+	// SearchResult. Either way, they only order the files found before the
+	// match limits apply, which doesn't change which files those are.
+	SortBy SortOrder
+
+	// If set, the search doesn't use or fill the result cache of the
+	// searcher, if it has one.
+	NoCache bool
+
+	// Client identifies the client making the search, eg. a service or
+	// a user. Searchers that schedule searches share their capacity
+	// fairly between clients.
+	Client string
+
 	// SpanContext is the opentracing span context, if it exists, from the zoekt client
 	SpanContext map[string]string
 }
@@ -0,0 +1,117 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authz restricts searches to the repositories that the identity
+// making a request may see.
+package authz
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/query"
+)
+
+// Authorizer decides which repositories an identity may see.
+type Authorizer interface {
+	// Authorize returns a query matching the repositories that identity
+	// may see, typically a *query.RepoSet or *query.BranchesRepos, or nil
+	// if it may see all of them. The empty identity is an anonymous
+	// request. Callers must not modify the query.
+	Authorize(ctx context.Context, identity string) (query.Q, error)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context for the requests of identity.
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity set by WithIdentity, or the empty
+// string.
+func IdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(identityKey{}).(string)
+	return identity
+}
+
+// HeaderIdentity returns a function that takes the identity of a request
+// from the header name, eg. as set by an authenticating proxy.
+func HeaderIdentity(name string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// TLSIdentity returns the subject common name of the verified client
+// certificate of r, or the empty string.
+func TLSIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// NewSearcher returns a searcher that restricts the searches and lists of s
+// to the repositories that a allows for the identity of their context.
+func NewSearcher(s zoekt.Streamer, a Authorizer) zoekt.Streamer {
+	return &authorizedSearcher{Streamer: s, authorizer: a}
+}
+
+type authorizedSearcher struct {
+	zoekt.Streamer
+
+	authorizer Authorizer
+}
+
+// restrict returns q limited to the repositories allowed for ctx.
+func (s *authorizedSearcher) restrict(ctx context.Context, q query.Q) (query.Q, error) {
+	allowed, err := s.authorizer.Authorize(ctx, IdentityFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if allowed == nil {
+		return q, nil
+	}
+	return query.NewAnd(allowed, q), nil
+}
+
+func (s *authorizedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	q, err := s.restrict(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *authorizedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	q, err := s.restrict(ctx, q)
+	if err != nil {
+		return err
+	}
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+func (s *authorizedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	q, err := s.restrict(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.List(ctx, q, opts)
+}
+
+func (s *authorizedSearcher) String() string {
+	return "authorized(" + s.Streamer.String() + ")"
+}
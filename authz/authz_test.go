@@ -0,0 +1,135 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authz
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/internal/mockSearcher"
+	"github.com/google/zoekt/query"
+	"github.com/google/zoekt/stream"
+)
+
+type streamer struct {
+	*mockSearcher.MockSearcher
+}
+
+func (s streamer) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	sr, err := s.Search(ctx, q, opts)
+	if err != nil {
+		return err
+	}
+	sender.Send(sr)
+	return nil
+}
+
+func writePolicy(t *testing.T, path, policy string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilePolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	writePolicy(t, path, `{
+  "alice": ["private", "public"],
+  "admin": ["*"],
+  "*": ["public"]
+}`, time.Unix(1, 0))
+
+	p, err := NewFilePolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(identity, want string) {
+		t.Helper()
+		q, err := p.Authorize(context.Background(), identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := "<nil>"
+		if q != nil {
+			got = q.String()
+		}
+		if got != want {
+			t.Errorf("%q: got %s, want %s", identity, got, want)
+		}
+	}
+	check("alice", "(reposet private public)")
+	check("admin", "<nil>")
+	check("bob", "(reposet public)")
+	check("", "(reposet public)")
+
+	// Changes are picked up, and invalid policies are ignored.
+	writePolicy(t, path, `{"alice": ["public"]}`, time.Unix(2, 0))
+	check("alice", "(reposet public)")
+	check("bob", "(reposet )")
+
+	writePolicy(t, path, `{`, time.Unix(3, 0))
+	check("alice", "(reposet public)")
+
+	if _, err := NewFilePolicy(path); err == nil {
+		t.Error("want an error for an invalid policy")
+	}
+}
+
+type staticAuthorizer map[string]query.Q
+
+func (a staticAuthorizer) Authorize(ctx context.Context, identity string) (query.Q, error) {
+	return a[identity], nil
+}
+
+func TestSearcher(t *testing.T) {
+	allowed := &query.RepoSet{Set: map[string]bool{"public": true}}
+	q := &query.Substring{Pattern: "needle"}
+	a := staticAuthorizer{"alice": nil, "": allowed}
+
+	for _, tc := range []struct {
+		identity string
+		want     query.Q
+	}{
+		{"alice", q},
+		{"", query.NewAnd(allowed, q)},
+	} {
+		s := NewSearcher(streamer{&mockSearcher.MockSearcher{
+			WantSearch:   tc.want,
+			SearchResult: &zoekt.SearchResult{},
+			WantList:     tc.want,
+			RepoList:     &zoekt.RepoList{},
+		}}, a)
+
+		ctx := WithIdentity(context.Background(), tc.identity)
+		if _, err := s.Search(ctx, q, &zoekt.SearchOptions{}); err != nil {
+			t.Errorf("%q: Search: %v", tc.identity, err)
+		}
+		sent := 0
+		if err := s.StreamSearch(ctx, q, &zoekt.SearchOptions{}, stream.SenderFunc(func(*zoekt.SearchResult) { sent++ })); err != nil || sent != 1 {
+			t.Errorf("%q: StreamSearch: sent %d results, err %v", tc.identity, sent, err)
+		}
+		if _, err := s.List(ctx, q, nil); err != nil {
+			t.Errorf("%q: List: %v", tc.identity, err)
+		}
+	}
+}
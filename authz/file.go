@@ -0,0 +1,117 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/zoekt/query"
+)
+
+// allIdentities is the policy entry for identities without their own entry,
+// and the repository name that allows all repositories.
+const allIdentities = "*"
+
+// FilePolicy is an Authorizer that reads the repositories of each identity
+// from a JSON file, eg.
+//
+//	{
+//	  "alice": ["github.com/org/private", "github.com/org/public"],
+//	  "admin": ["*"],
+//	  "*": ["github.com/org/public"]
+//	}
+//
+// The entry "*" applies to identities without their own entry, including
+// anonymous requests; without it they see no repositories. The repository
+// "*" allows all repositories. The file is read again when it changes.
+type FilePolicy struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	allowed map[string]query.Q
+}
+
+// NewFilePolicy returns the policy in the file at path.
+func NewFilePolicy(path string) (*FilePolicy, error) {
+	p := &FilePolicy{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reload reads the file if it changed since the last call. It must be called
+// with mu held, or before p is shared.
+func (p *FilePolicy) reload() error {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	if p.allowed != nil && fi.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	var policy map[string][]string
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return fmt.Errorf("authz: invalid policy %s: %w", p.path, err)
+	}
+
+	allowed := make(map[string]query.Q, len(policy))
+	for identity, repos := range policy {
+		set := make(map[string]bool, len(repos))
+		for _, r := range repos {
+			set[r] = true
+		}
+		if set[allIdentities] {
+			allowed[identity] = nil
+		} else {
+			allowed[identity] = &query.RepoSet{Set: set}
+		}
+	}
+	p.allowed = allowed
+	p.modTime = fi.ModTime()
+	return nil
+}
+
+// Authorize implements Authorizer.
+func (p *FilePolicy) Authorize(ctx context.Context, identity string) (query.Q, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.reload(); err != nil {
+		// Keep the last valid policy, rather than failing all
+		// requests while the file is rewritten.
+		log.Printf("authz: using the last valid policy: %v", err)
+	}
+
+	if q, ok := p.allowed[identity]; ok {
+		return q, nil
+	}
+	if q, ok := p.allowed[allIdentities]; ok {
+		return q, nil
+	}
+	return &query.RepoSet{Set: map[string]bool{}}, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Parameters of the BM25 scoring function, see
+// https://en.wikipedia.org/wiki/Okapi_BM25.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// bm25Scorer scores documents of a shard with BM25, for
+// SearchOptions.UseBM25Scoring. The terms are the content atoms of the
+// query, and the term frequency is the number of matches of an atom in the
+// document.
+//
+// The index doesn't store the number of documents containing a term, so it
+// is estimated from the posting list size of the rarest ngram of the term.
+// This overestimates the number of documents, since a document can contain
+// an ngram more than once. Regular expressions have no ngrams, so their IDF
+// is 1.
+type bm25Scorer struct {
+	d *indexData
+
+	avgDocLen float64
+
+	// cached IDF of each substring atom
+	idf map[*substrMatchTree]float64
+}
+
+func newBM25Scorer(d *indexData) *bm25Scorer {
+	n := d.numDocs()
+	avg := 1.0
+	if n > 0 && d.boundaries[n] > 0 {
+		avg = float64(d.boundaries[n]) / float64(n)
+	}
+	return &bm25Scorer{
+		d:         d,
+		avgDocLen: avg,
+		idf:       map[*substrMatchTree]float64{},
+	}
+}
+
+// docFrequency estimates the number of documents containing the pattern
+// of t.
+func (s *bm25Scorer) docFrequency(t *substrMatchTree) uint32 {
+	numDocs := s.d.numDocs()
+	if utf8.RuneCountInString(t.query.Pattern) < ngramSize {
+		return numDocs
+	}
+
+	est := numDocs
+	for _, o := range splitNGrams([]byte(t.query.Pattern)) {
+		var freq uint32
+		if t.caseSensitive {
+			freq = s.d.ngramFrequency(o.ngram, false)
+		} else {
+			for _, v := range generateCaseNgrams(o.ngram) {
+				freq += s.d.ngramFrequency(v, false)
+			}
+		}
+		if freq < est {
+			est = freq
+		}
+	}
+	return est
+}
+
+func (s *bm25Scorer) substrIDF(t *substrMatchTree) float64 {
+	if idf, ok := s.idf[t]; ok {
+		return idf
+	}
+	n := float64(s.d.numDocs())
+	df := float64(s.docFrequency(t))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	s.idf[t] = idf
+	return idf
+}
+
+// score adds the BM25 score of docID for the atoms of mt that matched to
+// fileMatch.
+func (s *bm25Scorer) score(fileMatch *FileMatch, docID uint32, mt matchTree, known map[matchTree]bool, debug bool) {
+	docLen := float64(s.d.boundaries[docID+1] - s.d.boundaries[docID])
+	norm := bm25K1 * (1 - bm25B + bm25B*docLen/s.avgDocLen)
+
+	total := 0.0
+	visitMatches(mt, known, func(mt matchTree) {
+		var tf int
+		var idf float64
+		var term string
+		switch t := mt.(type) {
+		case *substrMatchTree:
+			if t.fileName {
+				return
+			}
+			tf, idf, term = len(t.current), s.substrIDF(t), t.query.Pattern
+		case *regexpMatchTree:
+			if t.fileName {
+				return
+			}
+			tf, idf, term = len(t.found), 1, t.regexp.String()
+		default:
+			return
+		}
+		if tf == 0 {
+			return
+		}
+
+		termScore := idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+		if debug {
+			fileMatch.Debug += fmt.Sprintf("bm25(%q tf=%d idf=%.2f), ", term, tf, idf)
+		}
+		total += termScore
+	})
+	fileMatch.addScore("bm25", total, debug)
+}
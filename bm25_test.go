@@ -0,0 +1,87 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestBM25(t *testing.T) {
+	filler := strings.Repeat("lorem ipsum dolor sit amet\n", 20)
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		// Without BM25, earlier documents rank higher.
+		Document{Name: "f1", Content: []byte(filler + "needle\n")},
+		Document{Name: "f2", Content: []byte("needle needle needle\n")},
+		Document{Name: "f3", Content: []byte(filler + "needle haystack\n")},
+		Document{Name: "f4", Content: []byte("zebra haystack haystack haystack\n")},
+		Document{Name: "f5", Content: []byte("haystack haystack haystack haystack\n")},
+	)
+	searcher := searcherForTest(t, b)
+
+	search := func(q query.Q, opts SearchOptions) []FileMatch {
+		t.Helper()
+		res, err := searcher.Search(context.Background(), q, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SortFilesByScore(res.Files)
+		return res.Files
+	}
+
+	needle := &query.Substring{Pattern: "needle"}
+
+	// Short documents with many matches rank first.
+	files := search(needle, SearchOptions{UseBM25Scoring: true, DebugScore: true})
+	if len(files) != 3 || files[0].FileName != "f2" {
+		t.Fatalf("got %v, want f2 first", files)
+	}
+	if !strings.Contains(files[0].Debug, `bm25("needle" tf=3`) {
+		t.Errorf("got debug %q, want term details", files[0].Debug)
+	}
+
+	// "zebra" is rarer than "needle", so it weighs more.
+	idf := func(f FileMatch, term string) float64 {
+		t.Helper()
+		i := strings.Index(f.Debug, fmt.Sprintf("bm25(%q", term))
+		if i < 0 {
+			t.Fatalf("%s: no score for %q in %q", f.FileName, term, f.Debug)
+		}
+		var tf int
+		var idf float64
+		if _, err := fmt.Sscanf(f.Debug[i:], fmt.Sprintf("bm25(%q tf=%%d idf=%%f)", term), &tf, &idf); err != nil {
+			t.Fatal(err)
+		}
+		return idf
+	}
+	q := query.NewOr(needle, &query.Substring{Pattern: "zebra"})
+	files = search(q, SearchOptions{UseBM25Scoring: true, DebugScore: true})
+	scores := map[string]FileMatch{}
+	for _, f := range files {
+		scores[f.FileName] = f
+	}
+	if got, want := idf(scores["f4"], "zebra"), idf(scores["f1"], "needle"); got <= want {
+		t.Errorf("got idf %f for zebra, want more than %f for needle", got, want)
+	}
+
+	files = search(needle, SearchOptions{})
+	if files[0].FileName != "f1" {
+		t.Errorf("got %s first without BM25, want f1", files[0].FileName)
+	}
+}
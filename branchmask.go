@@ -0,0 +1,114 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import "math/bits"
+
+// branchMask is a set of branches of a repository. Bit i%64 of word i/64 is
+// set if the set contains branch i. Zoekt by convention represents the
+// default branch as the lowest bit. Missing trailing words are zero, so masks
+// of different lengths can be combined.
+type branchMask []uint64
+
+// set adds branch i to the mask.
+func (m *branchMask) set(i int) {
+	w := i / 64
+	for len(*m) <= w {
+		*m = append(*m, 0)
+	}
+	(*m)[w] |= uint64(1) << uint(i%64)
+}
+
+// word returns the i-th 64-bit word of the mask.
+func (m branchMask) word(i int) uint64 {
+	if i < len(m) {
+		return m[i]
+	}
+	return 0
+}
+
+// intersects returns true if m and o have a branch in common.
+func (m branchMask) intersects(o branchMask) bool {
+	for i := 0; i < len(m) && i < len(o); i++ {
+		if m[i]&o[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// and returns the branches in both m and o.
+func (m branchMask) and(o branchMask) branchMask {
+	n := len(m)
+	if len(o) < n {
+		n = len(o)
+	}
+	out := make(branchMask, n)
+	for i := range out {
+		out[i] = m[i] & o[i]
+	}
+	return out
+}
+
+// first returns the lowest branch index in the mask, or -1 if it is empty.
+func (m branchMask) first() int {
+	for w, word := range m {
+		if word != 0 {
+			return w*64 + bits.TrailingZeros64(word)
+		}
+	}
+	return -1
+}
+
+// forEach calls f for each branch index in the mask in increasing order.
+func (m branchMask) forEach(f func(i int)) {
+	for w, word := range m {
+		for word != 0 {
+			f(w*64 + bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+}
+
+// wideBranchMask returns the words after the first one of the branch mask of
+// docID. It is empty unless the shard contains a repository with more than 64
+// branches.
+func (d *indexData) wideBranchMask(docID uint32) []uint64 {
+	w := d.branchMaskWideWords
+	if w == 0 {
+		return nil
+	}
+	return d.fileBranchMasksWide[int(docID)*w : int(docID+1)*w]
+}
+
+// docBranchMask returns the branches docID is part of.
+func (d *indexData) docBranchMask(docID uint32) branchMask {
+	wide := d.wideBranchMask(docID)
+	m := make(branchMask, 0, 1+len(wide))
+	m = append(m, d.fileBranchMasks[docID])
+	return append(m, wide...)
+}
+
+// docInBranches returns true if docID is part of any of the branches in
+// want. It does not allocate, so it can be used when iterating over documents.
+func (d *indexData) docInBranches(docID uint32, want branchMask) bool {
+	if len(want) == 0 {
+		return false
+	}
+	if d.fileBranchMasks[docID]&want[0] != 0 {
+		return true
+	}
+	return branchMask(d.wideBranchMask(docID)).intersects(want[1:])
+}
@@ -98,6 +98,29 @@ type Options struct {
 	// last run.
 	IsDelta bool
 
+	// CompressContents stores file contents compressed in blocks, trading
+	// search CPU for disk and page cache usage.
+	CompressContents bool
+
+	// RankConfig orders the documents within a shard. If nil, documents
+	// are ordered by a built-in ranking.
+	RankConfig *RankConfig
+
+	// RankConfigFile is the file RankConfig was loaded from by the
+	// -rank_config flag.
+	RankConfigFile string
+
+	// CommitMessages is the number of commits per branch whose messages
+	// the indexer adds as commit documents, see
+	// gitindex.Options.CommitMessages. The builder doesn't use it, but
+	// changing it changes the hash of the options.
+	CommitMessages int
+
+	// DiffWindow is the window of the commits whose diffs the indexer
+	// adds, see gitindex.Options.DiffWindow. Like CommitMessages, it only
+	// changes the hash of the options.
+	DiffWindow time.Duration
+
 	// changedOrRemovedFiles is a list of file paths that have been changed or removed
 	// since the last indexing job for this repository. These files will be tombstoned
 	// in the older shards for this repository.
@@ -111,6 +134,10 @@ type HashOptions struct {
 	cTags            string
 	cTagsMustSucceed bool
 	largeFiles       []string
+	compressContents bool
+	rankConfig       string
+	commitMessages   int
+	diffWindow       time.Duration
 }
 
 func (o *Options) HashOptions() HashOptions {
@@ -120,6 +147,10 @@ func (o *Options) HashOptions() HashOptions {
 		cTags:            o.CTags,
 		cTagsMustSucceed: o.CTagsMustSucceed,
 		largeFiles:       o.LargeFiles,
+		compressContents: o.CompressContents,
+		rankConfig:       o.RankConfig.hash(),
+		commitMessages:   o.CommitMessages,
+		diffWindow:       o.DiffWindow,
 	}
 }
 
@@ -132,6 +163,19 @@ func (o *Options) GetHash() string {
 	hasher.Write([]byte(fmt.Sprintf("%d", h.sizeMax)))
 	hasher.Write([]byte(fmt.Sprintf("%q", h.largeFiles)))
 	hasher.Write([]byte(fmt.Sprintf("%t", h.disableCTags)))
+	// Only hashed if set, so that existing shards keep their hash.
+	if h.compressContents {
+		hasher.Write([]byte("compressContents"))
+	}
+	if h.rankConfig != "" {
+		hasher.Write([]byte(h.rankConfig))
+	}
+	if h.commitMessages != 0 {
+		hasher.Write([]byte(fmt.Sprintf("commitMessages:%d", h.commitMessages)))
+	}
+	if h.diffWindow != 0 {
+		hasher.Write([]byte(fmt.Sprintf("diffWindow:%s", h.diffWindow)))
+	}
 
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
@@ -155,6 +199,25 @@ func (f largeFilesFlag) Set(value string) error {
 	return nil
 }
 
+type rankConfigFlag struct{ *Options }
+
+func (f rankConfigFlag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	return f.RankConfigFile
+}
+
+func (f rankConfigFlag) Set(value string) error {
+	c, err := LoadRankConfig(value)
+	if err != nil {
+		return err
+	}
+	f.RankConfig = c
+	f.RankConfigFile = value
+	return nil
+}
+
 // Flags adds flags for build options to fs. It is the "inverse" of Args.
 func (o *Options) Flags(fs *flag.FlagSet) {
 	x := *o
@@ -166,6 +229,8 @@ func (o *Options) Flags(fs *flag.FlagSet) {
 	fs.StringVar(&o.IndexDir, "index", x.IndexDir, "directory for search indices")
 	fs.BoolVar(&o.CTagsMustSucceed, "require_ctags", x.CTagsMustSucceed, "If set, ctags calls must succeed.")
 	fs.Var(largeFilesFlag{o}, "large_file", "A glob pattern where matching files are to be index regardless of their size. You can add multiple patterns by setting this more than once.")
+	fs.BoolVar(&o.CompressContents, "compress_contents", x.CompressContents, "If set, file contents are stored compressed in the index.")
+	fs.Var(rankConfigFlag{o}, "rank_config", "A YAML `file` that configures the order of documents in a shard, see RankConfig.")
 
 	// Sourcegraph specific
 	fs.BoolVar(&o.DisableCTags, "disable_ctags", x.DisableCTags, "If set, ctags will not be called.")
@@ -203,6 +268,14 @@ func (o *Options) Args() []string {
 		args = append(args, "-large_file", a)
 	}
 
+	if o.CompressContents {
+		args = append(args, "-compress_contents")
+	}
+
+	if o.RankConfigFile != "" {
+		args = append(args, "-rank_config", o.RankConfigFile)
+	}
+
 	// Sourcegraph specific
 	if o.DisableCTags {
 		args = append(args, "-disable_ctags")
@@ -310,6 +383,11 @@ func (o *Options) shardNameVersion(version, n int) string {
 		fmt.Sprintf("%s_v%d.%05d.zoekt", abs, version, n))
 }
 
+// shardSetName returns the path of the zoekt.ShardSet of the repository.
+func (o *Options) shardSetName() string {
+	return strings.TrimSuffix(o.shardName(0), ".00000.zoekt") + ".shards"
+}
+
 type IndexState string
 
 const (
@@ -725,6 +803,17 @@ func (b *Builder) Finish() error {
 		}
 	}
 
+	// Before renaming, so that readers wait for all renames.
+	if err := b.writeShardSet(artifactPaths, oldShards); err != nil {
+		for tmp := range artifactPaths {
+			os.Remove(tmp)
+		}
+		b.finishedShards = map[string]string{}
+		b.buildError = fmt.Errorf("writing shard set: %w", err)
+		return b.buildError
+	}
+	delete(toDelete, b.opts.shardSetName())
+
 	for tmp, final := range artifactPaths {
 		if err := os.Rename(tmp, final); err != nil {
 			b.buildError = err
@@ -759,6 +848,55 @@ func (b *Builder) Finish() error {
 	return b.buildError
 }
 
+// writeShardSet records the shards of the repository once artifactPaths,
+// which maps temporary to final paths, are renamed, see zoekt.ShardSet.
+func (b *Builder) writeShardSet(artifactPaths map[string]string, oldShards []string) error {
+	temps := make(map[string]string, len(artifactPaths))
+	var shards []string
+	if b.opts.IsDelta {
+		// Delta shards are added to the older shards.
+		shards = append(shards, oldShards...)
+	}
+	for tmp, final := range artifactPaths {
+		temps[final] = tmp
+		if strings.HasSuffix(final, ".zoekt") {
+			shards = append(shards, final)
+		}
+	}
+
+	set := zoekt.ShardSet{Shards: make(map[string]zoekt.ShardState, len(shards))}
+	for _, shard := range shards {
+		if strings.HasPrefix(filepath.Base(shard), "compound-") {
+			// Compound shards are shared with other repositories.
+			return nil
+		}
+
+		path := shard
+		if tmp, ok := temps[shard]; ok {
+			path = tmp
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		state := zoekt.ShardState{ModTime: fi.ModTime()}
+
+		// Renaming keeps the modification times. Full builds remove the
+		// .meta files of older shards.
+		meta, ok := temps[shard+".meta"]
+		if !ok && b.opts.IsDelta {
+			meta, ok = shard+".meta", true
+		}
+		if ok {
+			if fi, err := os.Lstat(meta); err == nil {
+				state.MetaModTime = fi.ModTime()
+			}
+		}
+		set.Shards[filepath.Base(shard)] = state
+	}
+	return zoekt.WriteShardSet(b.opts.shardSetName(), &set)
+}
+
 // BranchNamesEqual compares the given zoekt.RepositoryBranch slices, and returns true
 // iff both slices specify the same set of branch names in the same order.
 func BranchNamesEqual(a, b []zoekt.RepositoryBranch) bool {
@@ -915,6 +1053,10 @@ func rank(d *zoekt.Document, origIdx int) []float64 {
 }
 
 func sortDocuments(todo []*zoekt.Document) {
+	sortDocumentsBy(todo, rank)
+}
+
+func sortDocumentsBy(todo []*zoekt.Document, rank func(d *zoekt.Document, origIdx int) []float64) {
 	rs := make([]rankedDoc, 0, len(todo))
 	for i, t := range todo {
 		rd := rankedDoc{t, rank(t, i)}
@@ -956,7 +1098,7 @@ func (b *Builder) buildShard(todo []*zoekt.Document, nextShardNum int) (*finishe
 	if err != nil {
 		return nil, err
 	}
-	sortDocuments(todo)
+	sortDocumentsBy(todo, b.opts.RankConfig.rank)
 	for _, t := range todo {
 		if err := shardBuilder.Add(*t); err != nil {
 			return nil, err
@@ -978,6 +1120,7 @@ func (b *Builder) newShardBuilder() (*zoekt.IndexBuilder, error) {
 	}
 	shardBuilder.IndexTime = b.indexTime
 	shardBuilder.ID = b.id
+	shardBuilder.CompressContents = b.opts.CompressContents
 	return shardBuilder, nil
 }
 
@@ -3,6 +3,7 @@ package build
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -116,6 +117,11 @@ func TestFlags(t *testing.T) {
 		want: Options{
 			LargeFiles: []string{"*.md", "*.yaml"},
 		},
+	}, {
+		args: []string{"-compress_contents"},
+		want: Options{
+			CompressContents: true,
+		},
 	}}
 
 	ignored := []cmp.Option{
@@ -483,6 +489,10 @@ func TestBuilder_DeltaShardsBuildsShouldErrorOnIndexOptionsMismatch(t *testing.T
 			name:    "update option LargeFiles to non default",
 			options: func(options *Options) { options.LargeFiles = []string{"-large_file", "*.md", "-large_file", "*.yaml"} },
 		},
+		{
+			name:    "update option CompressContents to non default",
+			options: func(options *Options) { options.CompressContents = true },
+		},
 	} {
 		test := test
 
@@ -844,3 +854,46 @@ func createTestCompoundShard(t *testing.T, indexDir string, repositories []zoekt
 		t.Fatal(err)
 	}
 }
+
+func TestBuilder_ShardSet(t *testing.T) {
+	indexDir := t.TempDir()
+	repo := zoekt.Repository{
+		Name:     "repo",
+		ID:       1,
+		Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "v1"}},
+	}
+
+	checkShardSet := func(shards []string) {
+		t.Helper()
+		set, err := zoekt.ReadShardSet(filepath.Join(indexDir, fmt.Sprintf("repo_v%d.shards", zoekt.IndexFormatVersion)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !set.Complete(shards) {
+			t.Errorf("shard set %v doesn't match the shards %v", set.Shards, shards)
+		}
+	}
+
+	shards := createTestShard(t, indexDir, repo, 2, func(o *Options) {
+		o.DisableCTags = true
+	})
+	checkShardSet(shards)
+
+	// Delta builds update the .meta files of the older shards.
+	repo.Branches[0].Version = "v2"
+	shards = createTestShard(t, indexDir, repo, 1, func(o *Options) {
+		o.IsDelta = true
+		o.DisableCTags = true
+	})
+	if len(shards) < 3 {
+		t.Fatalf("expected at least 3 shards, got %d (%s)", len(shards), strings.Join(shards, ", "))
+	}
+	checkShardSet(shards)
+
+	// Full builds remove the older shards, but not the new shard set.
+	repo.Branches[0].Version = "v3"
+	shards = createTestShard(t, indexDir, repo, 1, func(o *Options) {
+		o.DisableCTags = true
+	})
+	checkShardSet(shards)
+}
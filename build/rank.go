@@ -0,0 +1,151 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bmatcuk/doublestar"
+	"github.com/google/zoekt"
+	"gopkg.in/yaml.v3"
+)
+
+// RankConfigFile is the path of the ranking config within a repository.
+const RankConfigFile = ".zoekt/rank.yaml"
+
+// RankConfig configures the order of the documents within a shard.
+// Documents that come first are found first and score higher.
+//
+// Every rule gives each document a value, and documents are ordered by
+// comparing their values rule by rule, smaller first. Documents that are
+// equal under all rules keep the order in which they were added. For
+// example, the following puts generated code last and otherwise prefers
+// files with many symbols:
+//
+//	rules:
+//	  - patterns: ["**/*.pb.go", "**/*_generated.go"]
+//	  - patterns: ["vendor/**", "third_party/**"]
+//	  - patterns: ["docs/**"]
+//	    weight: -1
+//	  - signal: symbols
+type RankConfig struct {
+	Rules []RankRule `yaml:"rules" json:"rules"`
+}
+
+// RankRule is a single rule of a RankConfig. Exactly one of Patterns and
+// Signal must be set.
+type RankRule struct {
+	// Patterns are glob patterns, including ** for any number of
+	// directories. Documents whose path matches one of them get Weight,
+	// others get 0.
+	Patterns []string `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+
+	// Signal names a property of the document: "symbols" puts documents
+	// with many symbols first, "branches" documents in many branches, and
+	// "content_length" and "name_length" put short documents first. The
+	// value is scaled by Weight.
+	Signal string `yaml:"signal,omitempty" json:"signal,omitempty"`
+
+	// Weight is the value of the rule. Negative weights move documents
+	// forward. Zero means 1.
+	Weight float64 `yaml:"weight,omitempty" json:"weight,omitempty"`
+}
+
+var rankSignals = map[string]func(d *zoekt.Document) float64{
+	"symbols":        func(d *zoekt.Document) float64 { return 1.0 - squashRange(len(d.Symbols)) },
+	"content_length": func(d *zoekt.Document) float64 { return squashRange(len(d.Content)) },
+	"name_length":    func(d *zoekt.Document) float64 { return squashRange(len(d.Name)) },
+	"branches":       func(d *zoekt.Document) float64 { return 1.0 - squashRange(len(d.Branches)) },
+}
+
+// ParseRankConfig reads a RankConfig in YAML format.
+func ParseRankConfig(r io.Reader) (*RankConfig, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	var c RankConfig
+	if err := dec.Decode(&c); err != nil && err != io.EOF {
+		return nil, err
+	}
+	for i, rule := range c.Rules {
+		if (len(rule.Patterns) > 0) == (rule.Signal != "") {
+			return nil, fmt.Errorf("rule %d: must have either patterns or signal", i)
+		}
+		if _, ok := rankSignals[rule.Signal]; rule.Signal != "" && !ok {
+			return nil, fmt.Errorf("rule %d: unknown signal %q", i, rule.Signal)
+		}
+	}
+	return &c, nil
+}
+
+// LoadRankConfig reads the RankConfig in the YAML file at path.
+func LoadRankConfig(path string) (*RankConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseRankConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// hash returns a canonical form of c for Options.GetHash. It is empty if c is
+// nil.
+func (c *RankConfig) hash() string {
+	if c == nil {
+		return ""
+	}
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+// rank is like the package level rank, but orders by the rules of c. A nil
+// config uses the default order.
+func (c *RankConfig) rank(d *zoekt.Document, origIdx int) []float64 {
+	if c == nil {
+		return rank(d, origIdx)
+	}
+
+	r := make([]float64, 0, len(c.Rules)+1)
+	for _, rule := range c.Rules {
+		w := rule.Weight
+		if w == 0 {
+			w = 1
+		}
+
+		if rule.Signal != "" {
+			r = append(r, w*rankSignals[rule.Signal](d))
+			continue
+		}
+
+		v := 0.0
+		for _, p := range rule.Patterns {
+			if m, _ := doublestar.Match(p, d.Name); m {
+				v = w
+				break
+			}
+		}
+		r = append(r, v)
+	}
+
+	// Preserve original ordering.
+	return append(r, squashRange(origIdx))
+}
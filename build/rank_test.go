@@ -0,0 +1,104 @@
+package build
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/zoekt"
+)
+
+func TestParseRankConfig(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		wantErr string
+	}{
+		{in: ""},
+		{in: "rules:\n  - patterns: ['**/*.go']\n    weight: 2\n  - signal: symbols\n"},
+		{in: "rules:\n  - weight: 2\n", wantErr: "either patterns or signal"},
+		{in: "rules:\n  - patterns: ['a']\n    signal: symbols\n", wantErr: "either patterns or signal"},
+		{in: "rules:\n  - signal: stars\n", wantErr: "unknown signal"},
+		{in: "rules:\n  - pattern: ['a']\n", wantErr: "not found"},
+	} {
+		_, err := ParseRankConfig(strings.NewReader(tc.in))
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+		} else if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%q: got error %v, want %q", tc.in, err, tc.wantErr)
+		}
+	}
+}
+
+func TestRankConfigOrder(t *testing.T) {
+	c, err := ParseRankConfig(strings.NewReader(`
+rules:
+  - patterns: ["gen/**", "**/*.pb.go"]
+  - patterns: ["docs/**"]
+    weight: -1
+  - signal: content_length
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	todo := []*zoekt.Document{
+		{Name: "gen/a.go", Content: []byte("a")},
+		{Name: "b.go", Content: []byte("bbbb")},
+		{Name: "api/c.pb.go", Content: []byte("c")},
+		{Name: "d.go", Content: []byte("dd")},
+		{Name: "docs/e.md", Content: []byte("eeeeeeee")},
+	}
+	sortDocumentsBy(todo, c.rank)
+
+	var got []string
+	for _, d := range todo {
+		got = append(got, d.Name)
+	}
+	want := []string{"docs/e.md", "d.go", "b.go", "gen/a.go", "api/c.pb.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRankConfigHash(t *testing.T) {
+	opts := Options{}
+	opts.SetDefaults()
+	base := opts.GetHash()
+
+	c1, _ := ParseRankConfig(strings.NewReader("rules:\n  - patterns: ['a/**']\n"))
+	c2, _ := ParseRankConfig(strings.NewReader("rules:\n  - patterns: ['b/**']\n"))
+
+	opts.RankConfig = c1
+	h1 := opts.GetHash()
+	opts.RankConfig = c2
+	h2 := opts.GetHash()
+
+	if h1 == base || h2 == base || h1 == h2 {
+		t.Errorf("hashes should differ: base %s, a/** %s, b/** %s", base, h1, h2)
+	}
+}
+
+func TestRankConfigFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rank.yaml")
+	if err := os.WriteFile(path, []byte("rules:\n  - signal: symbols\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var opts Options
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	opts.Flags(fs)
+	if err := fs.Parse([]string{"-rank_config", path}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &RankConfig{Rules: []RankRule{{Signal: "symbols"}}}
+	if !reflect.DeepEqual(opts.RankConfig, want) {
+		t.Errorf("got %+v, want %+v", opts.RankConfig, want)
+	}
+	if got := opts.Args(); !reflect.DeepEqual(got[len(got)-2:], []string{"-rank_config", path}) {
+		t.Errorf("got args %q, want -rank_config %s", got, path)
+	}
+}
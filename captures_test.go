@@ -0,0 +1,96 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestCaptureGroups(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "a.conf", Content: []byte("port=80\nhost=example\nport=443\n")},
+		Document{Name: "b.conf", Content: []byte("port=80\n")})
+
+	q := &query.Regexp{Regexp: mustParseRE(`(port)=([0-9]+)`), Content: true}
+	res := searchForTest(t, b, q)
+	if res.Captures != nil || res.Files[0].LineMatches[0].LineFragments[0].Submatches != nil {
+		t.Fatalf("got captures without asking: %+v", res)
+	}
+
+	res = searchForTest(t, b, q, SearchOptions{CaptureGroups: true})
+	if len(res.Files) != 2 {
+		t.Fatalf("got %d files, want 2", len(res.Files))
+	}
+
+	wantCaptures := map[int]map[string]int{
+		1: {"port": 3},
+		2: {"80": 2, "443": 1},
+	}
+	if d := cmp.Diff(wantCaptures, res.Captures); d != "" {
+		t.Errorf("captures mismatch (-want +got):\n%s", d)
+	}
+
+	for _, f := range res.Files {
+		if f.FileName != "a.conf" {
+			continue
+		}
+		var got [][]Submatch
+		for _, lm := range f.LineMatches {
+			for _, lf := range lm.LineFragments {
+				got = append(got, lf.Submatches)
+			}
+		}
+		want := [][]Submatch{
+			{{Group: 1, Offset: 0, Value: "port"}, {Group: 2, Offset: 5, Value: "80"}},
+			{{Group: 1, Offset: 21, Value: "port"}, {Group: 2, Offset: 26, Value: "443"}},
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("submatches mismatch (-want +got):\n%s", d)
+		}
+	}
+
+	// Chunk matches carry the same submatches, parallel to Ranges.
+	res = searchForTest(t, b, q, SearchOptions{CaptureGroups: true, ChunkMatches: true})
+	if d := cmp.Diff(wantCaptures, res.Captures); d != "" {
+		t.Errorf("chunk captures mismatch (-want +got):\n%s", d)
+	}
+	for _, f := range res.Files {
+		for _, cm := range f.ChunkMatches {
+			if len(cm.Submatches) != len(cm.Ranges) {
+				t.Errorf("%s: got %d submatches for %d ranges", f.FileName, len(cm.Submatches), len(cm.Ranges))
+			}
+		}
+	}
+
+	// ParseWithCaptures keeps the groups.
+	pq, err := query.ParseWithCaptures(`(port)=([0-9]+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = searchForTest(t, b, pq, SearchOptions{CaptureGroups: true})
+	if d := cmp.Diff(wantCaptures, res.Captures); d != "" {
+		t.Errorf("parsed query captures mismatch (-want +got):\n%s", d)
+	}
+
+	// Merging the captures of two shards.
+	AddCaptures(&wantCaptures, res.Captures)
+	if got := wantCaptures[2]["80"]; got != 4 {
+		t.Errorf("got %d after AddCaptures, want 4", got)
+	}
+}
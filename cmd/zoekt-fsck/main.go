@@ -0,0 +1,124 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command zoekt-fsck checks index shards for corruption, eg. truncated
+// files after a disk ran full.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/build"
+)
+
+// checkShard returns an error if the shard at path can't be read or is
+// inconsistent.
+func checkShard(path string) (err error) {
+	defer func() {
+		// Corrupt data can make decoding panic.
+		if e := recover(); e != nil {
+			err = fmt.Errorf("crashed: %v", e)
+		}
+	}()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	iFile, err := zoekt.NewIndexFile(f)
+	if err != nil {
+		return err
+	}
+	defer iFile.Close()
+
+	return zoekt.CheckIndexFile(iFile)
+}
+
+// quarantine moves the shard at path, and its ".meta" file and shard set, to
+// dir.
+func quarantine(path, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	paths, err := zoekt.IndexFilePaths(path)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := os.Rename(p, filepath.Join(dir, filepath.Base(p))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fsck checks the shards in paths, and quarantines bad shards if
+// quarantineDir is set. It returns the number of bad shards.
+func fsck(paths []string, quarantineDir string, verbose bool) int {
+	bad := 0
+	for _, p := range paths {
+		err := checkShard(p)
+		if err == nil {
+			if verbose {
+				fmt.Printf("OK %s\n", p)
+			}
+			continue
+		}
+
+		bad++
+		fmt.Printf("CORRUPT %s: %v\n", p, err)
+		if quarantineDir != "" {
+			if err := quarantine(p, quarantineDir); err != nil {
+				log.Printf("quarantine %s: %v", p, err)
+			}
+		}
+	}
+	return bad
+}
+
+func main() {
+	indexDir := flag.String("index_dir", build.DefaultDir, "check all shards in `directory`")
+	quarantineDir := flag.String("quarantine", "", "move corrupt shards to `directory`")
+	verbose := flag.Bool("v", false, "also print shards without problems")
+	flag.Usage = func() {
+		name := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage:\n\n  %s [option] [SHARD...]\n\n", name)
+		fmt.Fprintf(os.Stderr, "Checks the given shards, or all shards in -index_dir.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		var err error
+		paths, err = filepath.Glob(filepath.Join(*indexDir, "*.zoekt"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		sort.Strings(paths)
+	}
+
+	if bad := fsck(paths, *quarantineDir, *verbose); bad > 0 {
+		log.Printf("%d of %d shards are corrupt", bad, len(paths))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/shards/repo_v16.00000.zoekt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good_v16.00000.zoekt")
+	bad := filepath.Join(dir, "bad_v16.00000.zoekt")
+	if err := os.WriteFile(good, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, data[:len(data)-100], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad+".meta", []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantineDir := filepath.Join(dir, "quarantine")
+	if got := fsck([]string{good, bad}, quarantineDir, false); got != 1 {
+		t.Fatalf("got %d bad shards, want 1", got)
+	}
+
+	for _, p := range []string{bad, bad + ".meta"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s: still present after quarantine: %v", p, err)
+		}
+		if _, err := os.Stat(filepath.Join(quarantineDir, filepath.Base(p))); err != nil {
+			t.Errorf("%s: not quarantined: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(good); err != nil {
+		t.Errorf("good shard was moved: %v", err)
+	}
+}
@@ -38,6 +38,8 @@ func main() {
 		"It also affects name if the indexed repository is under this directory.")
 	isDelta := flag.Bool("delta", false, "whether we should use delta build")
 	deltaShardNumberFallbackThreshold := flag.Uint64("delta_threshold", 0, "upper limit on the number of preexisting shards that can exist before attempting a delta build (0 to disable fallback behavior)")
+	diffWindow := flag.Duration("diff_window", 0, "also index the diffs of the commits made within this duration of the latest commit of each branch, for added: and removed: queries")
+	commitMessages := flag.Int("commit_messages", 0, "also index the messages of the last N commits of each branch, for type:commit queries")
 	flag.Parse()
 
 	// Tune GOMAXPROCS to match Linux container CPU quota.
@@ -89,6 +91,8 @@ func main() {
 			Branches:                          branches,
 			RepoDir:                           dir,
 			DeltaShardNumberFallbackThreshold: *deltaShardNumberFallbackThreshold,
+			CommitMessages:                    *commitMessages,
+			DiffWindow:                        *diffWindow,
 		}
 
 		if err := gitindex.IndexGitRepo(gitOpts); err != nil {
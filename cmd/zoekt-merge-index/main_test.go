@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/google/zoekt"
@@ -26,11 +27,20 @@ func TestMerge(t *testing.T) {
 	}
 	t.Log(testShards)
 
+	// Merging removes the shard sets of the simple shards.
+	setPath := strings.TrimSuffix(testShards[0], ".00000.zoekt") + ".shards"
+	if err := zoekt.WriteShardSet(setPath, &zoekt.ShardSet{}); err != nil {
+		t.Fatal(err)
+	}
+
 	dir := t.TempDir()
 	err = merge(dir, testShards)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if _, err := os.Stat(setPath); !os.IsNotExist(err) {
+		t.Errorf("got %v for the shard set after merging, want it removed", err)
+	}
 
 	ss, err := shards.NewDirectorySearcher(dir)
 	if err != nil {
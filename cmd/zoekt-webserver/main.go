@@ -20,6 +20,8 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
@@ -34,6 +36,8 @@ import (
 	"time"
 
 	"github.com/google/zoekt"
+	"github.com/google/zoekt/aggregate"
+	"github.com/google/zoekt/authz"
 	"github.com/google/zoekt/build"
 	"github.com/google/zoekt/debugserver"
 	"github.com/google/zoekt/internal/profiler"
@@ -119,12 +123,22 @@ func main() {
 
 	listen := flag.String("listen", ":6070", "listen on this address.")
 	index := flag.String("index", build.DefaultDir, "set index directory to use")
+	cacheEntries := flag.Int("cache_entries", 0, "cache the results of this many searches until the index changes. 0 disables the cache.")
+	cacheBytes := flag.Int("cache_bytes", 100<<20, "limit the estimated size of the cached results to this many bytes. 0 means no limit.")
+	maxResidentBytes := flag.Int64("max_resident_bytes", 0, "keep the shards open within this many bytes of memory and mapped files, closing the least recently searched ones. 0 keeps all shards open.")
+	verifyChecksums := flag.Bool("verify_checksums", false, "read each shard completely when loading it to verify its checksums, and skip shards that don't match.")
+	schedConfig := flag.String("sched_config", "", "share the search capacity between clients by the JSON shards.SchedulerOptions in this file.")
+	schedClientHeader := flag.String("sched_client_header", "", "identify the client of searches for -sched_config by this header.")
+	backends := flag.String("backends", "", "instead of searching -index, search the zoekt-webservers (started with -rpc) at these comma-separated host:port addresses and merge their results")
 	html := flag.Bool("html", true, "enable HTML interface")
 	enableRPC := flag.Bool("rpc", false, "enable go/net RPC")
 	print := flag.Bool("print", false, "enable local result URLs")
 	enablePprof := flag.Bool("pprof", false, "set to enable remote profiling.")
 	sslCert := flag.String("ssl_cert", "", "set path to SSL .pem holding certificate.")
 	sslKey := flag.String("ssl_key", "", "set path to SSL .pem holding key.")
+	sslClientCA := flag.String("ssl_client_ca", "", "set path to a .pem holding the CAs of client certificates. Verified clients are identified by the subject of their certificate for -authz_policy.")
+	authzPolicy := flag.String("authz_policy", "", "restrict the repositories that each identity can see to the JSON policy in this file, see authz.FilePolicy. Can't be combined with -rpc, whose requests carry no identity; set it on the -backends front end instead.")
+	authzHeader := flag.String("authz_header", "", "take the identity of requests for -authz_policy from this header, eg. X-Forwarded-User, rather than from client certificates.")
 	hostCustomization := flag.String(
 		"host_customization", "",
 		"specify host customization, as HOST1=QUERY,HOST2=QUERY")
@@ -163,15 +177,37 @@ func main() {
 	// Tune GOMAXPROCS to match Linux container CPU quota.
 	_, _ = maxprocs.Set()
 
-	if err := os.MkdirAll(*index, 0o755); err != nil {
-		log.Fatal(err)
-	}
+	var searcher zoekt.Streamer
+	if *backends != "" {
+		searcher = aggregate.Dial(strings.Split(*backends, ","))
+	} else {
+		if err := os.MkdirAll(*index, 0o755); err != nil {
+			log.Fatal(err)
+		}
 
-	mustRegisterDiskMonitor(*index)
+		mustRegisterDiskMonitor(*index)
 
-	searcher, err := shards.NewDirectorySearcher(*index)
-	if err != nil {
-		log.Fatal(err)
+		opts := shards.Options{
+			CacheEntries:     *cacheEntries,
+			CacheBytes:       *cacheBytes,
+			MaxResidentBytes: *maxResidentBytes,
+			VerifyChecksums:  *verifyChecksums,
+		}
+		if *schedConfig != "" {
+			data, err := os.ReadFile(*schedConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &opts.Scheduler); err != nil {
+				log.Fatalf("invalid -sched_config %s: %v", *schedConfig, err)
+			}
+		}
+
+		var err error
+		searcher, err = shards.NewDirectorySearcherWithOptions(*index, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Sourcegraph: Add logging if debug logging enabled
@@ -196,6 +232,23 @@ func main() {
 	s.Print = *print
 	s.HTML = *html
 	s.RPC = *enableRPC
+	s.ClientHeader = *schedClientHeader
+
+	if *authzPolicy != "" {
+		if *enableRPC {
+			log.Fatal("-authz_policy can't be combined with -rpc: searches from a -backends front end carry no identity, so set -authz_policy there")
+		}
+		policy, err := authz.NewFilePolicy(*authzPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Authorizer = policy
+		if *authzHeader != "" {
+			s.Identity = authz.HeaderIdentity(*authzHeader)
+		} else {
+			s.Identity = authz.TLSIdentity
+		}
+	}
 
 	if *hostCustomization != "" {
 		s.HostCustomQueries = map[string]string{}
@@ -250,6 +303,21 @@ func main() {
 		Handler: handler,
 	}
 
+	if *sslClientCA != "" {
+		pem, err := os.ReadFile(*sslClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("no certificates in %s", *sslClientCA)
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
+
 	go func() {
 		if debug {
 			log.Printf("listening on %v", *listen)
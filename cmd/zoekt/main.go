@@ -46,6 +46,43 @@ func displayMatches(files []zoekt.FileMatch, pat string, withRepo bool, list boo
 	}
 }
 
+// displayDiffs prints the diffs that replace the matches of files by
+// template.
+func displayDiffs(files []zoekt.FileMatch, template string) error {
+	for i := range files {
+		diff, err := zoekt.ReplaceDiff(&files[i], template)
+		if err != nil {
+			return err
+		}
+		fmt.Print(diff)
+	}
+	return nil
+}
+
+func displayExplanations(explanations []zoekt.ShardExplanation) {
+	for _, e := range explanations {
+		fmt.Fprintf(os.Stderr, "%s:\n", e.Shard)
+		if e.Query != "" {
+			fmt.Fprintf(os.Stderr, "  query: %s\n", e.Query)
+		}
+		for _, n := range e.Ngrams {
+			fmt.Fprintf(os.Stderr, "  substr %q (filename=%v):", n.Pattern, n.FileName)
+			for i := range n.Ngrams {
+				fmt.Fprintf(os.Stderr, " %q=%d", n.Ngrams[i], n.Frequencies[i])
+			}
+			if n.NoMatch != "" {
+				fmt.Fprintf(os.Stderr, " no match (%s)", n.NoMatch)
+			}
+			fmt.Fprintln(os.Stderr)
+		}
+		if e.Skipped != "" {
+			fmt.Fprintf(os.Stderr, "  skipped: %s\n", e.Skipped)
+		} else {
+			fmt.Fprintf(os.Stderr, "  matchtree: %s\n", e.MatchTree)
+		}
+	}
+}
+
 func loadShard(fn string, verbose bool) (zoekt.Searcher, error) {
 	f, err := os.Open(fn)
 	if err != nil {
@@ -85,6 +122,10 @@ func main() {
 	verbose := flag.Bool("v", false, "print some background data")
 	withRepo := flag.Bool("r", false, "print the repo before the file name")
 	list := flag.Bool("l", false, "print matching filenames only")
+	explain := flag.Bool("explain", false, "print how each shard evaluated the query to stderr")
+	bm25 := flag.Bool("bm25", false, "rank files with BM25")
+	sortBy := flag.String("sort", "score", "sort the displayed files by `order`: score, repo, path or date")
+	replace := flag.String("replace", "", "print a unified diff that replaces the matches by `template`, which may refer to regexp groups as $1")
 
 	flag.Usage = func() {
 		name := os.Args[0]
@@ -114,7 +155,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	query, err := query.Parse(pat)
+	parse := query.Parse
+	if *replace != "" {
+		// The template may refer to the capture groups.
+		parse = query.ParseWithCaptures
+	}
+	query, err := parse(pat)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -122,7 +168,21 @@ func main() {
 		log.Println("query:", query)
 	}
 
-	var sOpts zoekt.SearchOptions
+	order, err := zoekt.ParseSortOrder(*sortBy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sOpts := zoekt.SearchOptions{
+		Explain:        *explain,
+		UseBM25Scoring: *bm25,
+		SortBy:         order,
+	}
+	if *replace != "" {
+		sOpts.Whole = true
+		sOpts.CaptureGroups = true
+		sOpts.ChunkMatches = true
+	}
 	sres, err := searcher.Search(context.Background(), query, &sOpts)
 	if *cpuProfile != "" {
 		// If profiling, do it another time so we measure with
@@ -153,7 +213,16 @@ func main() {
 		log.Fatal(err)
 	}
 
-	displayMatches(sres.Files, pat, *withRepo, *list)
+	if *replace != "" {
+		if err := displayDiffs(sres.Files, *replace); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		displayMatches(sres.Files, pat, *withRepo, *list)
+	}
+	if *explain {
+		displayExplanations(sres.Explanations)
+	}
 	if *verbose {
 		log.Printf("stats: %#v", sres.Stats)
 	}
@@ -0,0 +1,107 @@
+// package codeowners parses CODEOWNERS files, which assign owners to the
+// files of a repository.
+package codeowners
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// Files are the locations of the CODEOWNERS file in a repository, in the
+// order they are looked up. Only the first file found is used.
+var Files = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type rule struct {
+	// patterns match the paths the rule applies to.
+	patterns []glob.Glob
+	owners   []string
+}
+
+// Ruleset is a parsed CODEOWNERS file.
+type Ruleset struct {
+	rules []rule
+}
+
+// Parse parses a CODEOWNERS file according to the following rules
+//
+//   - each line is a pattern followed by owners, separated by whitespace
+//   - patterns follow .gitignore syntax: a pattern without a slash matches at
+//     any depth, a pattern with a slash is relative to the root of the
+//     repository, and a pattern matching a directory matches all files below it,
+//     except that a trailing /* only matches the files directly in a directory
+//   - a line without owners removes the owners of the paths it matches
+//   - lines starting with # and empty lines are ignored
+func Parse(r io.Reader) (*Ruleset, error) {
+	var rs Ruleset
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var owners []string
+		for _, f := range fields[1:] {
+			if strings.HasPrefix(f, "#") {
+				// trailing comment
+				break
+			}
+			owners = append(owners, f)
+		}
+
+		patterns, err := compilePattern(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		rs.rules = append(rs.rules, rule{patterns: patterns, owners: owners})
+	}
+	return &rs, scanner.Err()
+}
+
+func compilePattern(pattern string) ([]glob.Glob, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	// Patterns with a slash (other than a trailing one) are relative to the
+	// root, others match at any depth.
+	bases := []string{strings.TrimPrefix(pattern, "/")}
+	if !strings.Contains(pattern, "/") {
+		bases = append(bases, "**/"+pattern)
+	}
+
+	var globs []glob.Glob
+	for _, base := range bases {
+		var exprs []string
+		if !strings.HasSuffix(base, "/*") {
+			exprs = append(exprs, base+"/**")
+		}
+		if !dirOnly {
+			exprs = append(exprs, base)
+		}
+		for _, expr := range exprs {
+			// with separators = '/', * becomes path-aware
+			g, err := glob.Compile(expr, '/')
+			if err != nil {
+				return nil, err
+			}
+			globs = append(globs, g)
+		}
+	}
+	return globs, nil
+}
+
+// Owners returns the owners of the file at path, which is relative to the
+// root of the repository. If several rules match, the last one wins.
+func (rs *Ruleset) Owners(path string) []string {
+	for i := len(rs.rules) - 1; i >= 0; i-- {
+		for _, g := range rs.rules[i].patterns {
+			if g.Match(path) {
+				return rs.rules[i].owners
+			}
+		}
+	}
+	return nil
+}
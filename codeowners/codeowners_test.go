@@ -0,0 +1,56 @@
+package codeowners
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOwners(t *testing.T) {
+	rs, err := Parse(strings.NewReader(`
+# default owners
+*       @global-owner
+
+*.js    @js-owner # frontend
+docs/*  docs@example.com
+apps/   @octocat
+**/logs @logs-owner
+/build/logs/ @doctocat
+/scripts/ @doctocat @octocat
+/scripts/generated
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string][]string{
+		"main.go":                        {"@global-owner"},
+		"web/index.js":                   {"@js-owner"},
+		"index.js":                       {"@js-owner"},
+		"build/logs/today.txt":           {"@doctocat"},
+		"src/build/logs/today.txt":       {"@logs-owner"},
+		"docs/getting-started.md":        {"docs@example.com"},
+		"docs/build-app/trouble.md":      {"@global-owner"},
+		"apps/main.go":                   {"@octocat"},
+		"src/apps/main.go":               {"@octocat"},
+		"apps":                           {"@global-owner"},
+		"deeply/nested/logs/x.txt":       {"@logs-owner"},
+		"scripts/deploy.sh":              {"@doctocat", "@octocat"},
+		"scripts/generated/gen.sh":       nil,
+		"other/scripts/generated/gen.sh": {"@global-owner"},
+	} {
+		if got := rs.Owners(path); !reflect.DeepEqual(got, want) {
+			t.Errorf("Owners(%q): got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestOwnersEmpty(t *testing.T) {
+	rs, err := Parse(strings.NewReader("# nothing here\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rs.Owners("main.go"); got != nil {
+		t.Errorf("got %v, want no owners", got)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/google/zoekt/query"
+)
+
+// isCommit returns true if docID is a commit document, ie. a commit message
+// or a diff.
+func (d *indexData) isCommit(docID uint32) bool {
+	return len(d.fileCommitsIndex) > 0 && d.fileCommitsIndex[docID+1] > d.fileCommitsIndex[docID]
+}
+
+// isDiff returns true if docID is a diff.
+func (d *indexData) isDiff(docID uint32) bool {
+	i := sort.Search(len(d.fileDiffs), func(i int) bool { return d.fileDiffs[i] >= docID })
+	return i < len(d.fileDiffs) && d.fileDiffs[i] == docID
+}
+
+// isCommitMessage returns true if docID is a commit message.
+func (d *indexData) isCommitMessage(docID uint32) bool {
+	return d.isCommit(docID) && !d.isDiff(docID)
+}
+
+// docCommit returns the commit of docID, or nil if docID is a file.
+func (d *indexData) docCommit(docID uint32) (*Commit, error) {
+	if !d.isCommit(docID) {
+		return nil, nil
+	}
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.fileCommitsStart + d.fileCommitsIndex[docID],
+		sz:  d.fileCommitsIndex[docID+1] - d.fileCommitsIndex[docID],
+	})
+	if err != nil {
+		return nil, err
+	}
+	var c Commit
+	if err := json.Unmarshal(blob, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// newCommitMatchTree returns a matchTree for the commit documents, both
+// commit messages and diffs.
+func (d *indexData) newCommitMatchTree() matchTree {
+	return &docMatchTree{
+		reason:    "commit",
+		numDocs:   d.numDocs(),
+		predicate: d.isCommit,
+	}
+}
+
+// newCommitMessageMatchTree returns a matchTree for the commit messages.
+func (d *indexData) newCommitMessageMatchTree() matchTree {
+	return &docMatchTree{
+		reason:    "commit message",
+		numDocs:   d.numDocs(),
+		predicate: d.isCommitMessage,
+	}
+}
+
+// newDiffMatchTree returns a matchTree for the diffs.
+func (d *indexData) newDiffMatchTree() matchTree {
+	return &docMatchTree{
+		reason:    "diff",
+		numDocs:   d.numDocs(),
+		predicate: d.isDiff,
+	}
+}
+
+// wantsCommits returns whether q has a type:commit subquery, which matches
+// commit messages, and a diff subquery, which matches diffs.
+func wantsCommits(q query.Q) (messages, diffs bool) {
+	query.Map(q, func(q query.Q) query.Q {
+		switch s := q.(type) {
+		case *query.Type:
+			messages = messages || s.Type == query.TypeCommit
+		case *query.Diff:
+			diffs = true
+		}
+		return q
+	})
+	return messages, diffs
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestCommitMessages(t *testing.T) {
+	commit := &Commit{
+		SHA:    "0123456789abcdef0123456789abcdef01234567",
+		Author: "Alice <alice@example.com>",
+		Date:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	b := testIndexBuilder(t, &Repository{
+		Name:              "reponame",
+		CommitURLTemplate: "https://example.com/commit/{{.Version}}",
+	},
+		Document{Name: "f1", Content: []byte("fix for CVE-2024-1234")},
+		Document{Name: commit.SHA, Content: []byte("Fix CVE-2024-1234\n\nDetails.\n"), Commit: commit},
+	)
+
+	s := searcherForTest(t, b)
+	if got := s.(*indexData).metaData.IndexMinReaderVersion; got != 18 {
+		t.Errorf("got IndexMinReaderVersion %d, want 18", got)
+	}
+	s.Close()
+
+	res := searchForTest(t, b, &query.Substring{Pattern: "CVE-2024"})
+	if len(res.Files) != 1 || res.Files[0].FileName != "f1" || res.Files[0].Commit != nil {
+		t.Fatalf("got %v, want only f1", res.Files)
+	}
+
+	for _, q := range []query.Q{
+		&query.Type{Type: query.TypeCommit, Child: &query.Substring{Pattern: "CVE-2024"}},
+		&query.Type{Type: query.TypeCommit, Child: &query.Const{Value: true}},
+	} {
+		res = searchForTest(t, b, q)
+		if len(res.Files) != 1 {
+			t.Fatalf("%s: got %v, want 1 commit", q, res.Files)
+		}
+		f := res.Files[0]
+		if f.Commit == nil || *f.Commit != *commit {
+			t.Errorf("%s: got commit %+v, want %+v", q, f.Commit, commit)
+		}
+		if f.Version != commit.SHA {
+			t.Errorf("%s: got version %q, want %q", q, f.Version, commit.SHA)
+		}
+		if got := res.CommitURLs["reponame"]; got != "https://example.com/commit/{{.Version}}" {
+			t.Errorf("%s: got commit URL template %q", q, got)
+		}
+	}
+}
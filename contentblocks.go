@@ -0,0 +1,212 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/golang/snappy"
+)
+
+// contentBlockSize is the number of uncompressed content bytes stored in
+// each block when compressing file contents. Readers take the block size
+// from the first block, so it can be changed without breaking old shards.
+const contentBlockSize = 32 << 10
+
+// contentBlockCacheSize is the number of decompressed blocks kept in memory,
+// shared between all shards.
+const contentBlockCacheSize = 256
+
+var contentBlockCache = struct {
+	sync.Mutex
+	*lru.Cache
+}{Cache: lru.New(contentBlockCacheSize)}
+
+type contentBlockKey struct {
+	blocks *contentBlocks
+	idx    uint32
+}
+
+// writeContentBlocks writes the concatenated contents of strs as a list of
+// snappy compressed blocks of contentBlockSize bytes. The uncompressed
+// offsets of the documents go into boundaries.
+func writeContentBlocks(w *writer, strs []*searchableString, blocks *compoundSection, boundaries *simpleSection) {
+	blocks.start(w)
+	var buf, enc []byte
+	for _, s := range strs {
+		data := s.data
+		for len(data) > 0 {
+			n := contentBlockSize - len(buf)
+			if n > len(data) {
+				n = len(data)
+			}
+			buf = append(buf, data[:n]...)
+			data = data[n:]
+			if len(buf) == contentBlockSize {
+				enc = snappy.Encode(enc[:cap(enc)], buf)
+				blocks.addItem(w, enc)
+				buf = buf[:0]
+			}
+		}
+	}
+	if len(buf) > 0 {
+		blocks.addItem(w, snappy.Encode(enc[:cap(enc)], buf))
+	}
+	blocks.end(w)
+
+	boundaries.start(w)
+	var off uint32
+	w.U32(off)
+	for _, s := range strs {
+		off += uint32(len(s.data))
+		w.U32(off)
+	}
+	boundaries.end(w)
+}
+
+// contentBlocks reads file contents stored by writeContentBlocks.
+type contentBlocks struct {
+	file IndexFile
+
+	// start is the file offset of the first block, and index holds the
+	// offsets of the blocks relative to start, plus the end of the last.
+	start uint32
+	index []uint32
+
+	// blockSize is the uncompressed size of all blocks except the last.
+	blockSize uint32
+
+	// size is the total uncompressed size.
+	size uint32
+}
+
+func newContentBlocks(file IndexFile, sec compoundSection, size uint32) (*contentBlocks, error) {
+	c := &contentBlocks{
+		file:  file,
+		start: sec.data.off,
+		index: sec.relativeIndex(),
+		size:  size,
+	}
+	if len(c.index) == 0 {
+		if size != 0 {
+			return nil, fmt.Errorf("content blocks: no blocks for %d bytes of content", size)
+		}
+		return c, nil
+	}
+
+	first, err := c.readBlock(0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := snappy.DecodedLen(first)
+	if err != nil {
+		return nil, fmt.Errorf("content blocks: %w", err)
+	}
+	c.blockSize = uint32(n)
+
+	if numBlocks := uint32(len(c.index) - 1); c.blockSize == 0 || (size+c.blockSize-1)/c.blockSize != numBlocks {
+		return nil, fmt.Errorf("content blocks: got %d blocks of size %d, want %d bytes", numBlocks, c.blockSize, size)
+	}
+	return c, nil
+}
+
+func (c *contentBlocks) readBlock(i uint32) ([]byte, error) {
+	return c.file.Read(c.start+c.index[i], c.index[i+1]-c.index[i])
+}
+
+// compressedSize returns the size of all blocks in the file.
+func (c *contentBlocks) compressedSize() uint32 {
+	if len(c.index) == 0 {
+		return 0
+	}
+	return c.index[len(c.index)-1]
+}
+
+// block returns the decompressed block i. The result must not be modified.
+func (c *contentBlocks) block(i uint32) ([]byte, error) {
+	key := contentBlockKey{blocks: c, idx: i}
+
+	contentBlockCache.Lock()
+	v, ok := contentBlockCache.Get(key)
+	contentBlockCache.Unlock()
+	if ok {
+		return v.([]byte), nil
+	}
+
+	blob, err := c.readBlock(i)
+	if err != nil {
+		return nil, err
+	}
+	data, err := snappy.Decode(nil, blob)
+	if err != nil {
+		return nil, fmt.Errorf("content block %d: %w", i, err)
+	}
+
+	contentBlockCache.Lock()
+	contentBlockCache.Add(key, data)
+	contentBlockCache.Unlock()
+	return data, nil
+}
+
+// read returns sz bytes of uncompressed content starting at off. The result
+// is cut short at the end of the content, and must not be modified.
+func (c *contentBlocks) read(off, sz uint32) ([]byte, error) {
+	if off > c.size {
+		return nil, fmt.Errorf("content blocks: offset %d out of range %d", off, c.size)
+	}
+	if sz > c.size-off {
+		sz = c.size - off
+	}
+	if sz == 0 {
+		return []byte{}, nil
+	}
+
+	first, last := off/c.blockSize, (off+sz-1)/c.blockSize
+	if first == last {
+		b, err := c.block(first)
+		if err != nil {
+			return nil, err
+		}
+		start := off - first*c.blockSize
+		return b[start : start+sz], nil
+	}
+
+	out := make([]byte, 0, sz)
+	for i := first; i <= last; i++ {
+		b, err := c.block(i)
+		if err != nil {
+			return nil, err
+		}
+		if i == first {
+			b = b[off-first*c.blockSize:]
+		}
+		if rest := int(sz) - len(out); len(b) > rest {
+			b = b[:rest]
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// close drops the blocks of c from the cache.
+func (c *contentBlocks) close() {
+	contentBlockCache.Lock()
+	defer contentBlockCache.Unlock()
+	for i := 0; i+1 < len(c.index); i++ {
+		contentBlockCache.Remove(contentBlockKey{blocks: c, idx: uint32(i)})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/zoekt/query"
+)
+
+func compressedTestDocs() []Document {
+	var docs []Document
+	for i := 0; i < 50; i++ {
+		var b strings.Builder
+		for j := 0; j < 100; j++ {
+			fmt.Fprintf(&b, "line %d of file %d, blá blâ néédlÉ %d\n", j, i, i*j)
+		}
+		docs = append(docs, Document{Name: fmt.Sprintf("f%d", i), Content: []byte(b.String())})
+	}
+	// A document spanning several blocks.
+	docs = append(docs, Document{
+		Name:    "large",
+		Content: bytes.Repeat([]byte("large needle\n"), 3*contentBlockSize/13),
+	})
+	docs = append(docs, Document{Name: "empty"})
+	return docs
+}
+
+func TestContentBlocks(t *testing.T) {
+	docs := compressedTestDocs()
+
+	plain := testIndexBuilder(t, nil, docs...)
+	compressed := testIndexBuilder(t, nil, docs...)
+	compressed.CompressContents = true
+
+	d := searcherForTest(t, compressed).(*indexData)
+	if d.contentBlocks == nil {
+		t.Fatal("want compressed contents")
+	}
+	if got := len(d.contentBlocks.index) - 1; got < 4 {
+		t.Fatalf("got %d blocks, want at least 4", got)
+	}
+
+	var all []byte
+	for i, doc := range docs {
+		got, err := d.readContents(uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, doc.Content) {
+			t.Errorf("readContents(%d): got %d bytes, want %d", i, len(got), len(doc.Content))
+		}
+		all = append(all, doc.Content...)
+	}
+
+	for _, tc := range []struct{ off, sz uint32 }{
+		{0, 10},
+		{contentBlockSize - 5, 10},
+		{contentBlockSize - 5, 2*contentBlockSize + 10},
+		{uint32(len(all)) - 3, 10},
+	} {
+		got, err := d.readContentSlice(tc.off, tc.sz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := tc.off + tc.sz
+		if end > uint32(len(all)) {
+			end = uint32(len(all))
+		}
+		if want := all[tc.off:end]; !bytes.Equal(got, want) {
+			t.Errorf("readContentSlice(%d, %d): got %q, want %q", tc.off, tc.sz, got, want)
+		}
+	}
+
+	for _, q := range []query.Q{
+		&query.Substring{Pattern: "NÉÉDLÉ 42"},
+		&query.Substring{Pattern: "large needle", Content: true},
+		&query.Regexp{Regexp: mustParseRE("file 4[0-9], blá"), Content: true},
+	} {
+		want := searchForTest(t, plain, q, SearchOptions{Whole: true})
+		got := searchForTest(t, compressed, q, SearchOptions{Whole: true})
+		if len(want.Files) == 0 {
+			t.Fatalf("%s: no results", q)
+		}
+		if d := cmp.Diff(want.Files, got.Files); d != "" {
+			t.Errorf("%s: mismatch (-plain +compressed):\n%s", q, d)
+		}
+	}
+
+	rl, err := searcherForTest(t, compressed).List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := rl.Stats
+	if stats.UncompressedContentBytes != int64(len(all)) {
+		t.Errorf("got UncompressedContentBytes %d, want %d", stats.UncompressedContentBytes, len(all))
+	}
+	if stats.CompressedContentBytes == 0 || stats.CompressedContentBytes >= stats.UncompressedContentBytes {
+		t.Errorf("got CompressedContentBytes %d, want less than %d", stats.CompressedContentBytes, stats.UncompressedContentBytes)
+	}
+}
@@ -146,6 +146,7 @@ func (p *contentProvider) fillMatches(ms []*candidateMatch, numContextLines int,
 				LineOffset:  int(m.byteOffset),
 				MatchLength: int(m.byteMatchSz),
 				Offset:      m.byteOffset,
+				Submatches:  m.submatches(res.Line),
 			})
 
 			result = []LineMatch{res}
@@ -171,7 +172,14 @@ func (p *contentProvider) fillChunkMatches(ms []*candidateMatch, numContextLines
 
 		fileName := p.id.fileName(p.idx)
 		ranges := make([]Range, 0, len(ms))
-		for _, m := range ms {
+		var submatches [][]Submatch
+		for i, m := range ms {
+			if sm := m.submatches(fileName); sm != nil {
+				if submatches == nil {
+					submatches = make([][]Submatch, len(ms))
+				}
+				submatches[i] = sm
+			}
 			ranges = append(ranges, Range{
 				Start: Location{
 					ByteOffset: m.byteOffset,
@@ -190,6 +198,7 @@ func (p *contentProvider) fillChunkMatches(ms []*candidateMatch, numContextLines
 			Content:      fileName,
 			ContentStart: Location{ByteOffset: 0, LineNumber: 1, Column: 1},
 			Ranges:       ranges,
+			Submatches:   submatches,
 			FileName:     true,
 		}}
 	} else {
@@ -265,6 +274,7 @@ func (p *contentProvider) fillContentMatches(ms []*candidateMatch, numContextLin
 				Offset:      m.byteOffset,
 				LineOffset:  int(m.byteOffset) - lineStart,
 				MatchLength: int(m.byteMatchSz),
+				Submatches:  m.submatches(data),
 			}
 			if m.symbol {
 				start := p.id.fileEndSymbol[p.idx]
@@ -290,7 +300,14 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 	for _, chunk := range chunks {
 		ranges := make([]Range, 0, len(chunk.candidates))
 		var symbolInfo []*Symbol
+		var submatches [][]Submatch
 		for i, cm := range chunk.candidates {
+			if sm := cm.submatches(data); sm != nil {
+				if submatches == nil {
+					submatches = make([][]Submatch, len(chunk.candidates))
+				}
+				submatches[i] = sm
+			}
 			startOffset := cm.byteOffset
 			endOffset := cm.byteOffset + cm.byteMatchSz
 			startLine, startLineOffset, _ := newlines.atOffset(startOffset)
@@ -339,6 +356,7 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 			FileName:   false,
 			Ranges:     ranges,
 			SymbolInfo: symbolInfo,
+			Submatches: submatches,
 		})
 	}
 	return chunkMatches
@@ -671,7 +689,7 @@ type fileMatchSlice []FileMatch
 
 func (m fileMatchSlice) Len() int           { return len(m) }
 func (m fileMatchSlice) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
-func (m fileMatchSlice) Less(i, j int) bool { return m[i].Score > m[j].Score }
+func (m fileMatchSlice) Less(i, j int) bool { return SortByScore.less(&m[i], &m[j], nil) }
 
 func sortMatchesByScore(ms []LineMatch) {
 	sort.Sort(matchScoreSlice(ms))
@@ -685,3 +703,39 @@ func sortChunkMatchesByScore(ms []ChunkMatch) {
 func SortFilesByScore(ms []FileMatch) {
 	sort.Sort(fileMatchSlice(ms))
 }
+
+// CollapseDuplicates merges the files in ms that have the same checksum into
+// the first of them, which lists the others in its Duplicates. It keeps the
+// order of ms, so the first file is the one with the highest score if ms is
+// sorted. Commit documents are never merged. Collapsing collapsed results
+// again is a no-op.
+func CollapseDuplicates(ms []FileMatch) []FileMatch {
+	// checksum => index in collapsed
+	first := make(map[string]int, len(ms))
+	collapsed := ms[:0]
+	for _, m := range ms {
+		if m.Commit != nil || len(m.Checksum) == 0 {
+			collapsed = append(collapsed, m)
+			continue
+		}
+
+		i, ok := first[string(m.Checksum)]
+		if !ok {
+			first[string(m.Checksum)] = len(collapsed)
+			collapsed = append(collapsed, m)
+			continue
+		}
+
+		dups := append(collapsed[i].Duplicates, DuplicateFile{
+			FileName:          m.FileName,
+			Repository:        m.Repository,
+			RepositoryID:      m.RepositoryID,
+			Branches:          m.Branches,
+			SubRepositoryName: m.SubRepositoryName,
+			SubRepositoryPath: m.SubRepositoryPath,
+			Version:           m.Version,
+		})
+		collapsed[i].Duplicates = append(dups, m.Duplicates...)
+	}
+	return collapsed
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Cursor is a position in sorted search results. It identifies a file by
+// its score and location, so a search can resume after it, see
+// SearchOptions.Cursor.
+type Cursor struct {
+	Score      float64 `json:"s"`
+	Repository string  `json:"r"`
+	FileName   string  `json:"f"`
+
+	// Order is the order of the results. Cursors don't support
+	// SortByCommitDate.
+	Order SortOrder `json:"o,omitempty"`
+}
+
+// NewCursor returns the cursor that resumes a search sorted by order after f.
+func NewCursor(f *FileMatch, order SortOrder) *Cursor {
+	return &Cursor{
+		Score:      f.Score,
+		Repository: f.Repository,
+		FileName:   f.FileName,
+		Order:      order,
+	}
+}
+
+// ParseCursor decodes a cursor returned by Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q: %w", s, err)
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor %q: %w", s, err)
+	}
+	if c.Order == "" {
+		c.Order = SortByScore
+	}
+	if c.Order == SortByCommitDate {
+		return nil, fmt.Errorf("invalid cursor %q: cursors don't support sort order %q", s, c.Order)
+	}
+	return &c, nil
+}
+
+// String returns the cursor as an opaque token, safe for use in URLs.
+func (c *Cursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Filter returns the files of ms that come after c, in the order of ms. It
+// reuses the storage of ms.
+func (c *Cursor) Filter(ms []FileMatch) []FileMatch {
+	last := FileMatch{Score: c.Score, Repository: c.Repository, FileName: c.FileName}
+	after := ms[:0]
+	for i := range ms {
+		if c.Order.less(&last, &ms[i], nil) {
+			after = append(after, ms[i])
+		}
+	}
+	return after
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	files := []FileMatch{
+		{Score: 3, Repository: "b", FileName: "x"},
+		{Score: 2, Repository: "a", FileName: "y"},
+		{Score: 2, Repository: "b", FileName: "x"},
+		{Score: 2, Repository: "a", FileName: "x"},
+		{Score: 1, Repository: "a", FileName: "x"},
+	}
+	SortFilesByScore(files)
+
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Repository+"/"+f.FileName)
+	}
+	if want := []string{"b/x", "a/x", "a/y", "b/x", "a/x"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got order %v, want %v", got, want)
+	}
+
+	for i := range files {
+		c, err := ParseCursor(NewCursor(&files[i], SortByScore).String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		after := c.Filter(append([]FileMatch{}, files...))
+		if !reflect.DeepEqual(after, files[i+1:]) {
+			t.Errorf("after file %d: got %v, want %v", i, after, files[i+1:])
+		}
+	}
+
+	if _, err := ParseCursor("not a cursor"); err == nil {
+		t.Error("ParseCursor succeeded on garbage")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+
+	"github.com/google/zoekt/query"
+)
+
+// diffLineRegexp returns a query for the lines matching q.Expr that start
+// with the marker of an added ("+") or removed ("-") line in a diff.
+func diffLineRegexp(q *query.Diff) (*query.Regexp, error) {
+	var pattern string
+	var caseSensitive bool
+	switch e := q.Expr.(type) {
+	case *query.Substring:
+		pattern, caseSensitive = regexp.QuoteMeta(e.Pattern), e.CaseSensitive
+	case *query.Regexp:
+		pattern, caseSensitive = e.Regexp.String(), e.CaseSensitive
+	default:
+		return nil, fmt.Errorf("found %T inside query.Diff", q.Expr)
+	}
+
+	marker := `\+`
+	if q.Removed {
+		marker = `-`
+	}
+	re, err := syntax.Parse("(?m)^"+marker+"(?-s:.)*(?:"+pattern+")", syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return &query.Regexp{
+		Regexp:        re,
+		Content:       true,
+		CaseSensitive: caseSensitive,
+	}, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestDiffQuery(t *testing.T) {
+	commit := &Commit{SHA: "0123456789abcdef0123456789abcdef01234567"}
+	message := &Commit{SHA: "89abcdef0123456789abcdef0123456789abcdef"}
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{Name: "main.go", Content: []byte("+ not a diff: oldCall()\n")},
+		Document{Name: "main.go", Commit: commit, Diff: true, Content: []byte(
+			"@@ -1,3 +1,3 @@\n func main() {\n-\toldCall()\n+\tnewCall()\n }\n")},
+		Document{Name: message.SHA, Commit: message, Content: []byte("+\tnewCall in a commit message\n")},
+	)
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{"added:newCall", []string{"+\tnewCall"}},
+		{"added:oldCall", nil},
+		{"removed:oldCall", []string{"-\toldCall"}},
+		{"removed:OLDcall case:no", []string{"-\toldCall"}},
+		{"removed:OLDcall", nil},
+		{"added:new.*Call", []string{"+\tnewCall"}},
+	} {
+		q, err := query.Parse(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := searchForTest(t, b, q)
+		var got []string
+		for _, f := range res.Files {
+			if f.Commit == nil || f.Commit.SHA != commit.SHA {
+				t.Errorf("%s: got %s without commit", tc.q, f.FileName)
+			}
+			for _, l := range f.LineMatches {
+				for _, lf := range l.LineFragments {
+					got = append(got, string(l.Line[lf.LineOffset:lf.LineOffset+lf.MatchLength]))
+				}
+			}
+		}
+		if len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Errorf("%s: got %q, want %q", tc.q, got, tc.want)
+		}
+	}
+
+	// Diffs are not returned for plain searches.
+	res := searchForTest(t, b, &query.Substring{Pattern: "newCall"})
+	if len(res.Files) != 0 {
+		t.Errorf("got %v, want no matches", res.Files)
+	}
+
+	// Nor for type:commit, which only matches commit messages.
+	res = searchForTest(t, b, &query.Type{Type: query.TypeCommit, Child: &query.Substring{Pattern: "newCall"}})
+	if len(res.Files) != 1 || res.Files[0].FileName != message.SHA {
+		t.Errorf("got %v, want the commit message", res.Files)
+	}
+}
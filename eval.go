@@ -118,6 +118,14 @@ func (d *indexData) simplify(in query.Q) query.Q {
 			if !has {
 				return &query.Const{Value: false}
 			}
+		case *query.Owner:
+			if len(d.matchingOwnerIDs(r.Owner)) == 0 {
+				return &query.Const{Value: false}
+			}
+		case *query.Diff:
+			if len(d.fileDiffs) == 0 {
+				return &query.Const{Value: false}
+			}
 		}
 		return q
 	})
@@ -148,19 +156,41 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 	importantMatchCount := 0
 
 	var res SearchResult
+	var explanation *ShardExplanation
+	if opts.Explain {
+		explanation = &ShardExplanation{Shard: d.String()}
+		defer func() {
+			if sr != nil {
+				sr.Explanations = append(sr.Explanations, *explanation)
+			}
+		}()
+	}
+
 	if len(d.fileNameIndex) == 0 {
+		if explanation != nil {
+			explanation.Skipped = "empty shard"
+		}
 		return &res, nil
 	}
 
 	select {
 	case <-ctx.Done():
 		res.Stats.ShardsSkipped++
+		if explanation != nil {
+			explanation.Skipped = "canceled"
+		}
 		return &res, nil
 	default:
 	}
 
 	q = d.simplify(q)
+	if explanation != nil {
+		explanation.Query = q.String()
+	}
 	if c, ok := q.(*query.Const); ok && !c.Value {
+		if explanation != nil {
+			explanation.Skipped = "query simplified to false"
+		}
 		return &res, nil
 	}
 
@@ -170,11 +200,29 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 	}
 
 	q = query.Map(q, query.ExpandFileContent)
+	if !opts.CaptureGroups {
+		q = query.Map(q, query.RemoveCaptures)
+	}
 
 	mt, err := d.newMatchTree(q)
 	if err != nil {
 		return nil, err
 	}
+	if len(d.fileCommitsIndex) > 0 {
+		// Commit messages only match type:commit queries, and diffs only
+		// diff queries.
+		switch messages, diffs := wantsCommits(q); {
+		case !messages && !diffs:
+			mt = &andMatchTree{[]matchTree{mt, &notMatchTree{d.newCommitMatchTree()}}}
+		case !messages:
+			mt = &andMatchTree{[]matchTree{mt, &notMatchTree{d.newCommitMessageMatchTree()}}}
+		case !diffs && len(d.fileDiffs) > 0:
+			mt = &andMatchTree{[]matchTree{mt, &notMatchTree{d.newDiffMatchTree()}}}
+		}
+	}
+	if explanation != nil {
+		explanation.Ngrams = explainNgrams(mt)
+	}
 
 	mt, err = pruneMatchTree(mt)
 	if err != nil {
@@ -182,8 +230,21 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 	}
 	if mt == nil {
 		res.Stats.ShardsSkippedFilter++
+		if explanation != nil {
+			explanation.Skipped = explainSkip(explanation.Ngrams)
+		}
 		return &res, nil
 	}
+	if explanation != nil {
+		explanation.MatchTree = fmt.Sprintf("%v", mt)
+	}
+	if opts.CaptureGroups {
+		visitMatchTree(mt, func(t matchTree) {
+			if rmt, ok := t.(*regexpMatchTree); ok {
+				rmt.captures = true
+			}
+		})
+	}
 
 	totalAtomCount := 0
 	visitMatchTree(mt, func(t matchTree) {
@@ -197,10 +258,15 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 		stats: &res.Stats,
 	}
 
+	var bm25 *bm25Scorer
+	if opts.UseBM25Scoring {
+		bm25 = newBM25Scorer(d)
+	}
+
 	// Track the number of documents found in a repository for
 	// ShardRepoMaxMatchCount
 	var (
-		lastRepoID     uint16
+		lastRepoID     uint32
 		repoMatchCount int
 	)
 
@@ -238,7 +304,8 @@ nextFileMatch:
 			}
 
 			// Skip documents over ShardRepoMaxMatchCount if specified.
-			if opts.ShardRepoMaxMatchCount > 0 {
+			// Facets count them, so they are matched below.
+			if opts.ShardRepoMaxMatchCount > 0 && !opts.Facets {
 				if repoMatchCount >= opts.ShardRepoMaxMatchCount && repoID == lastRepoID {
 					res.Stats.FilesSkipped++
 					continue
@@ -260,13 +327,23 @@ nextFileMatch:
 			repoMatchCount = 0
 		}
 
+		// Documents over the match limits are only matched to count them
+		// in the facets.
+		facetsOnly := opts.Facets && opts.ShardRepoMaxMatchCount > 0 && repoMatchCount >= opts.ShardRepoMaxMatchCount
 		if canceled || (res.Stats.MatchCount >= opts.ShardMaxMatchCount && opts.ShardMaxMatchCount > 0) ||
 			(opts.ShardMaxImportantMatch > 0 && importantMatchCount >= opts.ShardMaxImportantMatch) {
-			res.Stats.FilesSkipped += int(docCount - nextDoc)
-			break
+			if canceled || !opts.Facets {
+				res.Stats.FilesSkipped += int(docCount - nextDoc)
+				break
+			}
+			facetsOnly = true
 		}
 
-		res.Stats.FilesConsidered++
+		if facetsOnly {
+			res.Stats.FilesSkipped++
+		} else {
+			res.Stats.FilesConsidered++
+		}
 		mt.prepare(nextDoc)
 
 		cp.setDocument(nextDoc)
@@ -287,6 +364,17 @@ nextFileMatch:
 			}
 		}
 
+		if opts.Facets {
+			if res.Facets == nil {
+				res.Facets = &Facets{}
+			}
+			res.Facets.addFile(md.Name, string(d.fileName(nextDoc)), d.languageMap[d.getLanguage(nextDoc)],
+				d.gatherBranches(nextDoc, mt, known))
+		}
+		if facetsOnly {
+			continue
+		}
+
 		fileMatch := FileMatch{
 			Repository:         md.Name,
 			RepositoryID:       md.ID,
@@ -314,6 +402,13 @@ nextFileMatch:
 			}
 		}
 
+		if fileMatch.Commit, err = d.docCommit(nextDoc); err != nil {
+			return nil, err
+		}
+		if fileMatch.Commit != nil {
+			fileMatch.Version = fileMatch.Commit.SHA
+		}
+
 		atomMatchCount := 0
 		visitMatches(mt, known, func(mt matchTree) {
 			atomMatchCount++
@@ -361,15 +456,19 @@ nextFileMatch:
 			fileMatch.ChunkMatches[i].Score += scoreLineOrderFactor * (1.0 - (float64(i) / float64(len(fileMatch.ChunkMatches))))
 		}
 
-		// Maintain ordering of input files. This
-		// strictly dominates the in-file ordering of
-		// the matches.
-		fileMatch.addScore("fragment", maxFileScore, opts.DebugScore)
-		fileMatch.addScore("atom", float64(atomMatchCount)/float64(totalAtomCount)*scoreFactorAtomMatch, opts.DebugScore)
+		if bm25 != nil {
+			bm25.score(&fileMatch, nextDoc, mt, known, opts.DebugScore)
+		} else {
+			// Maintain ordering of input files. This
+			// strictly dominates the in-file ordering of
+			// the matches.
+			fileMatch.addScore("fragment", maxFileScore, opts.DebugScore)
+			fileMatch.addScore("atom", float64(atomMatchCount)/float64(totalAtomCount)*scoreFactorAtomMatch, opts.DebugScore)
 
-		// Prefer earlier docs.
-		fileMatch.addScore("doc-order", scoreFileOrderFactor*(1.0-float64(nextDoc)/float64(len(d.boundaries))), opts.DebugScore)
-		fileMatch.addScore("shard-order", scoreShardRankFactor*float64(md.Rank)/maxUInt16, opts.DebugScore)
+			// Prefer earlier docs.
+			fileMatch.addScore("doc-order", scoreFileOrderFactor*(1.0-float64(nextDoc)/float64(len(d.boundaries))), opts.DebugScore)
+			fileMatch.addScore("shard-order", scoreShardRankFactor*float64(md.Rank)/maxUInt16, opts.DebugScore)
+		}
 
 		if fileMatch.Score > scoreImportantThreshold {
 			importantMatchCount++
@@ -389,6 +488,9 @@ nextFileMatch:
 		repoMatchCount += len(fileMatch.LineMatches)
 		repoMatchCount += matchedChunkRanges
 
+		if opts.CaptureGroups {
+			addCaptures(&res, &fileMatch)
+		}
 		res.Files = append(res.Files, fileMatch)
 		res.Stats.MatchCount += len(fileMatch.LineMatches)
 		res.Stats.MatchCount += matchedChunkRanges
@@ -425,6 +527,13 @@ func addRepo(res *SearchResult, repo *Repository) {
 		res.LineFragments = map[string]string{}
 	}
 	res.LineFragments[repo.Name] = repo.LineFragmentTemplate
+
+	if repo.CommitURLTemplate != "" {
+		if res.CommitURLs == nil {
+			res.CommitURLs = map[string]string{}
+		}
+		res.CommitURLs[repo.Name] = repo.CommitURLTemplate
+	}
 }
 
 type sortByOffsetSlice []*candidateMatch
@@ -435,6 +544,32 @@ func (m sortByOffsetSlice) Less(i, j int) bool {
 	return m[i].byteOffset < m[j].byteOffset
 }
 
+// addCaptures counts the values captured in the matches of fm in
+// res.Captures.
+func addCaptures(res *SearchResult, fm *FileMatch) {
+	add := func(sms []Submatch) {
+		for _, sm := range sms {
+			if res.Captures == nil {
+				res.Captures = map[int]map[string]int{}
+			}
+			if res.Captures[sm.Group] == nil {
+				res.Captures[sm.Group] = map[string]int{}
+			}
+			res.Captures[sm.Group][sm.Value]++
+		}
+	}
+	for _, lm := range fm.LineMatches {
+		for _, f := range lm.LineFragments {
+			add(f.Submatches)
+		}
+	}
+	for _, cm := range fm.ChunkMatches {
+		for _, sms := range cm.Submatches {
+			add(sms)
+		}
+	}
+}
+
 // Gather matches from this document. This never returns a mixture of
 // filename/content matches: if there are content matches, all
 // filename matches are trimmed from the result. The matches are
@@ -490,6 +625,7 @@ func gatherMatches(mt matchTree, known map[matchTree]bool, merge bool) []*candid
 				if end > lastEnd {
 					last.byteMatchSz = end - last.byteOffset
 				}
+				last.captures = append(last.captures, c.captures...)
 				continue
 			}
 
@@ -519,16 +655,7 @@ func gatherMatches(mt matchTree, known map[matchTree]bool, merge bool) []*candid
 }
 
 func (d *indexData) branchIndex(docID uint32) int {
-	mask := d.fileBranchMasks[docID]
-	idx := 0
-	for mask != 0 {
-		if mask&0x1 != 0 {
-			return idx
-		}
-		idx++
-		mask >>= 1
-	}
-	return -1
+	return d.docBranchMask(docID).first()
 }
 
 // gatherBranches returns a list of branch names.
@@ -540,21 +667,16 @@ func (d *indexData) gatherBranches(docID uint32, mt matchTree, known map[matchTr
 		bq, ok := mt.(*branchQueryMatchTree)
 		if ok {
 			foundBranchQuery = true
-			branches = append(branches,
-				d.branchNames[repoIdx][uint(bq.masks[repoIdx])])
+			d.docBranchMask(docID).and(bq.masks[repoIdx]).forEach(func(idx int) {
+				branches = append(branches, d.branchNames[repoIdx][uint(idx)])
+			})
 		}
 	})
 
 	if !foundBranchQuery {
-		mask := d.fileBranchMasks[docID]
-		id := uint32(1)
-		for mask != 0 {
-			if mask&0x1 != 0 {
-				branches = append(branches, d.branchNames[repoIdx][uint(id)])
-			}
-			id <<= 1
-			mask >>= 1
-		}
+		d.docBranchMask(docID).forEach(func(idx int) {
+			branches = append(branches, d.branchNames[repoIdx][uint(idx)])
+		})
 	}
 	return branches
 }
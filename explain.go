@@ -0,0 +1,54 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"sort"
+	"strings"
+)
+
+// explainNgrams returns the ngram lookups of the substrings in mt. It must be
+// called before pruning, since pruning drops the substrings that can't match.
+func explainNgrams(mt matchTree) []NgramExplanation {
+	var out []NgramExplanation
+	visitMatchTree(mt, func(t matchTree) {
+		st, ok := t.(*substrMatchTree)
+		if !ok {
+			return
+		}
+		if r, ok := st.matchIterator.(*ngramIterationResults); ok {
+			out = append(out, r.explanation)
+		}
+	})
+	return out
+}
+
+// explainSkip returns why a shard whose match tree pruned to nothing was
+// skipped.
+func explainSkip(ngrams []NgramExplanation) string {
+	seen := map[string]bool{}
+	var why []string
+	for _, n := range ngrams {
+		if n.NoMatch != "" && !seen[n.NoMatch] {
+			seen[n.NoMatch] = true
+			why = append(why, n.NoMatch)
+		}
+	}
+	if len(why) == 0 {
+		return "pruned"
+	}
+	sort.Strings(why)
+	return "pruned by " + strings.Join(why, ", ")
+}
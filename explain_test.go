@@ -0,0 +1,90 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestExplain(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("needle in a haystack")},
+		Document{Name: "f2", Content: []byte("needle")},
+		Document{Name: "f3", Content: []byte("abcd cdef")})
+
+	explain := func(q query.Q) ShardExplanation {
+		t.Helper()
+		res := searchForTest(t, b, q, SearchOptions{Explain: true})
+		if len(res.Explanations) != 1 {
+			t.Fatalf("%s: got %d explanations, want 1", q, len(res.Explanations))
+		}
+		return res.Explanations[0]
+	}
+
+	t.Run("searched", func(t *testing.T) {
+		e := explain(&query.Substring{Pattern: "needle", CaseSensitive: true, Content: true})
+		if e.Skipped != "" {
+			t.Fatalf("got Skipped %q", e.Skipped)
+		}
+		if e.MatchTree == "" || e.Query == "" {
+			t.Errorf("got empty MatchTree %q or Query %q", e.MatchTree, e.Query)
+		}
+		want := []NgramExplanation{{
+			Pattern:     "needle",
+			Ngrams:      []string{"nee", "dle"},
+			Frequencies: []uint32{2, 2},
+		}}
+		if d := cmp.Diff(want, e.Ngrams); d != "" {
+			t.Errorf("mismatch (-want +got):\n%s", d)
+		}
+	})
+
+	t.Run("freq=0", func(t *testing.T) {
+		e := explain(&query.And{Children: []query.Q{
+			&query.Substring{Pattern: "needle", CaseSensitive: true, Content: true},
+			&query.Substring{Pattern: "xyz", CaseSensitive: true, Content: true},
+		}})
+		if want := "pruned by freq=0"; e.Skipped != want {
+			t.Errorf("got Skipped %q, want %q", e.Skipped, want)
+		}
+		if e.MatchTree != "" {
+			t.Errorf("got MatchTree %q for skipped shard", e.MatchTree)
+		}
+		if len(e.Ngrams) != 2 || e.Ngrams[1].NoMatch != "freq=0" {
+			t.Errorf("got Ngrams %+v, want xyz with freq=0", e.Ngrams)
+		}
+	})
+
+	t.Run("bloomfilter", func(t *testing.T) {
+		t.Setenv("ZOEKT_ENABLE_BLOOM", "1")
+
+		// All trigrams occur, but the word doesn't.
+		e := explain(&query.Substring{Pattern: "abcdef", CaseSensitive: true, Content: true})
+		if want := "pruned by bloomfilter"; e.Skipped != want {
+			t.Errorf("got Skipped %q, want %q", e.Skipped, want)
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		res := searchForTest(t, b, &query.Substring{Pattern: "needle"})
+		if len(res.Explanations) != 0 {
+			t.Errorf("got explanations without SearchOptions.Explain: %v", res.Explanations)
+		}
+	})
+}
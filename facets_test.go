@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestFacets(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{
+		Name:     "repo",
+		Branches: []RepositoryBranch{{Name: "main"}, {Name: "dev"}},
+	},
+		Document{Name: "cmd/main.go", Content: []byte("needle"), Language: "Go", Branches: []string{"main", "dev"}},
+		Document{Name: "cmd/util.go", Content: []byte("needle"), Language: "Go", Branches: []string{"dev"}},
+		Document{Name: "README.md", Content: []byte("needle"), Language: "Markdown", Branches: []string{"main"}},
+		Document{Name: "docs/x.md", Content: []byte("haystack"), Language: "Markdown", Branches: []string{"main"}})
+
+	q := &query.Substring{Pattern: "needle", Content: true}
+	if res := searchForTest(t, b, q); res.Facets != nil {
+		t.Errorf("got facets %+v without asking", res.Facets)
+	}
+
+	res := searchForTest(t, b, q, SearchOptions{Facets: true})
+	want := &Facets{
+		Languages:    map[string]int{"Go": 2, "Markdown": 1},
+		Repositories: map[string]int{"repo": 3},
+		Branches:     map[string]int{"main": 2, "dev": 2},
+		Directories:  map[string]int{"cmd": 2, "": 1},
+	}
+	if d := cmp.Diff(want, res.Facets); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+
+	// The match limits don't apply to the facets.
+	for name, opts := range map[string]SearchOptions{
+		"ShardMaxMatchCount":     {Facets: true, ShardMaxMatchCount: 1},
+		"ShardRepoMaxMatchCount": {Facets: true, ShardRepoMaxMatchCount: 1},
+	} {
+		res := searchForTest(t, b, q, opts)
+		if len(res.Files) != 1 {
+			t.Errorf("%s: got %d files, want 1", name, len(res.Files))
+		}
+		if d := cmp.Diff(want, res.Facets); d != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", name, d)
+		}
+	}
+
+	// Merging the facets of two shards.
+	want.Add(res.Facets)
+	if got := want.Repositories["repo"]; got != 6 {
+		t.Errorf("got %d files after Add, want 6", got)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+)
+
+// CheckIndexFile decodes every section of the index file r and checks that
+// the contents are consistent. Unlike NewSearcher, which only does cheap
+// checks, it decodes all posting lists and reads all file contents, so it is
+// as expensive as reading the whole file. It returns the first problem found.
+// The IndexFile is not closed.
+func CheckIndexFile(r IndexFile) error {
+	size, err := r.Size()
+	if err != nil {
+		return err
+	}
+
+	rd := &reader{r: r}
+	var toc indexTOC
+	if err := rd.readTOC(&toc); err != nil {
+		return fmt.Errorf("TOC: %w", err)
+	}
+
+	for _, ent := range toc.sectionsTaggedList() {
+		if err := checkSection(r, size, ent.sec); err != nil {
+			return fmt.Errorf("section %q: %w", ent.tag, err)
+		}
+	}
+	if err := verifySectionChecksums(r, &toc); err != nil {
+		return err
+	}
+
+	d, err := rd.readIndexData(&toc)
+	if err != nil {
+		return err
+	}
+	if d.contentBlocks != nil {
+		defer d.contentBlocks.close()
+	}
+	return d.check()
+}
+
+// checkSection checks that sec lies within a file of the given size.
+func checkSection(r IndexFile, size uint32, sec section) error {
+	switch s := sec.(type) {
+	case *simpleSection:
+		return checkSimpleSection(size, *s)
+	case *compoundSection:
+		return checkCompoundSection(size, s.data, s.index, s.offsets)
+	case *lazyCompoundSection:
+		if err := checkSimpleSection(size, s.index); err != nil {
+			return err
+		}
+		offsets, err := readSectionU32(r, s.index)
+		if err != nil {
+			return err
+		}
+		return checkCompoundSection(size, s.data, s.index, offsets)
+	}
+	return fmt.Errorf("unknown section type %T", sec)
+}
+
+func checkSimpleSection(size uint32, s simpleSection) error {
+	if uint64(s.off)+uint64(s.sz) > uint64(size) {
+		return fmt.Errorf("range [%d, %d) beyond end of file %d", s.off, uint64(s.off)+uint64(s.sz), size)
+	}
+	return nil
+}
+
+func checkCompoundSection(size uint32, data, index simpleSection, offsets []uint32) error {
+	if err := checkSimpleSection(size, data); err != nil {
+		return err
+	}
+	if err := checkSimpleSection(size, index); err != nil {
+		return err
+	}
+	for i, o := range offsets {
+		if o < data.off || o > data.off+data.sz {
+			return fmt.Errorf("item %d at %d outside data [%d, %d)", i, o, data.off, data.off+data.sz)
+		}
+		if i > 0 && o < offsets[i-1] {
+			return fmt.Errorf("item %d at %d before item %d at %d", i, o, i-1, offsets[i-1])
+		}
+	}
+	return nil
+}
+
+// checkNonDecreasing checks that the offsets in xs don't decrease.
+func checkNonDecreasing(what string, xs []uint32) error {
+	for i := 1; i < len(xs); i++ {
+		if xs[i] < xs[i-1] {
+			return fmt.Errorf("%s: entry %d is %d, less than previous %d", what, i, xs[i], xs[i-1])
+		}
+	}
+	return nil
+}
+
+// checkPostings checks that a posting list decodes to increasing offsets
+// below limit.
+func checkPostings(blob []byte, limit uint32) error {
+	var off uint64
+	for i := 0; len(blob) > 0; i++ {
+		delta, sz := binary.Uvarint(blob)
+		if sz <= 0 {
+			return fmt.Errorf("bad varint at entry %d", i)
+		}
+		if i > 0 && delta == 0 {
+			return fmt.Errorf("entry %d repeats offset %d", i, off)
+		}
+		off += delta
+		if off >= uint64(limit) {
+			return fmt.Errorf("entry %d at %d, beyond end %d", i, off, limit)
+		}
+		blob = blob[sz:]
+	}
+	return nil
+}
+
+// check does the expensive consistency checks for CheckIndexFile. It assumes
+// verify has passed.
+func (d *indexData) check() error {
+	n := len(d.fileNameIndex)
+	if n == 0 {
+		return nil
+	}
+	n--
+
+	for what, xs := range map[string][]uint32{
+		"content boundaries":  d.boundaries,
+		"file name index":     d.fileNameIndex,
+		"newlines index":      d.newlinesIndex,
+		"doc section index":   d.docSectionsIndex,
+		"file end runes":      d.fileEndRunes,
+		"file name end runes": d.fileNameEndRunes,
+		"file end symbol":     d.fileEndSymbol,
+		"repos":               d.repos,
+	} {
+		if err := checkNonDecreasing(what, xs); err != nil {
+			return err
+		}
+	}
+
+	for what, got := range map[string]int{
+		"file end runes":      len(d.fileEndRunes),
+		"file name end runes": len(d.fileNameEndRunes),
+		"file end symbol":     len(d.fileEndSymbol) - 1,
+		"repos":               len(d.repos),
+		"checksums":           len(d.checksums) / crc64.Size,
+	} {
+		if got != n {
+			return fmt.Errorf("got %s %d, want %d", what, got, n)
+		}
+	}
+	if len(d.repos) > 0 && int(d.repos[n-1]) >= len(d.repoMetaData) {
+		return fmt.Errorf("repo index %d out of range %d", d.repos[n-1], len(d.repoMetaData))
+	}
+
+	hasher := crc64.New(crc64.MakeTable(crc64.ISO))
+	var newlines []uint32
+	var secs []DocumentSection
+	for i := uint32(0); i < uint32(n); i++ {
+		content, err := d.readContents(i)
+		if err != nil {
+			return fmt.Errorf("doc %d: content: %w", i, err)
+		}
+
+		hasher.Reset()
+		hasher.Write(content)
+		if got, want := hasher.Sum(nil), d.getChecksum(i); !bytes.Equal(got, want) {
+			return fmt.Errorf("doc %d (%s): content checksum %x, want %x", i, d.fileName(i), got, want)
+		}
+
+		newlines, _, err = d.readNewlines(i, newlines)
+		if err != nil {
+			return fmt.Errorf("doc %d: newlines: %w", i, err)
+		}
+		for j, nl := range newlines {
+			if nl >= uint32(len(content)) || (j > 0 && nl <= newlines[j-1]) {
+				return fmt.Errorf("doc %d: newline %d at %d out of order or beyond size %d", i, j, nl, len(content))
+			}
+		}
+
+		secs, _, err = d.readDocSections(i, secs)
+		if err != nil {
+			return fmt.Errorf("doc %d: sections: %w", i, err)
+		}
+		for j, s := range secs {
+			if s.Start > s.End || s.End > uint32(len(content)) {
+				return fmt.Errorf("doc %d: section %d [%d, %d) beyond size %d", i, j, s.Start, s.End, len(content))
+			}
+		}
+	}
+
+	contentRunes := d.fileEndRunes[n-1]
+	for ng, sec := range d.ngrams.DumpMap() {
+		blob, err := d.readSectionBlob(sec)
+		if err != nil {
+			return fmt.Errorf("postings for %q: %w", ng, err)
+		}
+		if err := checkPostings(blob, contentRunes); err != nil {
+			return fmt.Errorf("postings for %q: %w", ng, err)
+		}
+	}
+
+	nameRunes := d.fileNameEndRunes[n-1]
+	for ng, blob := range d.fileNameNgrams {
+		if err := checkPostings(blob, nameRunes); err != nil {
+			return fmt.Errorf("file name postings for %q: %w", ng, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func checkIndexFileBytes(t *testing.T, data []byte) error {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "shard.zoekt")
+	if err := os.WriteFile(p, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	iFile, err := NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iFile.Close()
+	return CheckIndexFile(iFile)
+}
+
+func TestCheckIndexFile(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "repo"},
+		Document{Name: "f1", Content: []byte("the quick brown fox\njumps over\n")},
+		Document{Name: "f2", Content: []byte("the lazy dog"), Symbols: []DocumentSection{{Start: 4, End: 8}}},
+		Document{Name: "f3"})
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	if err := checkIndexFileBytes(t, good); err != nil {
+		t.Fatalf("CheckIndexFile on good shard: %v", err)
+	}
+
+	t.Run("content", func(t *testing.T) {
+		bad := append([]byte{}, good...)
+		i := bytes.Index(bad, []byte("lazy"))
+		bad[i] = 'h'
+		err := checkIndexFileBytes(t, bad)
+		if err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("got %v, want checksum error", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		if err := checkIndexFileBytes(t, good[:len(good)/2]); err == nil {
+			t.Error("CheckIndexFile succeeded on truncated shard")
+		}
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		b := testIndexBuilder(t, nil, compressedTestDocs()...)
+		b.CompressContents = true
+		var buf bytes.Buffer
+		if err := b.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkIndexFileBytes(t, buf.Bytes()); err != nil {
+			t.Errorf("CheckIndexFile on compressed shard: %v", err)
+		}
+	})
+
+	t.Run("testdata", func(t *testing.T) {
+		paths, err := filepath.Glob("testdata/shards/*.zoekt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkIndexFileBytes(t, data); err != nil {
+				t.Errorf("%s: %v", p, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package gitindex
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/build"
+	"github.com/google/zoekt/query"
+	"github.com/google/zoekt/shards"
+)
+
+func createCodeOwnersRepo(dir string) error {
+	script := `mkdir repo
+cd repo
+git init
+mkdir -p .github docs
+echo acont > afile.go
+echo bcont > docs/bfile.md
+printf '*.go @org/go\ndocs/ @org/Docs @alice\n' > .github/CODEOWNERS
+git add afile.go docs/bfile.md .github/CODEOWNERS
+git config user.email "you@example.com"
+git config user.name "Your Name"
+git commit -am amsg
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("execution error: %v, output %s", err, out)
+	}
+	return nil
+}
+
+func TestCodeOwners(t *testing.T) {
+	dir := t.TempDir()
+	if err := createCodeOwnersRepo(dir); err != nil {
+		t.Fatalf("createCodeOwnersRepo: %v", err)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads",
+		Branches:     []string{"master"},
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	for owner, want := range map[string][]string{
+		"@org/go":   {"afile.go"},
+		"@org/docs": {"docs/bfile.md"},
+		"@alice":    {"docs/bfile.md"},
+		"@bob":      nil,
+	} {
+		res, err := searcher.Search(context.Background(), &query.Owner{Owner: owner}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.FileName)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("owner:%s: got %v, want %v", owner, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package gitindex
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/build"
+	"github.com/google/zoekt/query"
+	"github.com/google/zoekt/shards"
+)
+
+func createCommitsRepo(dir string) error {
+	script := `mkdir repo
+cd repo
+git init
+git config user.email "you@example.com"
+git config user.name "Your Name"
+echo acont > afile
+git add afile
+git commit -am "first: add afile"
+echo bcont > afile
+git commit -am "second: fix CVE-2024-1234 in afile"
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("execution error: %v, output %s", err, out)
+	}
+	return nil
+}
+
+func TestCommitMessages(t *testing.T) {
+	dir := t.TempDir()
+	if err := createCommitsRepo(dir); err != nil {
+		t.Fatalf("createCommitsRepo: %v", err)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads",
+		Branches:     []string{"master"},
+		Incremental:  true,
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+	// Turning on commit messages reindexes the unchanged repository.
+	opts.CommitMessages = 1
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	search := func(q query.Q) []zoekt.FileMatch {
+		t.Helper()
+		res, err := searcher.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Files
+	}
+
+	// Only the last commit is indexed.
+	files := search(&query.Type{Type: query.TypeCommit, Child: &query.Const{Value: true}})
+	if len(files) != 1 {
+		t.Fatalf("got %d commits, want 1", len(files))
+	}
+	f := files[0]
+	if f.Commit == nil || f.Commit.SHA != f.FileName || f.Commit.Author != "Your Name <you@example.com>" {
+		t.Errorf("got commit %+v for %s", f.Commit, f.FileName)
+	}
+	if len(f.Branches) != 1 || f.Branches[0] != "master" {
+		t.Errorf("got branches %v, want [master]", f.Branches)
+	}
+
+	if files := search(&query.Type{Type: query.TypeCommit, Child: &query.Substring{Pattern: "CVE-2024-1234"}}); len(files) != 1 {
+		t.Errorf("got %d commits mentioning the CVE, want 1", len(files))
+	}
+	if files := search(&query.Substring{Pattern: "CVE-2024-1234"}); len(files) != 0 {
+		t.Errorf("got %v, want commit messages to be excluded from normal searches", files)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitindex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	git "github.com/go-git/go-git/v5"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/build"
+)
+
+// DiffRepositoryName returns the name of the repository holding the diffs of
+// the repository name, see Options.DiffWindow.
+func DiffRepositoryName(name string) string {
+	return name + "@diffs"
+}
+
+// indexDiffs builds the diff shards of the repository, which hold a document
+// for each file changed by the recent commits of each branch.
+func indexDiffs(opts Options, repo *git.Repository) error {
+	buildOpts := opts.BuildOptions
+	buildOpts.RepositoryDescription.Name = DiffRepositoryName(buildOpts.RepositoryDescription.Name)
+	buildOpts.RepositoryDescription.SubRepoMap = nil
+	buildOpts.SubRepositories = nil
+	buildOpts.IsDelta = false
+	buildOpts.CommitMessages = 0
+	buildOpts.DiffWindow = opts.DiffWindow
+
+	if opts.Incremental && buildOpts.IncrementalSkipIndexing() {
+		return nil
+	}
+
+	docs, err := diffDocuments(repo, buildOpts.RepositoryDescription.Branches, opts)
+	if err != nil {
+		return err
+	}
+
+	builder, err := build.NewBuilder(buildOpts)
+	if err != nil {
+		return fmt.Errorf("build.NewBuilder: %w", err)
+	}
+	for _, doc := range docs {
+		if err := builder.Add(doc); err != nil {
+			builder.Finish() // nolint:errcheck
+			return fmt.Errorf("error adding diff of %s: %w", doc.Name, err)
+		}
+	}
+	return builder.Finish()
+}
+
+// diffDocuments returns a document for each file changed by the commits of
+// each branch made within opts.DiffWindow of the latest commit of the branch.
+// The document is named after the file, and holds the hunks of its diff in
+// unified format. Merge commits are skipped.
+func diffDocuments(repo *git.Repository, branches []zoekt.RepositoryBranch, opts Options) ([]zoekt.Document, error) {
+	var docs []zoekt.Document
+	// SHA1 => indexes in docs
+	seen := map[plumbing.Hash][]int{}
+	for _, br := range branches {
+		iter, err := repo.Log(&git.LogOptions{
+			From:  plumbing.NewHash(br.Version),
+			Order: git.LogOrderCommitterTime,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var first *object.Commit
+		for {
+			c, err := iter.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+			if first == nil {
+				first = c
+			}
+			if first.Committer.When.Sub(c.Committer.When) > opts.DiffWindow {
+				break
+			}
+
+			if idxs, ok := seen[c.Hash]; ok {
+				for _, idx := range idxs {
+					docs[idx].Branches = append(docs[idx].Branches, br.Name)
+				}
+				continue
+			}
+			if c.NumParents() > 1 {
+				seen[c.Hash] = nil
+				continue
+			}
+
+			commitDocs, err := commitDiffDocuments(c)
+			if err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("commit %s: %w", c.Hash, err)
+			}
+			for _, doc := range commitDocs {
+				seen[c.Hash] = append(seen[c.Hash], len(docs))
+				doc.Branches = []string{br.Name}
+				docs = append(docs, doc)
+			}
+		}
+		iter.Close()
+	}
+	return docs, nil
+}
+
+// commitDiffDocuments returns a document for each file changed by c,
+// compared to its first parent.
+func commitDiffDocuments(c *object.Commit) ([]zoekt.Document, error) {
+	var from *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if from, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	to, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, err
+	}
+
+	commit := &zoekt.Commit{
+		SHA:    c.Hash.String(),
+		Author: c.Author.String(),
+		Date:   c.Author.When,
+	}
+
+	var docs []zoekt.Document
+	for _, fp := range patch.FilePatches() {
+		if fp.IsBinary() {
+			continue
+		}
+		fromFile, toFile := fp.Files()
+		name := ""
+		if toFile != nil {
+			name = toFile.Path()
+		} else if fromFile != nil {
+			name = fromFile.Path()
+		}
+
+		hunks, err := diffHunks(fp)
+		if err != nil {
+			return nil, err
+		}
+		if len(hunks) == 0 {
+			continue
+		}
+		docs = append(docs, zoekt.Document{
+			Name:    name,
+			Content: hunks,
+			Commit:  commit,
+			Diff:    true,
+		})
+	}
+	return docs, nil
+}
+
+// singleFilePatch is a diff.Patch for one file.
+type singleFilePatch struct {
+	fp diff.FilePatch
+}
+
+func (p singleFilePatch) FilePatches() []diff.FilePatch { return []diff.FilePatch{p.fp} }
+func (p singleFilePatch) Message() string               { return "" }
+
+// diffHunks returns the hunks of fp in unified format, where added lines
+// start with "+" and removed lines with "-". The file header is left out.
+func diffHunks(fp diff.FilePatch) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := diff.NewUnifiedEncoder(&buf, diff.DefaultContextLines).Encode(singleFilePatch{fp}); err != nil {
+		return nil, err
+	}
+	out := buf.Bytes()
+	if bytes.HasPrefix(out, []byte("@@")) {
+		return out, nil
+	}
+	if i := bytes.Index(out, []byte("\n@@")); i >= 0 {
+		return out[i+1:], nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,102 @@
+package gitindex
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/build"
+	"github.com/google/zoekt/query"
+	"github.com/google/zoekt/shards"
+)
+
+func TestIndexDiffs(t *testing.T) {
+	dir := t.TempDir()
+	// Two commits to afile: "first: add afile" and "second: fix
+	// CVE-2024-1234 in afile".
+	if err := createCommitsRepo(dir); err != nil {
+		t.Fatalf("createCommitsRepo: %v", err)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads",
+		Branches:     []string{"master"},
+		DiffWindow:   time.Hour,
+		Incremental:  true,
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	search := func(q string) []zoekt.FileMatch {
+		t.Helper()
+		pq, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := searcher.Search(context.Background(), pq, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Files
+	}
+
+	for q, want := range map[string]int{
+		"added:acont":   1, // root commit
+		"removed:acont": 1,
+		"added:bcont":   1,
+		"removed:bcont": 0,
+		"bcont":         1, // afile at HEAD
+	} {
+		files := search(q)
+		if len(files) != want {
+			t.Errorf("%s: got %d matches, want %d", q, len(files), want)
+			continue
+		}
+		for _, f := range files {
+			wantRepo := "repo"
+			if f.Commit != nil {
+				wantRepo = DiffRepositoryName("repo")
+			}
+			if f.FileName != "afile" || f.Repository != wantRepo {
+				t.Errorf("%s: got %s in %s", q, f.FileName, f.Repository)
+			}
+		}
+	}
+
+	// Changing the window reindexes the diffs of the unchanged repository.
+	diffOpts := buildOpts
+	diffOpts.RepositoryDescription.Name = DiffRepositoryName("repo")
+	diffOpts.DiffWindow = 2 * time.Hour
+	if state, _ := diffOpts.IndexState(); state != build.IndexStateOption {
+		t.Errorf("got index state %s for the new window, want %s", state, build.IndexStateOption)
+	}
+	opts.DiffWindow = diffOpts.DiffWindow
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+	if repo, ok, err := diffOpts.FindRepositoryMetadata(); err != nil || !ok {
+		t.Fatalf("FindRepositoryMetadata: %v, %v", ok, err)
+	} else if repo.IndexOptions != diffOpts.GetHash() {
+		t.Errorf("got options hash %s after reindexing, want %s", repo.IndexOptions, diffOpts.GetHash())
+	}
+}
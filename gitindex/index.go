@@ -33,6 +33,7 @@ import (
 
 	"github.com/google/zoekt"
 	"github.com/google/zoekt/build"
+	"github.com/google/zoekt/codeowners"
 	"github.com/google/zoekt/ignore"
 
 	"github.com/go-git/go-git/v5/config"
@@ -308,6 +309,18 @@ type Options struct {
 	// If DeltaShardNumberFallbackThreshold is 0, then this fallback behavior is disabled:
 	// a delta build will always be performed regardless of the number of preexisting shards.
 	DeltaShardNumberFallbackThreshold uint64
+
+	// CommitMessages is the number of commits per branch whose messages are
+	// indexed as commit documents, which only match type:commit queries.
+	// Commit messages are not supported in delta builds, which fall back to
+	// normal builds.
+	CommitMessages int
+
+	// DiffWindow, if positive, also builds separate shards for the
+	// repository named DiffRepositoryName(name), holding the diffs of the
+	// commits made within DiffWindow of the latest commit of each branch.
+	// The diffs are searched with the added: and removed: queries.
+	DiffWindow time.Duration
 }
 
 func expandBranches(repo *git.Repository, bs []string, prefix string) ([]string, error) {
@@ -378,6 +391,8 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 
 	// Set max thresholds, since we use them in this function.
 	opts.BuildOptions.SetDefaults()
+	// Changing the commit messages changes the shards.
+	opts.BuildOptions.CommitMessages = opts.CommitMessages
 	if opts.RepoDir == "" {
 		return fmt.Errorf("gitindex: must set RepoDir")
 	}
@@ -396,6 +411,9 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 	if err != nil {
 		return fmt.Errorf("expandBranches: %w", err)
 	}
+
+	// File owners and the ranking config are taken from the first branch.
+	var owners *codeowners.Ruleset
 	for _, b := range branches {
 		commit, err := getCommit(repo, opts.BranchPrefix, b)
 		if err != nil {
@@ -406,6 +424,23 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 			return fmt.Errorf("getCommit: %w", err)
 		}
 
+		if owners == nil {
+			tree, err := commit.Tree()
+			if err != nil {
+				return fmt.Errorf("commit.Tree: %w", err)
+			}
+			if owners, err = newCodeOwners(tree); err != nil {
+				return fmt.Errorf("newCodeOwners: %w", err)
+			}
+			// A broken config shouldn't stop the repository from being
+			// indexed, so it keeps the ranking it would have without it.
+			if rankConfig, err := newRankConfig(tree); err != nil {
+				log.Printf("ignoring %s of %s: %v", build.RankConfigFile, opts.RepoDir, err)
+			} else if rankConfig != nil {
+				opts.BuildOptions.RankConfig = rankConfig
+			}
+		}
+
 		opts.BuildOptions.RepositoryDescription.Branches = append(opts.BuildOptions.RepositoryDescription.Branches, zoekt.RepositoryBranch{
 			Name:    b,
 			Version: commit.Hash.String(),
@@ -416,6 +451,16 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 		}
 	}
 
+	if owners == nil {
+		owners = &codeowners.Ruleset{}
+	}
+
+	if opts.DiffWindow > 0 {
+		if err := indexDiffs(opts, repo); err != nil {
+			return fmt.Errorf("indexDiffs: %w", err)
+		}
+	}
+
 	if opts.Incremental && opts.BuildOptions.IncrementalSkipIndexing() {
 		return nil
 	}
@@ -435,6 +480,11 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 	// These only have an effect on delta builds
 	var changedOrRemovedFiles []string
 
+	if opts.BuildOptions.IsDelta && opts.CommitMessages > 0 {
+		log.Printf("delta build: falling back to normal build since commit messages are not supported in delta builds, repository=%q", opts.BuildOptions.RepositoryDescription.Name)
+		opts.BuildOptions.IsDelta = false
+	}
+
 	if opts.BuildOptions.IsDelta {
 		repos, branchMap, branchVersions, changedOrRemovedFiles, err = prepareDeltaBuild(opts, repo)
 		if err != nil {
@@ -516,6 +566,7 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 					Name:              key.FullPath(),
 					Branches:          brs,
 					SubRepositoryPath: key.SubRepoPath,
+					Owners:            owners.Owners(key.FullPath()),
 				}); err != nil {
 					return err
 				}
@@ -531,14 +582,70 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 				Name:              key.FullPath(),
 				Content:           contents,
 				Branches:          brs,
+				Owners:            owners.Owners(key.FullPath()),
 			}); err != nil {
 				return fmt.Errorf("error adding document with name %s: %w", key.FullPath(), err)
 			}
 		}
 	}
+
+	if opts.CommitMessages > 0 {
+		docs, err := commitDocuments(repo, opts.BuildOptions.RepositoryDescription.Branches, opts.CommitMessages)
+		if err != nil {
+			return fmt.Errorf("commitDocuments: %w", err)
+		}
+		for _, doc := range docs {
+			if err := builder.Add(doc); err != nil {
+				return fmt.Errorf("error adding commit %s: %w", doc.Name, err)
+			}
+		}
+	}
 	return builder.Finish()
 }
 
+// commitDocuments returns a document for each of the last n commits of each
+// branch. The document is named by the commit SHA1 and holds the commit
+// message.
+func commitDocuments(repo *git.Repository, branches []zoekt.RepositoryBranch, n int) ([]zoekt.Document, error) {
+	var docs []zoekt.Document
+	// SHA1 => index in docs
+	seen := map[plumbing.Hash]int{}
+	for _, br := range branches {
+		iter, err := repo.Log(&git.LogOptions{From: plumbing.NewHash(br.Version)})
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			c, err := iter.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+
+			if idx, ok := seen[c.Hash]; ok {
+				docs[idx].Branches = append(docs[idx].Branches, br.Name)
+				continue
+			}
+			seen[c.Hash] = len(docs)
+			docs = append(docs, zoekt.Document{
+				Name:     c.Hash.String(),
+				Content:  []byte(c.Message),
+				Branches: []string{br.Name},
+				Commit: &zoekt.Commit{
+					SHA:    c.Hash.String(),
+					Author: c.Author.String(),
+					Date:   c.Author.When,
+				},
+			})
+		}
+		iter.Close()
+	}
+	return docs, nil
+}
+
 func newIgnoreMatcher(tree *object.Tree) (*ignore.Matcher, error) {
 	ignoreFile, err := tree.File(ignore.IgnoreFile)
 	if err == object.ErrFileNotFound {
@@ -554,6 +661,51 @@ func newIgnoreMatcher(tree *object.Tree) (*ignore.Matcher, error) {
 	return ignore.ParseIgnoreFile(strings.NewReader(content))
 }
 
+// newCodeOwners returns the owners from the first CODEOWNERS file found in
+// tree. If there is none, no file has owners.
+func newCodeOwners(tree *object.Tree) (*codeowners.Ruleset, error) {
+	for _, name := range codeowners.Files {
+		f, err := tree.File(name)
+		if err == object.ErrFileNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return nil, err
+		}
+		return codeowners.Parse(strings.NewReader(content))
+	}
+	return &codeowners.Ruleset{}, nil
+}
+
+// newRankConfig returns the ranking config in tree, or nil if there is none.
+func newRankConfig(tree *object.Tree) (*build.RankConfig, error) {
+	f, err := tree.File(build.RankConfigFile)
+	if err == object.ErrFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return build.ParseRankConfig(strings.NewReader(content))
+}
+
+func isCodeOwnersFile(path string) bool {
+	for _, name := range codeowners.Files {
+		if path == name {
+			return true
+		}
+	}
+	return false
+}
+
 // prepareDeltaBuildFunc is a function that calculates the necessary metadata for preparing
 // a build.Builder instance for generating a delta build.
 type prepareDeltaBuildFunc func(options Options, repository *git.Repository) (repos map[fileKey]BlobLocation, branchMap map[fileKey][]string, branchVersions map[string]map[string]plumbing.Hash, changedOrDeletedPaths []string, err error)
@@ -704,6 +856,11 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 					return nil, nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
 				}
 
+				// Owners of unchanged files would be stale.
+				if isCodeOwnersFile(newFileRelativeRootPath) {
+					return nil, nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", newFileRelativeRootPath)
+				}
+
 				// either file is added or renamed, so we need to add the new version to the build
 				file := fileKey{Path: newFileRelativeRootPath, ID: newFile.Hash}
 				repos[file] = hackSharedBlobLocation
@@ -723,6 +880,10 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 				return nil, nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
 			}
 
+			if isCodeOwnersFile(oldFileRelativeRootPath) {
+				return nil, nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", oldFileRelativeRootPath)
+			}
+
 			// The file is either modified or deleted. So, we need to add ALL versions
 			// of the old file (across all branches) to the build.
 			for b, currentTree := range branchToCurrentTree {
@@ -0,0 +1,143 @@
+package gitindex
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/build"
+	"github.com/google/zoekt/query"
+	"github.com/google/zoekt/shards"
+)
+
+const testRankConfig = "rules:\n  - patterns: ['**/*.go']\n"
+
+func createRankRepo(dir, config string) error {
+	script := `mkdir repo
+cd repo
+git init
+mkdir -p .zoekt
+echo cont > afile.go
+echo cont > bfile.md
+printf "` + config + `" > .zoekt/rank.yaml
+git add afile.go bfile.md .zoekt/rank.yaml
+git config user.email "you@example.com"
+git config user.name "Your Name"
+git commit -am amsg
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("execution error: %v, output %s", err, out)
+	}
+	return nil
+}
+
+func TestRankConfigFromRepo(t *testing.T) {
+	dir := t.TempDir()
+	if err := createRankRepo(dir, testRankConfig); err != nil {
+		t.Fatalf("createRankRepo: %v", err)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads",
+		Branches:     []string{"master"},
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	// The config puts Go files last.
+	res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "cont", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName)
+	}
+	if want := []string{"bfile.md", "afile.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	rl, err := searcher.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 {
+		t.Fatalf("got %d repos, want 1", len(rl.Repos))
+	}
+	buildOpts.RankConfig, err = build.ParseRankConfig(strings.NewReader(testRankConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rl.Repos[0].Repository.IndexOptions, buildOpts.GetHash(); got != want {
+		t.Errorf("got index options %s, want %s", got, want)
+	}
+}
+
+func TestRankConfigFromRepo_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	if err := createRankRepo(dir, "rules: ["); err != nil {
+		t.Fatalf("createRankRepo: %v", err)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads",
+		Branches:     []string{"master"},
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	rl, err := searcher.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 {
+		t.Fatalf("got %d repos, want 1", len(rl.Repos))
+	}
+	// The repository is indexed with the default ranking.
+	if got, want := rl.Repos[0].Repository.IndexOptions, buildOpts.GetHash(); got != want {
+		t.Errorf("got index options %s, want %s", got, want)
+	}
+}
@@ -472,6 +472,49 @@ func TestAndSearch(t *testing.T) {
 	})
 }
 
+func TestNearSearch(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("acquire()\nwork()\nrelease()\n")},
+		Document{Name: "f2", Content: []byte("acquire()\na\nb\nc\nd\nrelease()\n")},
+		Document{Name: "f3", Content: []byte("release()\nacquire() // release")},
+		Document{Name: "f4", Content: []byte("acquire()\n")},
+	)
+
+	near := func(dist uint32, inBytes bool, patterns ...string) query.Q {
+		q := &query.Near{Distance: dist, Bytes: inBytes}
+		for _, p := range patterns {
+			q.Children = append(q.Children, &query.Substring{Pattern: p})
+		}
+		return q
+	}
+
+	for _, tc := range []struct {
+		q    query.Q
+		want []string
+	}{
+		{q: near(0, false, "acquire", "release"), want: []string{"f3"}},
+		{q: near(2, false, "acquire", "release"), want: []string{"f1", "f3"}},
+		{q: near(5, false, "acquire", "release"), want: []string{"f1", "f2", "f3"}},
+		{q: near(1, false, "work", "release"), want: []string{"f1"}},
+		{q: near(1, false, "acquire", "work", "release"), want: nil},
+		{q: near(2, false, "acquire", "work", "release"), want: []string{"f1"}},
+		// "work" ends at byte 14, "release" starts at byte 17.
+		{q: near(3, true, "work", "release"), want: []string{"f1"}},
+		{q: near(2, true, "work", "release"), want: nil},
+	} {
+		t.Run(tc.q.String(), func(t *testing.T) {
+			sres := searchForTest(t, b, tc.q)
+			var got []string
+			for _, f := range sres.Files {
+				got = append(got, f.FileName)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestAndNegateSearch(t *testing.T) {
 	b := testIndexBuilder(t, nil,
 		Document{Name: "f1", Content: []byte("x banana y")},
@@ -1022,22 +1065,59 @@ func TestBranchMask(t *testing.T) {
 	})
 }
 
-func TestBranchLimit(t *testing.T) {
-	for limit := 64; limit <= 65; limit++ {
-		r := &Repository{}
-		for i := 0; i < limit; i++ {
-			s := fmt.Sprintf("b%d", i)
-			r.Branches = append(r.Branches, RepositoryBranch{
-				s, "v-" + s,
-			})
+func TestManyBranches(t *testing.T) {
+	r := &Repository{}
+	for i := 0; i < 130; i++ {
+		s := fmt.Sprintf("b%d", i)
+		r.Branches = append(r.Branches, RepositoryBranch{
+			s, "v-" + s,
+		})
+	}
+	b := testIndexBuilder(t, r,
+		Document{Name: "f1", Content: []byte("needle"), Branches: []string{"b0"}},
+		Document{Name: "f2", Content: []byte("needle"), Branches: []string{"b1", "b70"}},
+		Document{Name: "f3", Content: []byte("needle"), Branches: []string{"b70", "b129"}},
+	)
+
+	for _, tc := range []struct {
+		branch string
+		want   []string
+	}{
+		{"b0", []string{"f1"}},
+		{"b70", []string{"f2", "f3"}},
+		{"b129", []string{"f3"}},
+		{"b128", nil},
+	} {
+		sres := searchForTest(t, b, query.NewAnd(
+			&query.Substring{Pattern: "needle"},
+			&query.Branch{Pattern: tc.branch, Exact: true}))
+
+		var got []string
+		for _, f := range sres.Files {
+			got = append(got, f.FileName)
+			if len(f.Branches) != 1 || f.Branches[0] != tc.branch {
+				t.Errorf("%s: got branches %v for %s, want [%s]", tc.branch, f.Branches, f.FileName, tc.branch)
+			}
 		}
-		_, err := NewIndexBuilder(r)
-		if limit == 64 && err != nil {
-			t.Fatalf("NewIndexBuilder: %v", err)
-		} else if limit == 65 && err == nil {
-			t.Fatalf("NewIndexBuilder succeeded")
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.branch, got, tc.want)
 		}
 	}
+
+	// Without a branch query all branches of a document are reported.
+	sres := searchForTest(t, b, &query.Substring{Pattern: "needle"})
+	gotBranches := map[string][]string{}
+	for _, f := range sres.Files {
+		gotBranches[f.FileName] = f.Branches
+	}
+	wantBranches := map[string][]string{
+		"f1": {"b0"},
+		"f2": {"b1", "b70"},
+		"f3": {"b70", "b129"},
+	}
+	if !reflect.DeepEqual(gotBranches, wantBranches) {
+		t.Errorf("got branches %v, want %v", gotBranches, wantBranches)
+	}
 }
 
 func TestBranchReport(t *testing.T) {
@@ -1777,7 +1857,7 @@ func TestListRepos(t *testing.T) {
 			Stats: RepoStats{
 				Shards:                     1,
 				Documents:                  4,
-				IndexBytes:                 300,
+				IndexBytes:                 308,
 				ContentBytes:               68,
 				NewLinesCount:              4,
 				DefaultBranchNewLinesCount: 2,
@@ -1786,7 +1866,7 @@ func TestListRepos(t *testing.T) {
 		}
 
 		if os.Getenv("ZOEKT_ENABLE_NGRAM_BS") != "" {
-			want.Stats.IndexBytes = 228
+			want.Stats.IndexBytes = 236
 		}
 
 		if diff := cmp.Diff(want, res); diff != "" {
@@ -3575,3 +3655,36 @@ func TestStats(t *testing.T) {
 
 	})
 }
+
+func TestManyReposCompound(t *testing.T) {
+	// More repositories than fit into a 16-bit repo index.
+	n := 1<<16 + 10
+	repos := make([]*Repository, 0, n)
+	docs := make([][]Document, 0, n)
+	for i := 0; i < n; i++ {
+		repos = append(repos, &Repository{Name: fmt.Sprintf("repo%d", i)})
+		content := "haystack"
+		if i == 5 || i == n-1 {
+			content = "needle"
+		}
+		docs = append(docs, []Document{{Name: "f", Content: []byte(content)}})
+	}
+	b := testIndexBuilderCompound(t, repos, docs)
+
+	sres := searchForTest(t, b, &query.Substring{Pattern: "needle"})
+	var got []string
+	for _, f := range sres.Files {
+		got = append(got, f.Repository)
+	}
+	want := []string{"repo5", fmt.Sprintf("repo%d", n-1)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	sres = searchForTest(t, b, query.NewAnd(
+		&query.Substring{Pattern: "needle"},
+		&query.RepoSet{Set: map[string]bool{fmt.Sprintf("repo%d", n-1): true}}))
+	if len(sres.Files) != 1 || sres.Files[0].Repository != want[1] {
+		t.Fatalf("got %v, want 1 match in %s", sres.Files, want[1])
+	}
+}
@@ -170,11 +170,11 @@ type IndexBuilder struct {
 
 	checksums []byte
 
-	branchMasks []uint64
+	branchMasks []branchMask
 	subRepos    []uint32
 
 	// docID => repoID
-	repos []uint16
+	repos []uint32
 
 	contentPostings *postingsBuilder
 	namePostings    *postingsBuilder
@@ -194,12 +194,29 @@ type IndexBuilder struct {
 	// language codes, uint16 encoded as little-endian
 	languages []uint8
 
+	// owner => owner ID
+	ownerMap map[string]uint32
+
+	// sorted owner IDs for each document
+	fileOwners [][]uint32
+
+	// commit for each document, nil unless it is a commit message
+	fileCommits []*Commit
+	hasCommits  bool
+
+	// sorted IDs of the diff documents
+	diffDocs []uint32
+
 	// IndexTime will be used as the time if non-zero. Otherwise
 	// time.Now(). This is useful for doing reproducible builds in tests.
 	IndexTime time.Time
 
 	// a sortable 20 chars long id.
 	ID string
+
+	// CompressContents stores file contents as snappy compressed blocks,
+	// which are decompressed on demand when searching.
+	CompressContents bool
 }
 
 func (d *Repository) verify() error {
@@ -245,6 +262,7 @@ func newIndexBuilder() *IndexBuilder {
 		symIndex:        make(map[string]uint32),
 		symKindIndex:    make(map[string]uint32),
 		languageMap:     map[string]uint16{},
+		ownerMap:        map[string]uint32{},
 	}
 }
 
@@ -253,10 +271,6 @@ func (b *IndexBuilder) setRepository(desc *Repository) error {
 		return err
 	}
 
-	if len(desc.Branches) > 64 {
-		return fmt.Errorf("too many branches")
-	}
-
 	repo := *desc
 
 	// copy subrepomap without root
@@ -291,6 +305,20 @@ type Document struct {
 	// Document sections for symbols. Offsets should use bytes.
 	Symbols         []DocumentSection
 	SymbolsMetaData []*Symbol
+
+	// Owners of the file, eg. from a CODEOWNERS file. These are matched
+	// by owner: queries.
+	Owners []string
+
+	// Commit is set if the document is a commit message, or the diff of a
+	// file in a commit, instead of a file. Such commit documents only match
+	// type:commit and diff queries.
+	Commit *Commit
+
+	// Diff marks a commit document that holds the diff of a file in
+	// Commit rather than its message. Diffs only match diff queries, and
+	// commit messages only type:commit queries.
+	Diff bool
 }
 
 type symbolSlice struct {
@@ -411,6 +439,10 @@ func (b *IndexBuilder) addSymbols(symbols []*Symbol) {
 
 // Add a file which only occurs in certain branches.
 func (b *IndexBuilder) Add(doc Document) error {
+	if doc.Diff && doc.Commit == nil {
+		return fmt.Errorf("diff %s has no commit", doc.Name)
+	}
+
 	hasher := crc64.New(crc64.MakeTable(crc64.ISO))
 
 	if idx := bytes.IndexByte(doc.Content, 0); idx >= 0 {
@@ -474,21 +506,17 @@ func (b *IndexBuilder) Add(doc Document) error {
 		return fmt.Errorf("unknown subrepo path %q", doc.SubRepositoryPath)
 	}
 
-	var mask uint64
+	var mask branchMask
 	for _, br := range doc.Branches {
-		m := b.branchMask(br)
-		if m == 0 {
+		idx := b.branchIndex(br)
+		if idx < 0 {
 			return fmt.Errorf("no branch found for %s", br)
 		}
-		mask |= m
-	}
-
-	if repoIdx > 1<<16 {
-		return fmt.Errorf("too many repos in shard: max is %d", 1<<16)
+		mask.set(idx)
 	}
 
 	b.subRepos = append(b.subRepos, subRepoIdx)
-	b.repos = append(b.repos, uint16(repoIdx))
+	b.repos = append(b.repos, uint32(repoIdx))
 
 	hasher.Write(doc.Content)
 
@@ -511,14 +539,58 @@ func (b *IndexBuilder) Add(doc Document) error {
 	}
 	b.languages = append(b.languages, uint8(langCode), uint8(langCode>>8))
 
+	b.fileOwners = append(b.fileOwners, b.ownerIDs(doc.Owners))
+	b.fileCommits = append(b.fileCommits, doc.Commit)
+	b.hasCommits = b.hasCommits || doc.Commit != nil
+	if doc.Diff {
+		b.diffDocs = append(b.diffDocs, uint32(len(b.fileCommits)-1))
+	}
+
 	return nil
 }
 
-func (b *IndexBuilder) branchMask(br string) uint64 {
+// ownerIDs returns the sorted IDs of owners, assigning new IDs as needed.
+func (b *IndexBuilder) ownerIDs(owners []string) []uint32 {
+	if len(owners) == 0 {
+		return nil
+	}
+	ids := make([]uint32, 0, len(owners))
+	for _, o := range owners {
+		id, ok := b.ownerMap[o]
+		if !ok {
+			id = uint32(len(b.ownerMap))
+			b.ownerMap[o] = id
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	uniq := ids[:1]
+	for _, id := range ids[1:] {
+		if id != uniq[len(uniq)-1] {
+			uniq = append(uniq, id)
+		}
+	}
+	return uniq
+}
+
+func (b *IndexBuilder) branchIndex(br string) int {
 	for i, b := range b.repoList[len(b.repoList)-1].Branches {
 		if b.Name == br {
-			return uint64(1) << uint(i)
+			return i
+		}
+	}
+	return -1
+}
+
+// branchMaskWords returns the number of 64-bit words needed to store the
+// branch mask of each document.
+func (b *IndexBuilder) branchMaskWords() int {
+	words := 1
+	for _, m := range b.branchMasks {
+		if len(m) > words {
+			words = len(m)
 		}
 	}
-	return 0
+	return words
 }
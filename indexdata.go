@@ -51,6 +51,10 @@ type indexData struct {
 	boundariesStart uint32
 	boundaries      []uint32
 
+	// contentBlocks is set if the file contents are block compressed, in
+	// which case boundaries are offsets into the uncompressed content.
+	contentBlocks *contentBlocks
+
 	// rune offsets for the file content boundaries
 	fileEndRunes []uint32
 
@@ -67,12 +71,20 @@ type indexData struct {
 	// rune offsets for the file name boundaries
 	fileNameEndRunes []uint32
 
+	// fileBranchMasks holds the first 64 bits of the branch mask of each
+	// document, see branchMask.
 	fileBranchMasks []uint64
 
-	// mask (power of 2) => name
+	// fileBranchMasksWide holds the remaining words of the branch masks,
+	// branchMaskWideWords per document. Only shards with a repository of more
+	// than 64 branches have them.
+	fileBranchMasksWide []uint64
+	branchMaskWideWords int
+
+	// branch index => name
 	branchNames []map[uint]string
 
-	// name => mask (power of 2)
+	// name => branch index
 	branchIDs []map[string]uint
 
 	metaData     IndexMetadata
@@ -90,10 +102,27 @@ type indexData struct {
 	// inverse of LanguageMap in metaData
 	languageMap map[uint16]string
 
+	// owner ID => owner
+	ownerNames []string
+
+	// fileOwnersIndex holds the offsets of the owner IDs of each document,
+	// relative to fileOwnersStart. It is empty if no document has owners.
+	fileOwnersStart uint32
+	fileOwnersIndex []uint32
+
+	// fileCommitsIndex holds the offsets of the commit of each document,
+	// relative to fileCommitsStart. It is empty if there are no commit
+	// messages.
+	fileCommitsStart uint32
+	fileCommitsIndex []uint32
+
+	// fileDiffs holds the sorted IDs of the diff documents.
+	fileDiffs []uint32
+
 	repoListEntry []RepoListEntry
 
 	// repository indexes for all the files
-	repos []uint16
+	repos []uint32
 
 	// rawConfigMasks contains the encoded RawConfig for each repository
 	rawConfigMasks []uint8
@@ -189,6 +218,16 @@ func (d *indexData) calculateStatsForFileRange(start, end uint32) RepoStats {
 	bytesFN := d.fileNameIndex[end] - d.fileNameIndex[start]
 	count, defaultCount, otherCount := d.calculateNewLinesStats(start, end)
 
+	// Blocks can span repositories, so the compressed size of a range is
+	// attributed proportionally to its uncompressed size.
+	contentBytes := int64(bytesContent)
+	var compressed, uncompressed int64
+	if d.contentBlocks != nil && d.contentBlocks.size > 0 {
+		uncompressed = contentBytes
+		compressed = int64(d.contentBlocks.compressedSize()) * uncompressed / int64(d.contentBlocks.size)
+		contentBytes = compressed
+	}
+
 	// CR keegan for stefan: I think we may want to restructure RepoListEntry so
 	// that we don't change anything, except we have
 	// []Repository. Alternatively, things we can divide up we do (like
@@ -196,13 +235,16 @@ func (d *indexData) calculateStatsForFileRange(start, end uint32) RepoStats {
 	// after aggregation. For now I will move forward with this until we can
 	// chat more.
 	return RepoStats{
-		ContentBytes: int64(bytesContent) + int64(bytesFN),
+		ContentBytes: contentBytes + int64(bytesFN),
 		Documents:    int(end - start),
 		// CR keegan for stefan: our shard count is going to go out of whack,
 		// since we will aggregate these. So we will report more shards than are
 		// present on disk. What should we do?
 		Shards: 1,
 
+		CompressedContentBytes:   compressed,
+		UncompressedContentBytes: uncompressed,
+
 		// Sourcegraph specific
 		NewLinesCount:              count,
 		DefaultBranchNewLinesCount: defaultCount,
@@ -216,10 +258,10 @@ func (d *indexData) calculateStats() error {
 
 	for repoID, md := range d.repoMetaData {
 		// determine the file range for repo i
-		for end < uint32(len(d.repos)) && d.repos[end] == uint16(repoID) {
+		for end < uint32(len(d.repos)) && d.repos[end] == uint32(repoID) {
 			end++
 		}
-		if start < end && d.repos[start] != uint16(repoID) {
+		if start < end && d.repos[start] != uint32(repoID) {
 			return fmt.Errorf("shard documents out of order with respect to repositories: expected document %d to be part of repo %d", start, repoID)
 		}
 
@@ -262,6 +304,9 @@ func (d *indexData) calculateNewLinesStats(start, end uint32) (count, defaultCou
 		branchMask := d.fileBranchMasks[i]
 		isDefault := (branchMask & 1) == 1
 		others := uint64(bits.OnesCount64(branchMask >> 1))
+		for _, w := range d.wideBranchMask(i) {
+			others += uint64(bits.OnesCount64(w))
+		}
 
 		// this is readNewlines but only reading the size of each section which
 		// corresponds to the number of newlines.
@@ -303,7 +348,7 @@ func (d *indexData) memoryUse() int {
 		d.boundaries, d.fileNameIndex,
 		d.fileEndRunes, d.fileNameEndRunes,
 		d.fileEndSymbol, d.symbols.symKindIndex,
-		d.subRepos,
+		d.subRepos, d.fileOwnersIndex, d.fileCommitsIndex, d.fileDiffs,
 	} {
 		sz += 4 * len(a)
 	}
@@ -311,9 +356,10 @@ func (d *indexData) memoryUse() int {
 	sz += d.fileNameRuneOffsets.sizeBytes()
 	sz += len(d.languages)
 	sz += len(d.checksums)
-	sz += 2 * len(d.repos)
+	sz += 4 * len(d.repos)
 	sz += 8 * len(d.runeDocSections)
 	sz += 8 * len(d.fileBranchMasks)
+	sz += 8 * len(d.fileBranchMasksWide)
 	sz += d.ngrams.SizeBytes()
 	sz += 12 * len(d.fileNameNgrams) // these slices reference mmap-ed memory
 	return sz
@@ -360,6 +406,10 @@ type ngramIterationResults struct {
 	fileName      bool
 	substrBytes   []byte
 	substrLowered []byte
+
+	// explanation records how the iterator was chosen, for
+	// SearchOptions.Explain.
+	explanation NgramExplanation
 }
 
 func (r *ngramIterationResults) String() string {
@@ -379,6 +429,10 @@ func (r *ngramIterationResults) candidates() []*candidateMatch {
 
 func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResults, error) {
 	str := query.Pattern
+	explanation := NgramExplanation{
+		Pattern:  query.Pattern,
+		FileName: query.FileName,
+	}
 
 	if len(query.Pattern) >= bloomHashMinWordLength {
 		// test against appropriate content or filename bloom filters
@@ -390,10 +444,12 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 			match = d.bloomContents.maybeHasBytes(pat)
 		}
 		if !match {
+			explanation.NoMatch = "bloomfilter"
 			return &ngramIterationResults{
 				matchIterator: &noMatchTree{
 					Why: "bloomfilter",
 				},
+				explanation: explanation,
 			}, nil
 		}
 	}
@@ -412,24 +468,38 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 		}
 
 		if freq == 0 {
+			explanation.Ngrams = []string{o.ngram.String()}
+			explanation.Frequencies = []uint32{0}
+			explanation.NoMatch = "freq=0"
 			return &ngramIterationResults{
 				matchIterator: &noMatchTree{
 					Why: "freq=0",
 				},
+				explanation: explanation,
 			}, nil
 		}
 
 		frequencies = append(frequencies, freq)
 	}
 	firstI := firstMinarg(frequencies)
+	firstFreq := frequencies[firstI]
 	frequencies[firstI] = maxUInt32
 	lastI := lastMinarg(frequencies)
+	lastFreq := frequencies[lastI]
 	if firstI > lastI {
 		lastI, firstI = firstI, lastI
+		lastFreq, firstFreq = firstFreq, lastFreq
 	}
 
 	firstNG := ngramOffs[firstI].ngram
 	lastNG := ngramOffs[lastI].ngram
+	if firstI != lastI {
+		explanation.Ngrams = []string{firstNG.String(), lastNG.String()}
+		explanation.Frequencies = []uint32{firstFreq, lastFreq}
+	} else {
+		explanation.Ngrams = []string{lastNG.String()}
+		explanation.Frequencies = []uint32{firstFreq}
+	}
 	iter := &ngramDocIterator{
 		leftPad:  firstI,
 		rightPad: uint32(utf8.RuneCountInString(str)) - firstI,
@@ -464,6 +534,7 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 		fileName:      query.FileName,
 		substrBytes:   patBytes,
 		substrLowered: lowerPatBytes,
+		explanation:   explanation,
 	}, nil
 }
 
@@ -476,6 +547,9 @@ func (d *indexData) numDocs() uint32 {
 }
 
 func (s *indexData) Close() {
+	if s.contentBlocks != nil {
+		s.contentBlocks.close()
+	}
 	s.file.Close()
 }
 
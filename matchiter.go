@@ -35,6 +35,33 @@ type candidateMatch struct {
 	runeOffset  uint32
 	byteOffset  uint32
 	byteMatchSz uint32
+
+	// captures are the groups captured by a regexp match, if
+	// SearchOptions.CaptureGroups is set.
+	captures []capture
+}
+
+// capture is the byte range captured by a group of a regexp.
+type capture struct {
+	group      int
+	start, end uint32
+}
+
+// submatches returns the captures of m that start within m, which may be a
+// line of a longer match. data is the filename or file contents.
+func (m *candidateMatch) submatches(data []byte) []Submatch {
+	var sm []Submatch
+	for _, c := range m.captures {
+		if c.start < m.byteOffset || c.start > m.byteOffset+m.byteMatchSz {
+			continue
+		}
+		sm = append(sm, Submatch{
+			Group:  c.group,
+			Offset: c.start,
+			Value:  string(data[c.start:c.end]),
+		})
+	}
+	return sm
 }
 
 // Matches content against the substring, and populates byteMatchSz on success
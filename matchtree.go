@@ -112,6 +112,14 @@ type andMatchTree struct {
 	children []matchTree
 }
 
+// nearMatchTree matches if all children have content matches within distance
+// lines (or bytes) of each other.
+type nearMatchTree struct {
+	andMatchTree
+	distance uint32
+	bytes    bool
+}
+
 type orMatchTree struct {
 	children []matchTree
 }
@@ -135,6 +143,10 @@ type regexpMatchTree struct {
 
 	fileName bool
 
+	// captures is set to record the ranges captured by the groups of
+	// regexp.
+	captures bool
+
 	// mutable
 	reEvaluated bool
 	found       []*candidateMatch
@@ -156,9 +168,10 @@ type substrMatchTree struct {
 }
 
 type branchQueryMatchTree struct {
-	fileMasks []uint64
-	masks     []uint64
-	repos     []uint16
+	d *indexData
+
+	// masks[repoIdx] are the branches of repository repoIdx to match.
+	masks []branchMask
 
 	// mutable
 	firstDone bool
@@ -390,8 +403,8 @@ func (t *branchQueryMatchTree) nextDoc() uint32 {
 		start = t.docID + 1
 	}
 
-	for i := start; i < uint32(len(t.fileMasks)); i++ {
-		if (t.masks[t.repos[i]] & t.fileMasks[i]) != 0 {
+	for i := start; i < t.d.numDocs(); i++ {
+		if t.d.docInBranches(i, t.masks[t.d.repos[i]]) {
 			return i
 		}
 	}
@@ -420,6 +433,14 @@ func (t *regexpMatchTree) String() string {
 	return fmt.Sprintf("%sre(%s)", f, t.regexp)
 }
 
+func (t *nearMatchTree) String() string {
+	unit := ""
+	if t.bytes {
+		unit = "b"
+	}
+	return fmt.Sprintf("near(%d%s)%v", t.distance, unit, t.children)
+}
+
 func (t *orMatchTree) String() string {
 	return fmt.Sprintf("or%v", t.children)
 }
@@ -471,6 +492,8 @@ func visitMatchTree(t matchTree, f func(matchTree)) {
 		}
 	case *andLineMatchTree:
 		visitMatchTree(&s.andMatchTree, f)
+	case *nearMatchTree:
+		visitMatchTree(&s.andMatchTree, f)
 	case *noVisitMatchTree:
 		visitMatchTree(s.matchTree, f)
 	case *notMatchTree:
@@ -498,6 +521,8 @@ func visitMatches(t matchTree, known map[matchTree]bool, f func(matchTree)) {
 		}
 	case *andLineMatchTree:
 		visitMatches(&s.andMatchTree, known, f)
+	case *nearMatchTree:
+		visitMatches(&s.andMatchTree, known, f)
 	case *orMatchTree:
 		for _, ch := range s.children {
 			if known[ch] {
@@ -608,6 +633,70 @@ nextLine:
 	return false, true
 }
 
+// matches checks the proximity of the children's content matches once all
+// children are known to match. Each match is a [lo, hi] interval of line
+// numbers (or byte offsets), and we look for one match per child such that
+// max(lo) - min(hi) <= distance.
+func (t *nearMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) (bool, bool) {
+	matches, sure := t.andMatchTree.matches(cp, cost, known)
+	if !(sure && matches) {
+		return matches, sure
+	}
+
+	type interval struct {
+		lo, hi int
+	}
+
+	var nl newlines
+	if !t.bytes {
+		nl = cp.newlines()
+	}
+	children := make([][]interval, 0, len(t.children))
+	for _, child := range t.children {
+		var ivs []interval
+		for _, c := range gatherMatches(child, known, false) {
+			if c.fileName {
+				continue
+			}
+			start, end := int(c.byteOffset), int(c.byteOffset+c.byteMatchSz)
+			if !t.bytes {
+				start, _, _ = nl.atOffset(c.byteOffset)
+				end, _, _ = nl.atOffset(c.byteOffset + c.byteMatchSz)
+			}
+			ivs = append(ivs, interval{start, end})
+		}
+		if len(ivs) == 0 {
+			// This child only matched the file name or has no positive
+			// matches (eg. a negation), so there is nothing to measure.
+			return false, true
+		}
+		children = append(children, ivs)
+	}
+
+	// Advance the child whose current match ends first until the window
+	// fits. gatherMatches returns non-overlapping matches in document order,
+	// so both lo and hi are increasing within a child.
+	for {
+		maxLo, minHi, minChild := -1, -1, 0
+		for j, ivs := range children {
+			if ivs[0].lo > maxLo {
+				maxLo = ivs[0].lo
+			}
+			if minHi == -1 || ivs[0].hi < minHi {
+				minHi = ivs[0].hi
+				minChild = j
+			}
+		}
+		if maxLo-minHi <= int(t.distance) {
+			return true, true
+		}
+		children[minChild] = children[minChild][1:]
+		if len(children[minChild]) == 0 {
+			return false, true
+		}
+	}
+}
+
 func (t *andMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) (bool, bool) {
 	sure := true
 
@@ -642,7 +731,7 @@ func (t *orMatchTree) matches(cp *contentProvider, cost int, known map[matchTree
 }
 
 func (t *branchQueryMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) (bool, bool) {
-	return t.fileMasks[t.docID]&t.masks[t.repos[t.docID]] != 0, true
+	return t.d.docInBranches(t.docID, t.masks[t.d.repos[t.docID]]), true
 }
 
 func (t *regexpMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) (bool, bool) {
@@ -655,7 +744,12 @@ func (t *regexpMatchTree) matches(cp *contentProvider, cost int, known map[match
 	}
 
 	cp.stats.RegexpsConsidered++
-	idxs := t.regexp.FindAllIndex(cp.data(t.fileName), -1)
+	var idxs [][]int
+	if t.captures && t.regexp.NumSubexp() > 0 {
+		idxs = t.regexp.FindAllSubmatchIndex(cp.data(t.fileName), -1)
+	} else {
+		idxs = t.regexp.FindAllIndex(cp.data(t.fileName), -1)
+	}
 	found := t.found[:0]
 	for _, idx := range idxs {
 		cm := &candidateMatch{
@@ -663,6 +757,13 @@ func (t *regexpMatchTree) matches(cp *contentProvider, cost int, known map[match
 			byteMatchSz: uint32(idx[1] - idx[0]),
 			fileName:    t.fileName,
 		}
+		for g := 1; 2*g < len(idx); g++ {
+			// Groups that didn't participate in the match have
+			// index -1.
+			if idx[2*g] >= 0 {
+				cm.captures = append(cm.captures, capture{group: g, start: uint32(idx[2*g]), end: uint32(idx[2*g+1])})
+			}
+		}
 
 		found = append(found, cm)
 	}
@@ -772,13 +873,17 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 		// original regexp, it returns true. An equivalent matchTree has the same
 		// behaviour as the original regexp and can be used instead.
 		//
-		subMT, isEq, _, err := d.regexpToMatchTreeRecursive(s.Regexp, ngramSize, s.FileName, s.CaseSensitive)
+		re := s.Regexp
+		if s.Multiline {
+			re = query.MultilineRegexp(re)
+		}
+		subMT, isEq, _, err := d.regexpToMatchTreeRecursive(re, ngramSize, s.FileName, s.CaseSensitive)
 		if err != nil {
 			return nil, err
 		}
 		// if the query can be used in place of the regexp
-		// return the subtree
-		if isEq {
+		// return the subtree, unless we need its capture groups.
+		if isEq && s.Regexp.MaxCap() == 0 {
 			return subMT, nil
 		}
 
@@ -788,7 +893,7 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 		}
 
 		tr := &regexpMatchTree{
-			regexp:   regexp.MustCompile(prefix + s.Regexp.String()),
+			regexp:   regexp.MustCompile(prefix + re.String()),
 			fileName: s.FileName,
 		}
 
@@ -807,6 +912,20 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 			r = append(r, ct)
 		}
 		return &andMatchTree{r}, nil
+	case *query.Near:
+		var r []matchTree
+		for _, ch := range s.Children {
+			ct, err := d.newMatchTree(ch)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, ct)
+		}
+		return &nearMatchTree{
+			andMatchTree: andMatchTree{r},
+			distance:     s.Distance,
+			bytes:        s.Bytes,
+		}, nil
 	case *query.Or:
 		var r []matchTree
 		for _, ch := range s.Children {
@@ -823,7 +942,25 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 			child: ct,
 		}, err
 
+	case *query.Diff:
+		re, err := diffLineRegexp(s)
+		if err != nil {
+			return nil, err
+		}
+		ct, err := d.newMatchTree(re)
+		if err != nil {
+			return nil, err
+		}
+		return &andMatchTree{[]matchTree{ct, d.newDiffMatchTree()}}, nil
+
 	case *query.Type:
+		if s.Type == query.TypeCommit {
+			ct, err := d.newMatchTree(s.Child)
+			if err != nil {
+				return nil, err
+			}
+			return &andMatchTree{[]matchTree{ct, d.newCommitMessageMatchTree()}}, nil
+		}
 		if s.Type != query.TypeFileName {
 			break
 		}
@@ -841,17 +978,17 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 		return d.newSubstringMatchTree(s)
 
 	case *query.Branch:
-		masks := make([]uint64, 0, len(d.repoMetaData))
+		masks := make([]branchMask, 0, len(d.repoMetaData))
 		if s.Pattern == "HEAD" {
 			for i := 0; i < len(d.repoMetaData); i++ {
-				masks = append(masks, 1)
+				masks = append(masks, branchMask{1})
 			}
 		} else {
 			for _, branchIDs := range d.branchIDs {
-				mask := uint64(0)
-				for nm, m := range branchIDs {
+				var mask branchMask
+				for nm, idx := range branchIDs {
 					if (s.Exact && nm == s.Pattern) || (!s.Exact && strings.Contains(nm, s.Pattern)) {
-						mask |= uint64(m)
+						mask.set(int(idx))
 					}
 				}
 				masks = append(masks, mask)
@@ -859,9 +996,8 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 
 		}
 		return &branchQueryMatchTree{
-			masks:     masks,
-			fileMasks: d.fileBranchMasks,
-			repos:     d.repos,
+			d:     d,
+			masks: masks,
 		}, nil
 	case *query.Const:
 		if s.Value {
@@ -882,6 +1018,22 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 			},
 		}, nil
 
+	case *query.Owner:
+		ids := d.matchingOwnerIDs(s.Owner)
+		if len(ids) == 0 {
+			return &noMatchTree{"owner"}, nil
+		}
+		var buf []uint32
+		return &docMatchTree{
+			reason:  "owner",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				var ok bool
+				ok, buf = d.docHasOwner(docID, ids, buf)
+				return ok
+			},
+		}, nil
+
 	case *query.Symbol:
 		subMT, err := d.newMatchTree(s.Expr)
 		if err != nil {
@@ -922,12 +1074,15 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 		}, nil
 
 	case *query.BranchesRepos:
-		reposBranchesWant := make([]uint64, len(d.repoMetaData))
+		reposBranchesWant := make([]branchMask, len(d.repoMetaData))
 		for repoIdx := range d.repoMetaData {
-			var mask uint64
+			var mask branchMask
 			for _, br := range s.List {
-				if br.Repos.Contains(d.repoMetaData[repoIdx].ID) {
-					mask |= uint64(d.branchIDs[repoIdx][br.Branch])
+				if !br.Repos.Contains(d.repoMetaData[repoIdx].ID) {
+					continue
+				}
+				if idx, ok := d.branchIDs[repoIdx][br.Branch]; ok {
+					mask.set(int(idx))
 				}
 			}
 			reposBranchesWant[repoIdx] = mask
@@ -936,7 +1091,7 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 			reason:  "BranchesRepos",
 			numDocs: d.numDocs(),
 			predicate: func(docID uint32) bool {
-				return d.fileBranchMasks[docID]&reposBranchesWant[d.repos[docID]] != 0
+				return d.docInBranches(docID, reposBranchesWant[d.repos[docID]])
 			},
 		}, nil
 
@@ -1082,6 +1237,14 @@ func pruneMatchTree(mt matchTree) (matchTree, error) {
 		}
 	case *fileNameMatchTree:
 		mt.child, err = pruneMatchTree(mt.child)
+	case *nearMatchTree:
+		child, err := pruneMatchTree(&mt.andMatchTree)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			return nil, nil
+		}
 	case *andLineMatchTree:
 		child, err := pruneMatchTree(&mt.andMatchTree)
 		if err != nil {
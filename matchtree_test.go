@@ -232,7 +232,7 @@ func TestRepoSet(t *testing.T) {
 	d := &indexData{
 		repoMetaData:    []Repository{{Name: "r0"}, {Name: "r1"}, {Name: "r2"}, {Name: "r3"}},
 		fileBranchMasks: []uint64{1, 1, 1, 1, 1, 1},
-		repos:           []uint16{0, 0, 1, 2, 3, 3},
+		repos:           []uint32{0, 0, 1, 2, 3, 3},
 	}
 	mt, err := d.newMatchTree(&query.RepoSet{Set: map[string]bool{"r1": true, "r3": true, "r99": true}})
 	if err != nil {
@@ -255,7 +255,7 @@ func TestRepo(t *testing.T) {
 	d := &indexData{
 		repoMetaData:    []Repository{{Name: "foo"}, {Name: "bar"}},
 		fileBranchMasks: []uint64{1, 1, 1, 1, 1},
-		repos:           []uint16{0, 0, 1, 0, 1},
+		repos:           []uint32{0, 0, 1, 0, 1},
 	}
 	mt, err := d.newMatchTree(&query.Repo{Regexp: regexp.MustCompile("ar")})
 	if err != nil {
@@ -281,8 +281,8 @@ func TestBranchesRepos(t *testing.T) {
 			{ID: hash("bar"), Name: "bar"},
 		},
 		fileBranchMasks: []uint64{1, 1, 1, 2, 1, 2, 1},
-		repos:           []uint16{0, 0, 1, 1, 1, 1, 1},
-		branchIDs:       []map[string]uint{{"HEAD": 1}, {"HEAD": 1, "b1": 2}},
+		repos:           []uint32{0, 0, 1, 1, 1, 1, 1},
+		branchIDs:       []map[string]uint{{"HEAD": 0}, {"HEAD": 0, "b1": 1}},
 	}
 
 	mt, err := d.newMatchTree(&query.BranchesRepos{List: []query.BranchRepos{
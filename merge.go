@@ -99,6 +99,11 @@ func merge(ds ...*indexData) (*IndexBuilder, error) {
 
 	ib := newIndexBuilder()
 	ib.indexFormatVersion = NextIndexFormatVersion
+	for _, d := range ds {
+		if d.contentBlocks != nil {
+			ib.CompressContents = true
+		}
+	}
 
 	for _, d := range ds {
 		lastRepoID := -1
@@ -189,6 +194,7 @@ func explode(dstDir string, f IndexFile, ibFuncs ...indexBuilderFunc) (map[strin
 
 			ib = newIndexBuilder()
 			ib.indexFormatVersion = IndexFormatVersion
+			ib.CompressContents = d.contentBlocks != nil
 			if err := ib.setRepository(&d.repoMetaData[repoID]); err != nil {
 				return shardNames, err
 			}
@@ -228,23 +234,24 @@ func addDocument(d *indexData, ib *IndexBuilder, repoID int, docID uint32) error
 		return err
 	}
 
+	if doc.Owners, err = d.docOwners(docID); err != nil {
+		return err
+	}
+
+	if doc.Commit, err = d.docCommit(docID); err != nil {
+		return err
+	}
+	doc.Diff = d.isDiff(docID)
+
 	doc.SymbolsMetaData = make([]*Symbol, len(doc.Symbols))
 	for i := range doc.SymbolsMetaData {
 		doc.SymbolsMetaData[i] = d.symbols.data(d.fileEndSymbol[docID] + uint32(i))
 	}
 
 	// calculate branches
-	{
-		mask := d.fileBranchMasks[docID]
-		id := uint32(1)
-		for mask != 0 {
-			if mask&0x1 != 0 {
-				doc.Branches = append(doc.Branches, d.branchNames[repoID][uint(id)])
-			}
-			id <<= 1
-			mask >>= 1
-		}
-	}
+	d.docBranchMask(docID).forEach(func(idx int) {
+		doc.Branches = append(doc.Branches, d.branchNames[repoID][uint(idx)])
+	})
 	return ib.Add(doc)
 }
 
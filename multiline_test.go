@@ -0,0 +1,74 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestMultilineRegexp(t *testing.T) {
+	content := "package x\n\nfunc empty() {\n}\n\nfunc full() {\n\treturn\n}\n"
+	b := testIndexBuilder(t, nil,
+		Document{Name: "a.go", Content: []byte(content)})
+
+	mustParse := func(s string) query.Q {
+		t.Helper()
+		q, err := query.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	// Without the flag, . doesn't match newlines.
+	if res := searchForTest(t, b, mustParse(`func.*\}`)); len(res.Files) != 0 {
+		t.Errorf("got %d files without multiline, want 0", len(res.Files))
+	}
+
+	q := mustParse(`multiline:yes empty.*\}`)
+	res := searchForTest(t, b, q, SearchOptions{ChunkMatches: true})
+	if len(res.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(res.Files))
+	}
+	// The match is greedy, so it ends at the last brace.
+	got := res.Files[0].ChunkMatches
+	want := []ChunkMatch{{
+		Content:      []byte("func empty() {\n}\n\nfunc full() {\n\treturn\n}"),
+		ContentStart: Location{ByteOffset: 11, LineNumber: 3, Column: 1},
+		Ranges: []Range{{
+			Start: Location{ByteOffset: 16, LineNumber: 3, Column: 6},
+			End:   Location{ByteOffset: 52, LineNumber: 8, Column: 2},
+		}},
+	}}
+	if d := cmp.Diff(want, got, cmp.FilterPath(func(p cmp.Path) bool {
+		return p.Last().String() == ".Score"
+	}, cmp.Ignore())); d != "" {
+		t.Errorf("chunk mismatch (-want +got):\n%s", d)
+	}
+
+	// Line matches have a fragment per line.
+	res = searchForTest(t, b, q)
+	var lines []int
+	for _, lm := range res.Files[0].LineMatches {
+		lines = append(lines, lm.LineNumber)
+	}
+	if d := cmp.Diff([]int{3, 4, 6, 7, 8}, lines); d != "" {
+		t.Errorf("line mismatch (-want +got):\n%s", d)
+	}
+}
@@ -111,7 +111,13 @@ func (a *arrayNgramOffset) DumpMap() map[ngram]simpleSection {
 		botSec := a.bots[botStart:botEnd]
 		for j, bot := range botSec {
 			idx := int(botStart) + j
-			m[ngram(uint64(top)<<32|uint64(bot))] = simpleSection{
+			ng := ngram(uint64(top)<<32 | uint64(bot))
+			if _, ok := m[ng]; ok {
+				// Repeated entries mark the end of the previous
+				// section. Like Get, use the first entry.
+				continue
+			}
+			m[ng] = simpleSection{
 				off: a.offsets[idx],
 				sz:  a.offsets[idx+1] - a.offsets[idx],
 			}
@@ -340,16 +346,22 @@ func (a *asciiNgramOffset) DumpMap() map[ngram]simpleSection {
 			off = a.chunkOffsets[i/asciiNgramOffsetChunkLength]
 		}
 		length := ent & ngramAsciiMaxSectionLength
+		off += length
 		if length == ngramAsciiMaxSectionLength {
 			// This entry is an ascii gram with a section too long
-			// to be represented, so skip the entry.
+			// to be represented, so skip the entry. Its length still
+			// counts towards the offset of the next entry, like in Get.
+			continue
+		}
+		if i > 0 && a.entries[i-1]>>11 == ent>>11 {
+			// Repeated entries only pad the offsets of the next
+			// ngram. Like Get, use the first entry.
 			continue
 		}
 		m[ngramAsciiPackedToNgram(ngramAscii(ent>>11))] = simpleSection{
-			off: off,
+			off: off - length,
 			sz:  length,
 		}
-		off += length
 	}
 	return m
 }
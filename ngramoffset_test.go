@@ -110,6 +110,17 @@ func TestMakeCombinedNgramOffset(t *testing.T) {
 		}
 	}
 
+	dump := m.DumpMap()
+	if len(dump) != len(ngrams) {
+		t.Errorf("DumpMap has %d entries, want %d", len(dump), len(ngrams))
+	}
+	for i, ng := range ngrams {
+		want := simpleSection{offsets[i], offsets[i+1] - offsets[i]}
+		if got := dump[ng]; want != got {
+			t.Errorf("#%d: DumpMap()[%q] got %v, want %v", i, ng, got, want)
+		}
+	}
+
 	if t.Failed() || true {
 		t.Log(ngrams)
 		t.Log(offsets)
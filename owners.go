@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import "strings"
+
+// matchingOwnerIDs returns the IDs of the owners equal to owner, ignoring
+// case.
+func (d *indexData) matchingOwnerIDs(owner string) []uint32 {
+	var ids []uint32
+	for id, name := range d.ownerNames {
+		if strings.EqualFold(name, owner) {
+			ids = append(ids, uint32(id))
+		}
+	}
+	return ids
+}
+
+// docOwnerIDs returns the sorted owner IDs of docID, reusing buf.
+func (d *indexData) docOwnerIDs(docID uint32, buf []uint32) ([]uint32, error) {
+	if len(d.fileOwnersIndex) == 0 {
+		return buf[:0], nil
+	}
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.fileOwnersStart + d.fileOwnersIndex[docID],
+		sz:  d.fileOwnersIndex[docID+1] - d.fileOwnersIndex[docID],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromSizedDeltas(blob, buf), nil
+}
+
+// docOwners returns the owners of docID.
+func (d *indexData) docOwners(docID uint32) ([]string, error) {
+	ids, err := d.docOwnerIDs(docID, nil)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	owners := make([]string, 0, len(ids))
+	for _, id := range ids {
+		owners = append(owners, d.ownerNames[id])
+	}
+	return owners, nil
+}
+
+// docHasOwner returns true if docID is owned by one of the sorted owner IDs
+// in want.
+func (d *indexData) docHasOwner(docID uint32, want []uint32, buf []uint32) (bool, []uint32) {
+	ids, err := d.docOwnerIDs(docID, buf)
+	if err != nil {
+		return false, buf
+	}
+	for i, j := 0, 0; i < len(ids) && j < len(want); {
+		switch {
+		case ids[i] == want[j]:
+			return true, ids
+		case ids[i] < want[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return false, ids
+}
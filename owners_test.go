@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestOwner(t *testing.T) {
+	content := []byte("bla needle bla")
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{Name: "f1", Content: content},
+		Document{Name: "f2", Content: content, Owners: []string{"@org/backend"}},
+		Document{Name: "f3", Content: content, Owners: []string{"@org/frontend", "@alice", "@org/backend"}},
+		Document{Name: "f4", Content: content, Owners: []string{"@bob"}},
+	)
+
+	for _, tc := range []struct {
+		owner string
+		want  []string
+	}{
+		{"@org/backend", []string{"f2", "f3"}},
+		{"@ORG/Backend", []string{"f2", "f3"}},
+		{"@alice", []string{"f3"}},
+		{"@carol", nil},
+	} {
+		q := query.NewAnd(&query.Substring{Pattern: "needle"}, &query.Owner{Owner: tc.owner})
+		res := searchForTest(t, b, q)
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.FileName)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", q, got, tc.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSearcher(&memSeeker{buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := s.(*indexData)
+	got, err := d.docOwners(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"@org/backend", "@org/frontend", "@alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("docOwners: got %v, want %v", got, want)
+	}
+	if got, err := d.docOwners(0); err != nil || got != nil {
+		t.Errorf("docOwners: got %v, %v, want no owners", got, err)
+	}
+}
+
+func TestOwnerNoOwners(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{Name: "f1", Content: []byte("bla needle bla")})
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rd := &reader{r: &memSeeker{buf.Bytes()}}
+	var toc indexTOC
+	if err := rd.readTOC(&toc); err != nil {
+		t.Fatal(err)
+	}
+	if toc.ownerNames.data.sz != 0 || toc.fileOwners.data.sz != 0 {
+		t.Error("owner sections written for shard without owners")
+	}
+
+	res := searchForTest(t, b, &query.Owner{Owner: "@alice"})
+	if len(res.Files) != 0 {
+		t.Errorf("got %v, want no matches", res.Files)
+	}
+}
@@ -16,7 +16,7 @@ import (
 //
 // Wire-format of map[string][]string is pretty straightforward:
 //
-// byte(1) version
+// byte(2) version
 // uvarint(len(map))
 // for k, vs in map:
 //   str(k)
@@ -26,6 +26,8 @@ import (
 //
 //  where str(v) is uvarint(len(v)) bytes(v)
 //
+// Version 1 encoded len(vs) as a single byte, limiting it to 255 branches.
+//
 // The above format gives about the same size encoding as gob does. However,
 // gob doesn't have a specialization for map[string][]string so we get to
 // avoid a lot of intermediate allocations.
@@ -71,18 +73,15 @@ func repoBranchesEncode(repoBranches map[string][]string) ([]byte, error) {
 	size := 1 // version
 	size += binary.PutUvarint(enc[:], uint64(len(repoBranches)))
 	for name, branches := range repoBranches {
-		size += strSize(name) + 1
+		size += strSize(name)
 		if l := len(branches); l == 1 && branches[0] == "HEAD" {
+			size++
 			continue
 		} else if l == 0 {
 			// We reserve "0" for the "HEAD" special case.
 			return nil, fmt.Errorf("repo with no branches: %q", name)
-		} else if l > 255 {
-			// We encode branches len as a byte (saves 11% cpu vs varint). This is
-			// fine sinze Zoekt can only index upto 64 branches (uses a bitmask on a
-			// 64bit int to encode branch information for a document)
-			return nil, fmt.Errorf("can't encode more than 255 branches: %d", l)
 		}
+		size += binary.PutUvarint(enc[:], uint64(len(branches)))
 		for _, branch := range branches {
 			size += strSize(branch)
 		}
@@ -90,7 +89,7 @@ func repoBranchesEncode(repoBranches map[string][]string) ([]byte, error) {
 	b.Grow(size)
 
 	// Version
-	b.WriteByte(1)
+	b.WriteByte(2)
 
 	// Length
 	varint(len(repoBranches))
@@ -103,7 +102,7 @@ func repoBranchesEncode(repoBranches map[string][]string) ([]byte, error) {
 			branches = nil
 		}
 
-		b.WriteByte(byte(len(branches)))
+		varint(len(branches))
 		for _, branch := range branches {
 			str(branch)
 		}
@@ -99,6 +99,38 @@ func TestBranchesRepos_Marshal(t *testing.T) {
 	}
 }
 
+func TestRepoBranchesEncode_ManyBranches(t *testing.T) {
+	var branches []string
+	for i := 0; i < 300; i++ {
+		branches = append(branches, fmt.Sprintf("release-%d", i))
+	}
+	b, err := repoBranchesEncode(map[string][]string{"repo": branches})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := binaryReader{b: b}
+	if v := r.byt(); v != 2 {
+		t.Fatalf("got version %d, want 2", v)
+	}
+	if l := r.uvarint(); l != 1 {
+		t.Fatalf("got %d repos, want 1", l)
+	}
+	if name := r.str(); name != "repo" {
+		t.Fatalf("got repo %q", name)
+	}
+	got := make([]string, r.uvarint())
+	for i := range got {
+		got[i] = r.str()
+	}
+	if r.err != nil || len(r.b) != 0 {
+		t.Fatalf("got err %v and %d trailing bytes", r.err, len(r.b))
+	}
+	if diff := cmp.Diff(branches, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
 // Generating 5.5M repos slows down the benchmark setup time, so we cache things.
 var genCache = map[string]interface{}{}
 
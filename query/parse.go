@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"strconv"
+	"strings"
 
 	"github.com/go-enry/go-enry/v2"
 	"github.com/grafana/regexp"
@@ -73,9 +75,20 @@ func isSpace(c byte) bool {
 
 // Parse parses a string into a query.
 func Parse(qStr string) (Q, error) {
+	return parse(qStr, false)
+}
+
+// ParseWithCaptures is like Parse, but keeps the capture groups of regular
+// expressions, which Parse optimizes away. Use it to parse queries for
+// SearchOptions.CaptureGroups.
+func ParseWithCaptures(qStr string) (Q, error) {
+	return parse(qStr, true)
+}
+
+func parse(qStr string, captures bool) (Q, error) {
 	b := []byte(qStr)
 
-	qs, _, err := parseExprList(b)
+	qs, _, err := parseExprList(b, captures)
 	if err != nil {
 		return nil, err
 	}
@@ -89,8 +102,9 @@ func Parse(qStr string) (Q, error) {
 }
 
 // parseExpr parses a single expression, returning the result, and the
-// number of bytes consumed.
-func parseExpr(in []byte) (Q, int, error) {
+// number of bytes consumed. If captures is set, regular expressions keep
+// their capture groups.
+func parseExpr(in []byte, captures bool) (Q, int, error) {
 	b := in[:]
 	var expr Q
 	for len(b) > 0 && isSpace(b[0]) {
@@ -117,6 +131,13 @@ func parseExpr(in []byte) (Q, int, error) {
 			return nil, 0, fmt.Errorf("query: unknown case argument %q, want {yes,no,auto}", text)
 		}
 		expr = &caseQ{text}
+	case tokMultiline:
+		switch text {
+		case "yes", "no":
+		default:
+			return nil, 0, fmt.Errorf("query: unknown multiline argument %q, want {yes,no}", text)
+		}
+		expr = &multilineQ{text}
 	case tokRepo:
 		r, err := regexp.Compile(text)
 
@@ -137,20 +158,20 @@ func parseExpr(in []byte) (Q, int, error) {
 	case tokBranch:
 		expr = &Branch{Pattern: text}
 	case tokText, tokRegex:
-		q, err := RegexpQuery(text, false, false)
+		q, err := regexpQuery(text, false, false, captures)
 		if err != nil {
 			return nil, 0, err
 		}
 		expr = q
 	case tokFile:
-		q, err := RegexpQuery(text, false, true)
+		q, err := regexpQuery(text, false, true, captures)
 		if err != nil {
 			return nil, 0, err
 		}
 		expr = q
 
 	case tokContent:
-		q, err := RegexpQuery(text, true, false)
+		q, err := regexpQuery(text, true, false, captures)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -163,6 +184,12 @@ func parseExpr(in []byte) (Q, int, error) {
 			expr = &Language{Language: canonical}
 		}
 
+	case tokOwner:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the owner: atom must have an argument")
+		}
+		expr = &Owner{Owner: text}
+
 	case tokSym:
 		if text == "" {
 			return nil, 0, fmt.Errorf("the sym: atom must have an argument")
@@ -174,12 +201,23 @@ func parseExpr(in []byte) (Q, int, error) {
 		}
 
 		expr = &Symbol{q}
+	case tokAdded, tokRemoved:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the %s atom must have an argument", tok.Input)
+		}
+
+		q, err := RegexpQuery(text, true, false)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		expr = &Diff{Expr: q, Removed: tok.Type == tokRemoved}
 	case tokParenClose:
 		// Caller must consume paren.
 		expr = nil
 
 	case tokParenOpen:
-		qs, n, err := parseExprList(b)
+		qs, n, err := parseExprList(b, captures)
 		b = b[n:]
 		if err != nil {
 			return nil, 0, err
@@ -198,8 +236,29 @@ func parseExpr(in []byte) (Q, int, error) {
 		if err != nil {
 			return nil, 0, err
 		}
+	case tokNear:
+		dist, inBytes, err := parseNearDistance(text)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		subQ, n, err := parseExpr(b, captures)
+		if err != nil {
+			return nil, 0, err
+		}
+		if subQ == nil {
+			return nil, 0, fmt.Errorf("query: near: operator needs an argument")
+		}
+		b = b[n:]
+
+		and, ok := Simplify(subQ).(*And)
+		if !ok {
+			return nil, 0, fmt.Errorf("query: near: operator needs at least two terms, eg. near:5(foo bar)")
+		}
+		expr = &Near{Children: and.Children, Distance: dist, Bytes: inBytes}
+
 	case tokNegate:
-		subQ, n, err := parseExpr(b)
+		subQ, n, err := parseExpr(b, captures)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -218,8 +277,10 @@ func parseExpr(in []byte) (Q, int, error) {
 			t = TypeFileName
 		case "repo":
 			t = TypeRepo
+		case "commit":
+			t = TypeCommit
 		default:
-			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo}", text)
+			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo,commit}", text)
 		}
 		// Later we will lift this into a root, like we do for caseQ
 		expr = &Type{Type: t, Child: nil}
@@ -228,11 +289,32 @@ func parseExpr(in []byte) (Q, int, error) {
 	return expr, len(in) - len(b), nil
 }
 
+// parseNearDistance parses the argument of near:, which is a number of
+// lines, or a number of bytes if it has a "b" suffix.
+func parseNearDistance(text string) (dist uint32, inBytes bool, err error) {
+	num := text
+	if strings.HasSuffix(num, "b") {
+		num = strings.TrimSuffix(num, "b")
+		inBytes = true
+	}
+	n, err := strconv.ParseUint(num, 10, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("query: unknown near argument %q, want a number of lines or bytes, eg. near:5 or near:100b", text)
+	}
+	return uint32(n), inBytes, nil
+}
+
 const regexpFlags syntax.Flags = syntax.ClassNL | syntax.PerlX | syntax.UnicodeGroups
 
 // RegexpQuery parses an atom into either a regular expression, or a
 // simple substring atom.
 func RegexpQuery(text string, content, file bool) (Q, error) {
+	return regexpQuery(text, content, file, false)
+}
+
+// regexpQuery is RegexpQuery, keeping the capture groups of the regexp if
+// captures is set.
+func regexpQuery(text string, content, file, captures bool) (Q, error) {
 	var expr Q
 
 	r, err := syntax.Parse(text, regexpFlags)
@@ -240,7 +322,11 @@ func RegexpQuery(text string, content, file bool) (Q, error) {
 		return nil, err
 	}
 
-	r = OptimizeRegexp(r, regexpFlags)
+	if captures && hasCapture(r) {
+		r = r.Simplify()
+	} else {
+		r = OptimizeRegexp(r, regexpFlags)
+	}
 
 	if r.Op == syntax.OpLiteral {
 		expr = &Substring{
@@ -287,7 +373,7 @@ func parseOperators(in []Q) (Q, error) {
 
 // parseExprList parses a list of query expressions. It is the
 // workhorse of the Parse function.
-func parseExprList(in []byte) ([]Q, int, error) {
+func parseExprList(in []byte, captures bool) ([]Q, int, error) {
 	b := in[:]
 	var qs []Q
 	for len(b) > 0 {
@@ -303,7 +389,7 @@ func parseExprList(in []byte) ([]Q, int, error) {
 			continue
 		}
 
-		q, n, err := parseExpr(b)
+		q, n, err := parseExpr(b, captures)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -317,12 +403,15 @@ func parseExprList(in []byte) ([]Q, int, error) {
 	}
 
 	setCase := "auto"
+	setMultiline := false
 	newQS := qs[:0]
 	typeT := uint8(100)
 	for _, q := range qs {
 		switch s := q.(type) {
 		case *caseQ:
 			setCase = s.Flavor
+		case *multilineQ:
+			setMultiline = s.Flavor == "yes"
 		case *Type:
 			if s.Type < typeT {
 				typeT = s.Type
@@ -335,6 +424,9 @@ func parseExprList(in []byte) ([]Q, int, error) {
 		if sc, ok := q.(setCaser); ok {
 			sc.setCase(setCase)
 		}
+		if sm, ok := q.(setMultiliner); ok && setMultiline {
+			sm.setMultiline(true)
+		}
 		return q
 	})
 	if typeT != 100 {
@@ -374,9 +466,15 @@ const (
 	tokSym        = 13
 	tokType       = 14
 	tokArchived   = 15
+	tokNear       = 16
+	tokOwner      = 17
+	tokAdded      = 18
+	tokRemoved    = 19
+	tokMultiline  = 20
 )
 
 var tokNames = map[int]string{
+	tokAdded:      "Added",
 	tokArchived:   "Archived",
 	tokBranch:     "Branch",
 	tokCase:       "Case",
@@ -387,29 +485,38 @@ var tokNames = map[int]string{
 	tokParenClose: "ParenClose",
 	tokParenOpen:  "ParenOpen",
 	tokRegex:      "Regex",
+	tokRemoved:    "Removed",
 	tokRepo:       "Repo",
 	tokText:       "Text",
 	tokLang:       "Language",
+	tokMultiline:  "Multiline",
+	tokNear:       "Near",
+	tokOwner:      "Owner",
 	tokSym:        "Symbol",
 	tokType:       "Type",
 }
 
 var prefixes = map[string]int{
-	"archived:": tokArchived,
-	"b:":        tokBranch,
-	"branch:":   tokBranch,
-	"c:":        tokContent,
-	"case:":     tokCase,
-	"content:":  tokContent,
-	"f:":        tokFile,
-	"file:":     tokFile,
-	"r:":        tokRepo,
-	"regex:":    tokRegex,
-	"repo:":     tokRepo,
-	"lang:":     tokLang,
-	"sym:":      tokSym,
-	"t:":        tokType,
-	"type:":     tokType,
+	"added:":     tokAdded,
+	"archived:":  tokArchived,
+	"b:":         tokBranch,
+	"branch:":    tokBranch,
+	"c:":         tokContent,
+	"case:":      tokCase,
+	"content:":   tokContent,
+	"f:":         tokFile,
+	"file:":      tokFile,
+	"r:":         tokRepo,
+	"regex:":     tokRegex,
+	"removed:":   tokRemoved,
+	"repo:":      tokRepo,
+	"lang:":      tokLang,
+	"multiline:": tokMultiline,
+	"near:":      tokNear,
+	"owner:":     tokOwner,
+	"sym:":       tokSym,
+	"t:":         tokType,
+	"type:":      tokType,
 }
 
 var reservedWords = map[string]int{
@@ -469,6 +576,11 @@ loop:
 		c := left[0]
 		switch c {
 		case '(':
+			if parenCount == 0 && bytes.HasPrefix(cur.Text, []byte("near:")) {
+				// near:N(...) takes the parenthesized expression as its
+				// argument, so stop in front of the paren.
+				break loop
+			}
 			parenCount++
 			cur.Text = append(cur.Text, c)
 			left = left[1:]
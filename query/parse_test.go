@@ -64,7 +64,13 @@ func TestParseQuery(t *testing.T) {
 		{"abccase:yes", &Substring{Pattern: "abccase:yes"}},
 		{"file:abc", &Substring{Pattern: "abc", FileName: true}},
 		{"branch:pqr", &Branch{Pattern: "pqr"}},
+		{"multiline:yes a.*b", &Regexp{Regexp: mustParseRE("a(?-s:.)*b"), Multiline: true}},
+		{"multiline:yes a.*b sym:c.*d", NewAnd(
+			&Regexp{Regexp: mustParseRE("a(?-s:.)*b"), Multiline: true},
+			&Symbol{&Regexp{Regexp: mustParseRE("c(?-s:.)*d")}})},
+		{"multiline:no a.*b", &Regexp{Regexp: mustParseRE("a(?-s:.)*b")}},
 		{"((x|y) )", &Regexp{Regexp: mustParseRE("[xy]")}},
+		{"(hello)world", &Substring{Pattern: "helloworld"}},
 		{"archived:yes", RawConfig(RcOnlyArchived)},
 		{"archived:no", RawConfig(RcNoArchived)},
 		{"file:helpers\\.go byte", NewAnd(
@@ -90,11 +96,23 @@ func TestParseQuery(t *testing.T) {
 
 		{"lang:c++", &Language{"C++"}},
 		{"lang:cpp", &Language{"C++"}},
+		{"owner:@org/team", &Owner{"@org/team"}},
+		{"added:abc", &Diff{Expr: &Substring{Pattern: "abc", Content: true}}},
+		{"removed:a.*c", &Diff{Expr: &Regexp{Regexp: mustParseRE("a(?-s:.)*c"), Content: true}, Removed: true}},
 		{"sym:pqr", &Symbol{&Substring{Pattern: "pqr"}}},
 		{"sym:Pqr", &Symbol{&Substring{Pattern: "Pqr", CaseSensitive: true}}},
 		{"sym:.*", &Symbol{&Regexp{Regexp: mustParseRE(".*")}}},
 		{"sym:a(b|d)e", &Symbol{&Regexp{Regexp: mustParseRE("a[bd]e")}}},
 
+		// near
+		{"near:5(abc def)", &Near{Children: []Q{&Substring{Pattern: "abc"}, &Substring{Pattern: "def"}}, Distance: 5}},
+		{"near:0 (abc def)", &Near{Children: []Q{&Substring{Pattern: "abc"}, &Substring{Pattern: "def"}}, Distance: 0}},
+		{"near:100b(abc DEF)", &Near{Children: []Q{&Substring{Pattern: "abc"}, &Substring{Pattern: "DEF", CaseSensitive: true}}, Distance: 100, Bytes: true}},
+		{"near:2(abc def) case:yes", &Near{Children: []Q{&Substring{Pattern: "abc", CaseSensitive: true}, &Substring{Pattern: "def", CaseSensitive: true}}, Distance: 2}},
+		{"near:2(abc def) ghi", NewAnd(
+			&Near{Children: []Q{&Substring{Pattern: "abc"}, &Substring{Pattern: "def"}}, Distance: 2},
+			&Substring{Pattern: "ghi"})},
+
 		// case
 		{"abc case:yes", &Substring{Pattern: "abc", CaseSensitive: true}},
 		{"abc case:auto", &Substring{Pattern: "abc", CaseSensitive: false}},
@@ -108,6 +126,7 @@ func TestParseQuery(t *testing.T) {
 		// type
 		{"type:repo abc", &Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}},
 		{"type:file abc def", &Type{Type: TypeFileName, Child: NewAnd(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})}},
+		{"type:commit abc", &Type{Type: TypeCommit, Child: &Substring{Pattern: "abc"}}},
 		{"(type:repo abc) def", NewAnd(&Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}, &Substring{Pattern: "def"})},
 
 		// errors.
@@ -115,8 +134,15 @@ func TestParseQuery(t *testing.T) {
 		{"\"abc", nil},
 		{"\"a\\", nil},
 		{"case:foo", nil},
+		{"multiline:foo", nil},
+		{"near:5(abc)", nil},
+		{"near:5(abc or def)", nil},
+		{"near:x(abc def)", nil},
+		{"near:5", nil},
 
 		{"sym:", nil},
+		{"owner:", nil},
+		{"added:", nil},
 		{"abc or", nil},
 		{"or abc", nil},
 		{"def or or abc", nil},
@@ -134,6 +160,26 @@ func TestParseQuery(t *testing.T) {
 	}
 }
 
+func TestParseWithCaptures(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want Q
+	}{
+		{"(hello)world", &Regexp{Regexp: mustParseRE("(hello)world")}},
+		{"((x|y) )", &Regexp{Regexp: mustParseRE("([xy])")}},
+		{"file:(a)b", &Regexp{Regexp: mustParseRE("(a)b"), FileName: true}},
+		{"helloworld", &Substring{Pattern: "helloworld"}},
+		{"sym:(a)b", &Symbol{&Substring{Pattern: "ab"}}},
+	} {
+		got, err := ParseWithCaptures(c.in)
+		if err != nil {
+			t.Errorf("ParseWithCaptures(%q): %v", c.in, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseWithCaptures(%s): got %v want %v", c.in, got, c.want)
+		}
+	}
+}
+
 func TestTokenize(t *testing.T) {
 	type testcase struct {
 		in   string
@@ -159,6 +205,8 @@ func TestTokenize(t *testing.T) {
 		{"o\"r\" bla", tokText, "or"},
 		{"or bla", tokOr, "or"},
 		{"ar bla", tokText, "ar"},
+		{"near:5(abc def)", tokNear, "5"},
+		{"near:5b (abc def)", tokNear, "5b"},
 	}
 	for _, c := range cases {
 		tok, err := nextToken([]byte(c.in))
@@ -89,12 +89,21 @@ type Regexp struct {
 	FileName      bool
 	Content       bool
 	CaseSensitive bool
+
+	// Multiline makes . match newlines too, like the s flag, so a match
+	// can span several lines.
+	Multiline bool
 }
 
 func (q *Regexp) String() string {
 	pref := ""
 	if q.FileName {
 		pref = "file_"
+	} else if q.Content {
+		pref = "content_"
+	}
+	if q.Multiline {
+		pref = "multiline_" + pref
 	}
 	if q.CaseSensitive {
 		pref = "case_" + pref
@@ -141,6 +150,21 @@ func (s *Symbol) String() string {
 	return fmt.Sprintf("sym:%s", s.Expr)
 }
 
+// Diff finds lines added, or removed, by commits. It only matches the diff
+// documents of the diff shards built by gitindex. Expr is a Substring or
+// Regexp for the line.
+type Diff struct {
+	Expr    Q
+	Removed bool
+}
+
+func (q *Diff) String() string {
+	if q.Removed {
+		return fmt.Sprintf("removed:%s", q.Expr)
+	}
+	return fmt.Sprintf("added:%s", q.Expr)
+}
+
 type caseQ struct {
 	Flavor string
 }
@@ -149,6 +173,14 @@ func (c *caseQ) String() string {
 	return "case:" + c.Flavor
 }
 
+type multilineQ struct {
+	Flavor string
+}
+
+func (m *multilineQ) String() string {
+	return "multiline:" + m.Flavor
+}
+
 type Language struct {
 	Language string
 }
@@ -157,6 +189,16 @@ func (l *Language) String() string {
 	return "lang:" + l.Language
 }
 
+// Owner matches files owned by the given owner, eg. "@org/team" from a
+// CODEOWNERS file. The comparison ignores case.
+type Owner struct {
+	Owner string
+}
+
+func (q *Owner) String() string {
+	return "owner:" + q.Owner
+}
+
 type Const struct {
 	Value bool
 }
@@ -285,6 +327,7 @@ const (
 	TypeFileMatch uint8 = iota
 	TypeFileName
 	TypeRepo
+	TypeCommit
 )
 
 // Type changes the result type returned.
@@ -301,6 +344,8 @@ func (q *Type) String() string {
 		return fmt.Sprintf("(type:filename %s)", q.Child)
 	case TypeRepo:
 		return fmt.Sprintf("(type:repo %s)", q.Child)
+	case TypeCommit:
+		return fmt.Sprintf("(type:commit %s)", q.Child)
 	default:
 		return fmt.Sprintf("(type:UNKNOWN %s)", q.Child)
 	}
@@ -357,6 +402,12 @@ func (q *Symbol) setCase(k string) {
 	}
 }
 
+func (q *Diff) setCase(k string) {
+	if sc, ok := q.Expr.(setCaser); ok {
+		sc.setCase(k)
+	}
+}
+
 func (q *Regexp) setCase(k string) {
 	switch k {
 	case "yes":
@@ -368,6 +419,14 @@ func (q *Regexp) setCase(k string) {
 	}
 }
 
+type setMultiliner interface {
+	setMultiline(bool)
+}
+
+func (q *Regexp) setMultiline(v bool) {
+	q.Multiline = v
+}
+
 // GobCache exists so we only pay the cost of marshalling a query once when we
 // aggregate it out over all the replicas.
 //
@@ -453,6 +512,27 @@ func (q *And) String() string {
 	return fmt.Sprintf("(and %s)", strings.Join(sub, " "))
 }
 
+// Near is matched when all its children match within Distance of each
+// other. Distance is counted in lines, or in bytes if Bytes is set. A
+// Distance of 0 lines means all children match on the same line.
+type Near struct {
+	Children []Q
+	Distance uint32
+	Bytes    bool
+}
+
+func (q *Near) String() string {
+	var sub []string
+	for _, ch := range q.Children {
+		sub = append(sub, ch.String())
+	}
+	unit := ""
+	if q.Bytes {
+		unit = "b"
+	}
+	return fmt.Sprintf("(near:%d%s %s)", q.Distance, unit, strings.Join(sub, " "))
+}
+
 // NewAnd is syntactic sugar for constructing And queries.
 func NewAnd(qs ...Q) Q {
 	return &And{Children: qs}
@@ -523,6 +603,18 @@ func flatten(q Q) (Q, bool) {
 		}
 		flatChildren, changed := flattenAndOr(s.Children, s)
 		return &Or{flatChildren}, changed
+	case *Near:
+		if len(s.Children) == 1 {
+			return s.Children[0], true
+		}
+		var flat []Q
+		changed := false
+		for _, ch := range s.Children {
+			ch, subChanged := flatten(ch)
+			changed = changed || subChanged
+			flat = append(flat, ch)
+		}
+		return &Near{Children: flat, Distance: s.Distance, Bytes: s.Bytes}, changed
 	case *Not:
 		child, changed := flatten(s.Child)
 		return &Not{child}, changed
@@ -582,6 +674,27 @@ func evalConstants(q Q) Q {
 		return evalAndOrConstants(q, s.Children)
 	case *Or:
 		return evalAndOrConstants(q, s.Children)
+	case *Near:
+		// A constant has no position, so TRUE drops out of the proximity
+		// check and FALSE makes the whole expression FALSE.
+		children := mapQueryList(s.Children, evalConstants)
+		newCH := children[:0]
+		for _, ch := range children {
+			if c, ok := ch.(*Const); ok {
+				if !c.Value {
+					return ch
+				}
+				continue
+			}
+			newCH = append(newCH, ch)
+		}
+		switch len(newCH) {
+		case 0:
+			return &Const{true}
+		case 1:
+			return newCH[0]
+		}
+		return &Near{Children: newCH, Distance: s.Distance, Bytes: s.Bytes}
 	case *Not:
 		ch := evalConstants(s.Child)
 		if _, ok := ch.(*Const); ok {
@@ -590,13 +703,15 @@ func evalConstants(q Q) Q {
 		return &Not{ch}
 	case *Type:
 		ch := evalConstants(s.Child)
-		if _, ok := ch.(*Const); ok {
+		if c, ok := ch.(*Const); ok && !(c.Value && s.Type == TypeCommit) {
 			// If q is the root query, then evaluating this to a const changes
 			// the type of result we will return. However, the only case this
 			// makes sense is `type:repo TRUE` to return all repos or
 			// `type:file TRUE` to return all filenames. For other cases we
 			// want to do this constant folding though, so we allow the
-			// unexpected behaviour mentioned previously.
+			// unexpected behaviour mentioned previously. `type:commit TRUE`
+			// is kept, since it returns all commit messages rather than
+			// all files.
 			return ch
 		}
 		return &Type{Child: ch, Type: s.Type}
@@ -640,6 +755,8 @@ func Map(q Q, f func(q Q) Q) Q {
 		q = &And{Children: mapQueryList(s.Children, f)}
 	case *Or:
 		q = &Or{Children: mapQueryList(s.Children, f)}
+	case *Near:
+		q = &Near{Children: mapQueryList(s.Children, f), Distance: s.Distance, Bytes: s.Bytes}
 	case *Not:
 		q = &Not{Child: Map(s.Child, f)}
 	case *Type:
@@ -678,6 +795,7 @@ func VisitAtoms(q Q, v func(q Q)) {
 		switch iQ.(type) {
 		case *And:
 		case *Or:
+		case *Near:
 		case *Not:
 		case *Type:
 		default:
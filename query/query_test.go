@@ -67,6 +67,17 @@ func TestSimplify(t *testing.T) {
 		{in: NewAnd(&Const{true}, &Const{false}), want: &Const{false}},
 		{in: NewOr(&Const{false}, &Const{true}), want: &Const{true}},
 		{in: &Not{&Const{true}}, want: &Const{false}},
+		{in: &Type{Type: TypeRepo, Child: &Const{true}}, want: &Const{true}},
+		{in: &Type{Type: TypeCommit, Child: &Const{true}}, want: &Type{Type: TypeCommit, Child: &Const{true}}},
+		{in: &Type{Type: TypeCommit, Child: &Const{false}}, want: &Const{false}},
+		{
+			in:   &Near{Children: []Q{&Substring{Pattern: "hoi"}, &Const{false}}, Distance: 2},
+			want: &Const{false},
+		},
+		{
+			in:   &Near{Children: []Q{&Substring{Pattern: "hoi"}, &Const{true}}, Distance: 2},
+			want: &Substring{Pattern: "hoi"},
+		},
 		{
 			in: NewAnd(
 				&Substring{Pattern: "byte"},
@@ -43,6 +43,21 @@ func LowerRegexp(r *syntax.Regexp) *syntax.Regexp {
 	return &newRE
 }
 
+// MultilineRegexp returns a copy of r in which . also matches newlines, see
+// Regexp.Multiline.
+func MultilineRegexp(r *syntax.Regexp) *syntax.Regexp {
+	newRE := *r
+	newRE.Flags |= syntax.DotNL
+	if r.Op == syntax.OpAnyCharNotNL {
+		newRE.Op = syntax.OpAnyChar
+	}
+	newRE.Sub = make([]*syntax.Regexp, len(r.Sub))
+	for i, s := range r.Sub {
+		newRE.Sub[i] = MultilineRegexp(s)
+	}
+	return &newRE
+}
+
 // OptimizeRegexp converts capturing groups to non-capturing groups.
 // Returns original input if an error is encountered
 func OptimizeRegexp(re *syntax.Regexp, flags syntax.Flags) *syntax.Regexp {
@@ -50,6 +65,31 @@ func OptimizeRegexp(re *syntax.Regexp, flags syntax.Flags) *syntax.Regexp {
 	return r.Simplify()
 }
 
+// RemoveCaptures removes the capture groups of q if it is a regexp, as
+// OptimizeRegexp does. Regexps that become literals are replaced by
+// substrings. It is meant for query.Map.
+func RemoveCaptures(q Q) Q {
+	switch s := q.(type) {
+	case *Regexp:
+		if !hasCapture(s.Regexp) {
+			return q
+		}
+		r := OptimizeRegexp(s.Regexp, regexpFlags)
+		if r.Op == syntax.OpLiteral {
+			return &Substring{
+				Pattern:       string(r.Rune),
+				FileName:      s.FileName,
+				Content:       s.Content,
+				CaseSensitive: s.CaseSensitive,
+			}
+		}
+		c := *s
+		c.Regexp = r
+		return &c
+	}
+	return q
+}
+
 func convertCapture(re *syntax.Regexp, flags syntax.Flags) *syntax.Regexp {
 	if !hasCapture(re) {
 		return re
@@ -99,3 +99,20 @@ func TestOptimize(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoveCaptures(t *testing.T) {
+	for in, want := range map[string]string{
+		"test(ing|ed)":     `regex:"test(?:ing|ed)"`,
+		"(hello)world":     `substr:"helloworld"`,
+		"case:yes (Hel)lo": `case_substr:"Hello"`,
+		"te(st)? x":        `(and regex:"te(?:st)?" substr:"x")`,
+	} {
+		q, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Map(q, RemoveCaptures).String(); got != want {
+			t.Errorf("RemoveCaptures(%s) = %s, want %s", q, got, want)
+		}
+	}
+}
@@ -253,8 +253,19 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, fmt.Errorf("file needs read feature version >= %d, have read feature version %d", d.metaData.IndexMinReaderVersion, FeatureVersion)
 	}
 
-	d.boundariesStart = toc.fileContents.data.off
-	d.boundaries = toc.fileContents.relativeIndex()
+	if toc.contentBoundaries.sz > 0 {
+		d.boundaries, err = readSectionU32(d.file, toc.contentBoundaries)
+		if err != nil {
+			return nil, err
+		}
+		d.contentBlocks, err = newContentBlocks(d.file, toc.contentBlocks, d.boundaries[len(d.boundaries)-1])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		d.boundariesStart = toc.fileContents.data.off
+		d.boundaries = toc.fileContents.relativeIndex()
+	}
 	d.newlinesStart = toc.newlines.data.off
 	d.newlinesIndex = toc.newlines.relativeIndex()
 	d.docSectionsStart = toc.fileSections.data.off
@@ -289,6 +300,22 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, err
 	}
 
+	if len(toc.ownerNames.offsets) > 0 {
+		blob, err := d.readSectionBlob(toc.ownerNames.data)
+		if err != nil {
+			return nil, err
+		}
+		index := toc.ownerNames.relativeIndex()
+		d.ownerNames = make([]string, 0, len(index)-1)
+		for i := 0; i+1 < len(index); i++ {
+			d.ownerNames = append(d.ownerNames, string(blob[index[i]:index[i+1]]))
+		}
+	}
+	d.fileOwnersStart = toc.fileOwners.data.off
+	d.fileOwnersIndex = toc.fileOwners.relativeIndex()
+	d.fileCommitsStart = toc.fileCommits.data.off
+	d.fileCommitsIndex = toc.fileCommits.relativeIndex()
+
 	if os.Getenv("ZOEKT_ENABLE_NGRAM_BS") != "" {
 		bsMap, err := d.readBinarySearchNgrams(toc)
 		if err != nil {
@@ -320,6 +347,14 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, err
 	}
 
+	d.fileBranchMasksWide, err = readSectionU64(d.file, toc.branchMasksWide)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(d.fileBranchMasks); n > 0 {
+		d.branchMaskWideWords = len(d.fileBranchMasksWide) / n
+	}
+
 	d.fileNameContent, err = d.readSectionBlob(toc.fileNames.data)
 	if err != nil {
 		return nil, err
@@ -336,9 +371,8 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		repoBranchIDs := make(map[string]uint, len(md.Branches))
 		repoBranchNames := make(map[uint]string, len(md.Branches))
 		for j, br := range md.Branches {
-			id := uint(1) << uint(j)
-			repoBranchIDs[br.Name] = id
-			repoBranchNames[id] = br.Name
+			repoBranchIDs[br.Name] = uint(j)
+			repoBranchNames[uint(j)] = br.Name
 		}
 		d.branchIDs = append(d.branchIDs, repoBranchIDs)
 		d.branchNames = append(d.branchNames, repoBranchNames)
@@ -364,6 +398,7 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		toc.nameRuneOffsets: &fileNameRuneOffsets,
 		toc.nameEndRunes:    &d.fileNameEndRunes,
 		toc.fileEndRunes:    &d.fileEndRunes,
+		toc.fileDiffs:       &d.fileDiffs,
 	} {
 		if blob, err := d.readSectionBlob(sect); err != nil {
 			return nil, err
@@ -402,10 +437,12 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		if err != nil {
 			return nil, err
 		}
-		d.repos = fromSizedDeltas16(blob, nil)
+		// Repo indexes are non-decreasing, so older shards which were
+		// written with 16-bit deltas have the same encoding.
+		d.repos = fromSizedDeltas(blob, nil)
 	} else {
-		// every document is for repo index 0 (default value of uint16)
-		d.repos = make([]uint16, len(d.fileBranchMasks))
+		// every document is for repo index 0 (default value of uint32)
+		d.repos = make([]uint32, len(d.fileBranchMasks))
 	}
 
 	if err := d.calculateStats(); err != nil {
@@ -538,10 +575,22 @@ func (d *indexData) verify() error {
 			return fmt.Errorf("got %s %d, want %d", what, got, n)
 		}
 	}
+	if got, want := len(d.fileBranchMasksWide), d.branchMaskWideWords*n; got != want {
+		return fmt.Errorf("got wide branch masks %d, want %d", got, want)
+	}
+	if got := len(d.fileOwnersIndex) - 1; got >= 0 && got != n {
+		return fmt.Errorf("got file owners %d, want %d", got, n)
+	}
+	if got := len(d.fileCommitsIndex) - 1; got >= 0 && got != n {
+		return fmt.Errorf("got file commits %d, want %d", got, n)
+	}
 	return nil
 }
 
 func (d *indexData) readContents(i uint32) ([]byte, error) {
+	if d.contentBlocks != nil {
+		return d.contentBlocks.read(d.boundaries[i], d.boundaries[i+1]-d.boundaries[i])
+	}
 	return d.readSectionBlob(simpleSection{
 		off: d.boundariesStart + d.boundaries[i],
 		sz:  d.boundaries[i+1] - d.boundaries[i],
@@ -549,6 +598,9 @@ func (d *indexData) readContents(i uint32) ([]byte, error) {
 }
 
 func (d *indexData) readContentSlice(off uint32, sz uint32) ([]byte, error) {
+	if d.contentBlocks != nil {
+		return d.contentBlocks.read(off, sz)
+	}
 	// TODO(hanwen): cap result if it is at the end of the content
 	// section.
 	return d.readSectionBlob(simpleSection{
@@ -665,9 +717,13 @@ func ReadMetadataPath(p string) ([]*Repository, *IndexMetadata, error) {
 // exist. Note: if no files exist this will return an empty slice and nil
 // error.
 //
-// This is p and the ".meta" file for p.
+// This is p, the ".meta" file for p and the ShardSet of the repository of
+// p, if p is a simple shard.
 func IndexFilePaths(p string) ([]string, error) {
 	paths := []string{p, p + ".meta"}
+	if set := shardSetPath(p); set != "" {
+		paths = append(paths, set)
+	}
 	exist := paths[:0]
 	for _, p := range paths {
 		if _, err := os.Stat(p); err == nil {
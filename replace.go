@@ -0,0 +1,306 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines around each hunk of the
+// diffs returned by ReplaceDiff.
+const diffContextLines = 3
+
+// replaceEdit replaces content[start:end] by text.
+type replaceEdit struct {
+	start, end uint32
+	text       []byte
+}
+
+// ReplaceDiff returns a unified diff, suitable for git apply, that replaces
+// each content match of fm by template. The file must have been found with
+// SearchOptions.Whole, so its content is known. ReplaceDiff returns the empty
+// string if the replacement doesn't change the file.
+//
+// In the template, $n or ${n} is replaced by the text captured by group n of
+// the regexp, and $0 by the whole match. Groups are only known if the search
+// set SearchOptions.CaptureGroups. As in regexp.Regexp.Expand, $1x means
+// ${1x}, which like any unknown group is replaced by the empty string, and $$
+// is a literal $. Filename matches are not replaced.
+//
+// Line matches break a match that spans several lines into one fragment per
+// line, each of which is replaced by template. Search with
+// SearchOptions.ChunkMatches to replace such matches as a whole.
+func ReplaceDiff(fm *FileMatch, template string) (string, error) {
+	if fm.Content == nil {
+		return "", fmt.Errorf("%s: no content, search with SearchOptions.Whole", fm.FileName)
+	}
+	content := fm.Content
+
+	var edits []replaceEdit
+	add := func(start, end uint32, subs []Submatch) error {
+		if end < start || int(end) > len(content) {
+			return fmt.Errorf("%s: match [%d, %d) out of range", fm.FileName, start, end)
+		}
+		edits = append(edits, replaceEdit{
+			start: start,
+			end:   end,
+			text:  expandTemplate(template, content[start:end], subs),
+		})
+		return nil
+	}
+	for _, lm := range fm.LineMatches {
+		if lm.FileName {
+			continue
+		}
+		for _, f := range lm.LineFragments {
+			if err := add(f.Offset, f.Offset+uint32(f.MatchLength), f.Submatches); err != nil {
+				return "", err
+			}
+		}
+	}
+	for _, cm := range fm.ChunkMatches {
+		if cm.FileName {
+			continue
+		}
+		for i, r := range cm.Ranges {
+			var subs []Submatch
+			if cm.Submatches != nil {
+				subs = cm.Submatches[i]
+			}
+			if err := add(r.Start.ByteOffset, r.End.ByteOffset, subs); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return unifiedDiff(fm.FileName, content, edits), nil
+}
+
+// expandTemplate expands the groups in template, see ReplaceDiff. match is
+// the text of the whole match, and subs its captured groups.
+func expandTemplate(template string, match []byte, subs []Submatch) []byte {
+	var out []byte
+	for {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		out = append(out, template[:i]...)
+		template = template[i+1:]
+
+		if strings.HasPrefix(template, "$") {
+			out = append(out, '$')
+			template = template[1:]
+			continue
+		}
+
+		var name string
+		if strings.HasPrefix(template, "{") {
+			end := strings.IndexByte(template, '}')
+			if end < 0 {
+				// Malformed, keep the $ literally.
+				out = append(out, '$')
+				continue
+			}
+			name, template = template[1:end], template[end+1:]
+		} else {
+			n := 0
+			for n < len(template) && isWordByte(template[n]) {
+				n++
+			}
+			if n == 0 {
+				out = append(out, '$')
+				continue
+			}
+			name, template = template[:n], template[n:]
+		}
+
+		g, err := strconv.Atoi(name)
+		if err != nil || g < 0 {
+			continue
+		}
+		if g == 0 {
+			out = append(out, match...)
+			continue
+		}
+		for _, s := range subs {
+			if s.Group == g {
+				out = append(out, s.Value...)
+				break
+			}
+		}
+	}
+	return append(out, template...)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// diffBlock replaces the old lines [oldStart, oldEnd) by newLines.
+type diffBlock struct {
+	oldStart, oldEnd int
+	newLines         [][]byte
+}
+
+// unifiedDiff returns the diff of applying edits to the content of the file
+// name. Edits that overlap an earlier one are dropped.
+func unifiedDiff(name string, content []byte, edits []replaceEdit) string {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	lines := splitLines(content)
+	// lineStarts[i] is the offset of line i. The last entry is the end of
+	// the content.
+	lineStarts := make([]uint32, 0, len(lines)+1)
+	off := uint32(0)
+	for _, l := range lines {
+		lineStarts = append(lineStarts, off)
+		off += uint32(len(l))
+	}
+	lineStarts = append(lineStarts, off)
+	lineOf := func(off uint32) int {
+		return sort.Search(len(lines), func(i int) bool { return lineStarts[i+1] > off })
+	}
+
+	// Merge the edits that touch the same lines into blocks.
+	var blocks []diffBlock
+	for i := 0; i < len(edits); {
+		first := lineOf(edits[i].start)
+		last := first + 1
+		var group []replaceEdit
+		for ; i < len(edits); i++ {
+			e := edits[i]
+			if len(group) > 0 && e.start < group[len(group)-1].end {
+				continue
+			}
+			if len(group) > 0 && lineOf(e.start) >= last {
+				break
+			}
+			group = append(group, e)
+			if e.end > e.start {
+				l := lineOf(e.end-1) + 1
+				if content[e.end-1] == '\n' && !bytes.HasSuffix(e.text, []byte("\n")) {
+					// The edit joins the next line.
+					l++
+				}
+				if l > last {
+					last = l
+				}
+			}
+		}
+		if last > len(lines) {
+			last = len(lines)
+		}
+
+		var buf []byte
+		pos := lineStarts[first]
+		for _, e := range group {
+			buf = append(buf, content[pos:e.start]...)
+			buf = append(buf, e.text...)
+			pos = e.end
+		}
+		buf = append(buf, content[pos:lineStarts[last]]...)
+		if bytes.Equal(buf, content[lineStarts[first]:lineStarts[last]]) {
+			continue
+		}
+		blocks = append(blocks, diffBlock{oldStart: first, oldEnd: last, newLines: splitLines(buf)})
+	}
+	if len(blocks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", name, name)
+
+	// delta is the number of lines added before the current hunk.
+	delta := 0
+	for i := 0; i < len(blocks); {
+		// A hunk covers the blocks whose context lines overlap.
+		j := i + 1
+		for j < len(blocks) && blocks[j].oldStart-blocks[j-1].oldEnd <= 2*diffContextLines {
+			j++
+		}
+		hunkStart := blocks[i].oldStart - diffContextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := blocks[j-1].oldEnd + diffContextLines
+		if hunkEnd > len(lines) {
+			hunkEnd = len(lines)
+		}
+
+		var hunk strings.Builder
+		oldLen, newLen := 0, 0
+		pos := hunkStart
+		context := func(to int) {
+			for ; pos < to; pos++ {
+				writeDiffLine(&hunk, ' ', lines[pos])
+				oldLen++
+				newLen++
+			}
+		}
+		for _, blk := range blocks[i:j] {
+			context(blk.oldStart)
+			for _, l := range lines[blk.oldStart:blk.oldEnd] {
+				writeDiffLine(&hunk, '-', l)
+				oldLen++
+			}
+			for _, l := range blk.newLines {
+				writeDiffLine(&hunk, '+', l)
+				newLen++
+			}
+			pos = blk.oldEnd
+		}
+		context(hunkEnd)
+
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(hunkStart, oldLen), hunkRange(hunkStart+delta, newLen))
+		b.WriteString(hunk.String())
+		delta += newLen - oldLen
+		i = j
+	}
+	return b.String()
+}
+
+// writeDiffLine writes line l of a hunk with the given prefix.
+func writeDiffLine(b *strings.Builder, prefix byte, l []byte) {
+	b.WriteByte(prefix)
+	b.Write(l)
+	if len(l) == 0 || l[len(l)-1] != '\n' {
+		b.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// hunkRange formats the lines [start, start+n) for a hunk header. Empty
+// ranges are given by the line before them.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// splitLines splits data after each newline. The last line lacks a newline
+// if data doesn't end in one.
+func splitLines(data []byte) [][]byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,152 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/zoekt/query"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	subs := []Submatch{{Group: 1, Value: "foo"}, {Group: 2, Value: "bar"}}
+	for template, want := range map[string]string{
+		"plain":       "plain",
+		"$2.$1":       "bar.foo",
+		"${1}x":       "foox",
+		"$1x":         "",
+		"[$0]":        "[foo=bar]",
+		"$$1":         "$1",
+		"$3-":         "-",
+		"cost: $":     "cost: $",
+		"${1":         "${1",
+		"a$_b":        "a",
+		"${2}${2}$1!": "barbarfoo!",
+	} {
+		if got := string(expandTemplate(template, []byte("foo=bar"), subs)); got != want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", template, got, want)
+		}
+	}
+}
+
+func TestReplaceDiff(t *testing.T) {
+	content := "package main\n" +
+		"\n" +
+		"func main() {\n" +
+		"\tlog.Printf(\"a\")\n" +
+		"\tx := 1\n" +
+		"\ty := 2\n" +
+		"\tz := 3\n" +
+		"\tw := 4\n" +
+		"\tv := 5\n" +
+		"\tu := 6\n" +
+		"\tt := 7\n" +
+		"\tlog.Printf(\"b\"); log.Printf(\"c\")\n" +
+		"}"
+	b := testIndexBuilder(t, nil,
+		Document{Name: "main.go", Content: []byte(content)},
+		Document{Name: "other.go", Content: []byte("no match\n")})
+
+	q := &query.Regexp{Regexp: mustParseRE(`log\.(Printf)`), Content: true}
+	want := "--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -1,7 +1,7 @@\n" +
+		" package main\n" +
+		" \n" +
+		" func main() {\n" +
+		"-\tlog.Printf(\"a\")\n" +
+		"+\tfmt.Printf(\"a\")\n" +
+		" \tx := 1\n" +
+		" \ty := 2\n" +
+		" \tz := 3\n" +
+		"@@ -9,5 +9,5 @@\n" +
+		" \tv := 5\n" +
+		" \tu := 6\n" +
+		" \tt := 7\n" +
+		"-\tlog.Printf(\"b\"); log.Printf(\"c\")\n" +
+		"+\tfmt.Printf(\"b\"); fmt.Printf(\"c\")\n" +
+		" }\n" +
+		"\\ No newline at end of file\n"
+
+	for _, chunks := range []bool{false, true} {
+		res := searchForTest(t, b, q, SearchOptions{Whole: true, CaptureGroups: true, ChunkMatches: chunks})
+		if len(res.Files) != 1 {
+			t.Fatalf("got %d files, want 1", len(res.Files))
+		}
+		got, err := ReplaceDiff(&res.Files[0], "fmt.$1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("chunks=%v: diff mismatch (-want +got):\n%s", chunks, d)
+		}
+
+		// A replacement that changes nothing has no diff.
+		if got, err := ReplaceDiff(&res.Files[0], "$0"); err != nil || got != "" {
+			t.Errorf("chunks=%v: got %q, %v for identity replacement", chunks, got, err)
+		}
+	}
+
+	res := searchForTest(t, b, q)
+	if _, err := ReplaceDiff(&res.Files[0], "x"); err == nil {
+		t.Error("want error without content")
+	}
+}
+
+func TestReplaceDiffMultilineMatch(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "f.go", Content: []byte("func empty() {\n}\n")})
+
+	q := &query.Regexp{Regexp: mustParseRE(`empty.*?\}`), Content: true, Multiline: true}
+	res := searchForTest(t, b, q, SearchOptions{Whole: true, ChunkMatches: true})
+	if len(res.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(res.Files))
+	}
+	got, err := ReplaceDiff(&res.Files[0], "X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a/f.go\n" +
+		"+++ b/f.go\n" +
+		"@@ -1,2 +1,1 @@\n" +
+		"-func empty() {\n" +
+		"-}\n" +
+		"+func X\n"
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestUnifiedDiffMultiline(t *testing.T) {
+	content := []byte("a\nb\nc\n")
+	// Join the first two lines, and append a line at the end.
+	got := unifiedDiff("f", content, []replaceEdit{
+		{start: 6, end: 6, text: []byte("d\n")},
+		{start: 1, end: 2, text: []byte(" ")},
+	})
+	want := "--- a/f\n" +
+		"+++ b/f\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		"-a\n" +
+		"-b\n" +
+		"+a b\n" +
+		" c\n" +
+		"+d\n"
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
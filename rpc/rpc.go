@@ -133,10 +133,13 @@ func RegisterGob() {
 		gob.Register(&query.BranchesRepos{})
 		gob.Register(&query.Branch{})
 		gob.Register(&query.Const{})
+		gob.Register(&query.Diff{})
 		gob.Register(&query.GobCache{})
 		gob.Register(&query.Language{})
 		gob.Register(&query.Not{})
+		gob.Register(&query.Near{})
 		gob.Register(&query.Or{})
+		gob.Register(&query.Owner{})
 		gob.Register(&query.Regexp{})
 		gob.Register(&query.RepoRegexp{})
 		gob.Register(&query.RepoSet{})
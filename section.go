@@ -16,6 +16,7 @@ package zoekt
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"log"
 )
@@ -27,6 +28,12 @@ type writer struct {
 	err error
 	w   io.Writer
 	off uint32
+
+	// crc is the CRC of the data written since the last section start.
+	crc uint32
+
+	// sectionCRCs holds the CRC of each section written so far.
+	sectionCRCs map[simpleSection]uint32
 }
 
 func (w *writer) Write(b []byte) {
@@ -37,6 +44,7 @@ func (w *writer) Write(b []byte) {
 	var n int
 	n, w.err = w.w.Write(b)
 	w.off += uint32(n)
+	w.crc = crc32.Update(w.crc, crcTab, b[:n])
 }
 
 func (w *writer) Off() uint32 { return w.off }
@@ -72,10 +80,15 @@ func (w *writer) String(s string) {
 
 func (s *simpleSection) start(w *writer) {
 	s.off = w.Off()
+	w.crc = 0
 }
 
 func (s *simpleSection) end(w *writer) {
 	s.sz = w.Off() - s.off
+	if w.sectionCRCs == nil {
+		w.sectionCRCs = map[simpleSection]uint32{}
+	}
+	w.sectionCRCs[*s] = w.crc
 }
 
 // section is a range of bytes in the index file.
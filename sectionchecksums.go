@@ -0,0 +1,122 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// sectionRanges returns the byte ranges making up sec.
+func sectionRanges(sec section) []simpleSection {
+	switch s := sec.(type) {
+	case *simpleSection:
+		return []simpleSection{*s}
+	case *compoundSection:
+		return []simpleSection{s.data, s.index}
+	case *lazyCompoundSection:
+		return []simpleSection{s.data, s.index}
+	}
+	return nil
+}
+
+// writeSectionChecksums writes the CRCs of the sections in toc to
+// toc.sectionChecksums. It must be called after all other sections are
+// written.
+//
+// The section holds a list of entries. Each is the tag of a section, the
+// number of byte ranges of the section, and the CRC-32C of each range.
+func writeSectionChecksums(w *writer, toc *indexTOC) {
+	toc.sectionChecksums.start(w)
+	for _, ent := range toc.sectionsTaggedList() {
+		if ent.sec == &toc.sectionChecksums {
+			continue
+		}
+		ranges := sectionRanges(ent.sec)
+		w.String(ent.tag)
+		w.Varint(uint32(len(ranges)))
+		for _, r := range ranges {
+			// Sections that weren't written are empty, and the CRC
+			// of no data is 0.
+			w.U32(w.sectionCRCs[r])
+		}
+	}
+	toc.sectionChecksums.end(w)
+}
+
+// verifySectionChecksums checks the sections in toc against the CRCs in
+// toc.sectionChecksums.
+func verifySectionChecksums(f IndexFile, toc *indexTOC) error {
+	sec := toc.sectionChecksums
+	if sec.sz == 0 {
+		// Written before feature version 16.
+		return nil
+	}
+
+	secs := toc.sectionsTagged()
+	r := &reader{r: f, off: sec.off}
+	for r.off < sec.off+sec.sz {
+		tag, err := r.Str()
+		if err != nil {
+			return err
+		}
+		n, err := r.Varint()
+		if err != nil {
+			return err
+		}
+		want := make([]uint32, 0, n)
+		for i := uint64(0); i < n; i++ {
+			crc, err := r.U32()
+			if err != nil {
+				return err
+			}
+			want = append(want, crc)
+		}
+
+		s := secs[tag]
+		if s == nil {
+			// A section from a newer version; we can't check it.
+			continue
+		}
+		ranges := sectionRanges(s)
+		if len(ranges) != len(want) {
+			return fmt.Errorf("section %q: got %d checksums, want %d", tag, len(want), len(ranges))
+		}
+		for i, rng := range ranges {
+			data, err := f.Read(rng.off, rng.sz)
+			if err != nil {
+				return fmt.Errorf("section %q: %w", tag, err)
+			}
+			if got := crc32.Checksum(data, crcTab); got != want[i] {
+				return fmt.Errorf("section %q: checksum mismatch for bytes [%d, %d): got %08x, want %08x",
+					tag, rng.off, rng.off+rng.sz, got, want[i])
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyChecksums checks the sections of the index file r against the
+// checksums recorded when it was written. It reads the whole file. Files
+// written before feature version 16 have no checksums, and always pass. The
+// IndexFile is not closed.
+func VerifyChecksums(r IndexFile) error {
+	rd := &reader{r: r}
+	var toc indexTOC
+	if err := rd.readTOC(&toc); err != nil {
+		return err
+	}
+	return verifySectionChecksums(r, &toc)
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "repo"},
+		Document{Name: "f1", Content: []byte("the quick brown fox")},
+		Document{Name: "f2", Content: []byte("the lazy dog")})
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	if err := VerifyChecksums(&memSeeker{good}); err != nil {
+		t.Fatalf("VerifyChecksums on good shard: %v", err)
+	}
+
+	rd := &reader{r: &memSeeker{good}}
+	var toc indexTOC
+	if err := rd.readTOC(&toc); err != nil {
+		t.Fatal(err)
+	}
+	if toc.sectionChecksums.sz == 0 {
+		t.Fatal("no section checksums written")
+	}
+
+	for _, tc := range []struct {
+		tag string
+		off uint32
+	}{
+		{"fileContents", toc.fileContents.data.off},
+		{"postings", toc.postings.index.off + 2},
+		{"metaData", toc.metaData.off},
+	} {
+		bad := append([]byte{}, good...)
+		bad[tc.off] ^= 0x1
+		err := VerifyChecksums(&memSeeker{bad})
+		if err == nil || !strings.Contains(err.Error(), `"`+tc.tag+`"`) {
+			t.Errorf("%s: got %v, want checksum error for the section", tc.tag, err)
+		}
+	}
+}
+
+func TestVerifyChecksumsOldShards(t *testing.T) {
+	// Shards written before feature version 16 have no checksums.
+	paths, err := filepath.Glob("testdata/shards/ctagsrepo_v16.*.zoekt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no old shards found")
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyChecksums(&memSeeker{data}); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+}
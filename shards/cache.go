@@ -0,0 +1,213 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shards
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/query"
+)
+
+var (
+	metricSearchCacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_search_cache_hits_total",
+		Help: "The total number of searches answered from the result cache.",
+	})
+	metricSearchCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_search_cache_misses_total",
+		Help: "The total number of cacheable searches not found in the result cache.",
+	})
+	metricSearchCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_search_cache_bytes",
+		Help: "The estimated size of the results in the result cache.",
+	})
+)
+
+// maxCacheKeyLen is the length above which cache keys are hashed.
+const maxCacheKeyLen = 1024
+
+// resultCache holds the results of recent searches of the current
+// shardSnapshot, so it never returns results of other shards.
+type resultCache struct {
+	maxBytes int
+
+	mu    sync.Mutex
+	lru   *lru.Cache
+	bytes int
+
+	// generation is the generation of the snapshot of the cached results.
+	generation uint64
+}
+
+type cacheEntry struct {
+	sr   *zoekt.SearchResult
+	size int
+}
+
+// newResultCache returns a cache of at most maxEntries results, whose
+// estimated size is at most maxBytes. It returns nil if maxEntries is 0.
+func newResultCache(maxEntries, maxBytes int) *resultCache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	c := &resultCache{
+		maxBytes: maxBytes,
+		lru:      lru.New(maxEntries),
+	}
+	c.lru.OnEvicted = func(_ lru.Key, v interface{}) {
+		c.bytes -= v.(*cacheEntry).size
+	}
+	return c
+}
+
+// resultCacheKey returns the key of the search for q with opts, and false if
+// its results shouldn't be cached.
+func resultCacheKey(q query.Q, opts *zoekt.SearchOptions) (string, bool) {
+	if opts.NoCache {
+		return "", false
+	}
+
+	// The String of large repository sets only has their size, so we add
+	// their names, eg. for the sets of authz.Authorizer.
+	var sets strings.Builder
+	cacheable := true
+	query.VisitAtoms(q, func(q query.Q) {
+		switch q := q.(type) {
+		case *query.RepoSet:
+			names := make([]string, 0, len(q.Set))
+			for name := range q.Set {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(&sets, " %q", names)
+		case *query.BranchesRepos:
+			cacheable = false
+		}
+	})
+	if !cacheable {
+		return "", false
+	}
+
+	// Tracing and the client don't change the results.
+	o := *opts
+	o.Trace = false
+	o.SpanContext = nil
+	o.Client = ""
+	key := fmt.Sprintf("%s %#v%s", query.Simplify(q), o, sets.String())
+	if len(key) > maxCacheKeyLen {
+		sum := sha256.Sum256([]byte(key))
+		key = string(sum[:])
+	}
+	return key, true
+}
+
+// get returns the cached result for key in the snapshot generation, or nil.
+func (c *resultCache) get(generation uint64, key string) *zoekt.SearchResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var v interface{}
+	ok := generation == c.generation
+	if ok {
+		v, ok = c.lru.Get(key)
+	}
+	if !ok {
+		metricSearchCacheMissesTotal.Inc()
+		return nil
+	}
+	metricSearchCacheHitsTotal.Inc()
+
+	// Callers may modify the result, but not the files.
+	sr := *v.(*cacheEntry).sr
+	sr.Files = append([]zoekt.FileMatch(nil), sr.Files...)
+	return &sr
+}
+
+// add caches sr, the result of searching the snapshot generation, under key.
+// Results of snapshots older than the cached ones are dropped.
+func (c *resultCache) add(generation uint64, key string, sr *zoekt.SearchResult) {
+	size := resultSize(sr)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation < c.generation {
+		return
+	}
+	if generation > c.generation {
+		c.clearLocked(generation)
+	}
+	// Replace an entry added by a concurrent search.
+	c.lru.Remove(key)
+	c.lru.Add(key, &cacheEntry{sr: sr, size: size})
+	c.bytes += size
+	for c.maxBytes > 0 && c.bytes > c.maxBytes {
+		c.lru.RemoveOldest()
+	}
+	metricSearchCacheBytes.Set(float64(c.bytes))
+}
+
+// clear removes the results of snapshots older than generation.
+func (c *resultCache) clear(generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation > c.generation {
+		c.clearLocked(generation)
+	}
+}
+
+func (c *resultCache) clearLocked(generation uint64) {
+	c.generation = generation
+	c.lru.Clear()
+	c.bytes = 0
+	metricSearchCacheBytes.Set(0)
+}
+
+// resultSize estimates the memory used by sr.
+func resultSize(sr *zoekt.SearchResult) int {
+	// Roughly the size of the structs, without the data they point to.
+	const fileSize, matchSize = 300, 100
+
+	size := fileSize
+	for k, v := range sr.RepoURLs {
+		size += len(k) + len(v)
+	}
+	for k, v := range sr.LineFragments {
+		size += len(k) + len(v)
+	}
+	for _, f := range sr.Files {
+		size += fileSize + len(f.FileName) + len(f.Repository) + len(f.Language) + len(f.Content) + len(f.Checksum)
+		for _, l := range f.LineMatches {
+			size += matchSize + len(l.Line) + len(l.Before) + len(l.After) + matchSize*len(l.LineFragments)
+		}
+		for _, c := range f.ChunkMatches {
+			size += matchSize + len(c.Content) + matchSize*len(c.Ranges)
+		}
+	}
+	return size
+}
@@ -0,0 +1,198 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shards
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/query"
+)
+
+// countingSearcher counts the calls to Search.
+type countingSearcher struct {
+	zoekt.Searcher
+	searches int64
+}
+
+func (s *countingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	atomic.AddInt64(&s.searches, 1)
+	return s.Searcher.Search(ctx, q, opts)
+}
+
+func TestShardedSearcher_Cache(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.cache = newResultCache(10, 0)
+
+	s1 := &countingSearcher{Searcher: searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+		zoekt.Document{Name: "f1.go", Content: []byte("needle")}))}
+	ss.replace(map[string]zoekt.Searcher{"1": s1})
+
+	search := func(opts *zoekt.SearchOptions) int {
+		t.Helper()
+		res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.Files)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := search(&zoekt.SearchOptions{}); got != 1 {
+			t.Fatalf("got %d files, want 1", got)
+		}
+	}
+	if s1.searches != 1 {
+		t.Errorf("searched the shard %d times, want 1", s1.searches)
+	}
+
+	search(&zoekt.SearchOptions{NoCache: true})
+	if s1.searches != 2 {
+		t.Errorf("NoCache: searched the shard %d times, want 2", s1.searches)
+	}
+
+	search(&zoekt.SearchOptions{Whole: true})
+	if s1.searches != 3 {
+		t.Errorf("other options: searched the shard %d times, want 3", s1.searches)
+	}
+
+	// Loading a shard clears the cache.
+	ss.replace(map[string]zoekt.Searcher{
+		"2": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+			zoekt.Document{Name: "f2.go", Content: []byte("needle")})),
+	})
+	if got := search(&zoekt.SearchOptions{}); got != 2 {
+		t.Errorf("after replace: got %d files, want 2", got)
+	}
+	if s1.searches != 4 {
+		t.Errorf("after replace: searched the shard %d times, want 4", s1.searches)
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	sr := func(name string) *zoekt.SearchResult {
+		return &zoekt.SearchResult{Files: []zoekt.FileMatch{{FileName: name}}}
+	}
+
+	t.Run("entries", func(t *testing.T) {
+		c := newResultCache(2, 0)
+		for _, k := range []string{"a", "b", "c"} {
+			c.add(0, k, sr(k))
+		}
+		if got := c.get(0, "a"); got != nil {
+			t.Error("a wasn't evicted")
+		}
+		if got := c.get(0, "c"); got == nil || got.Files[0].FileName != "c" {
+			t.Errorf("got %v for c", got)
+		}
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		size := resultSize(sr("a"))
+		c := newResultCache(10, 2*size)
+		for _, k := range []string{"a", "b", "c"} {
+			c.add(0, k, sr(k))
+		}
+		if got := c.get(0, "a"); got != nil {
+			t.Error("a wasn't evicted")
+		}
+		if c.bytes != 2*size {
+			t.Errorf("got %d bytes, want %d", c.bytes, 2*size)
+		}
+	})
+
+	t.Run("generation", func(t *testing.T) {
+		c := newResultCache(10, 0)
+		c.clear(1)
+		c.add(0, "a", sr("a"))
+		if got := c.get(1, "a"); got != nil {
+			t.Error("cached a result of shards that were replaced")
+		}
+
+		c.add(1, "b", sr("b"))
+		if got := c.get(0, "b"); got != nil {
+			t.Error("returned a result of other shards")
+		}
+		c.add(2, "c", sr("c"))
+		if got := c.get(1, "b"); got != nil {
+			t.Error("kept the results of older shards")
+		}
+		if got := c.get(2, "c"); got == nil {
+			t.Error("didn't cache the result of newer shards")
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		c := newResultCache(10, 0)
+		c.add(0, "a", sr("a"))
+		got := c.get(0, "a")
+		got.Files[0].FileName = "changed"
+		if got := c.get(0, "a"); got.Files[0].FileName != "a" {
+			t.Error("changing a result changed the cache")
+		}
+	})
+}
+
+func TestResultCacheKey(t *testing.T) {
+	q := &query.Substring{Pattern: "needle"}
+	k1, ok := resultCacheKey(q, &zoekt.SearchOptions{SpanContext: map[string]string{"a": "1"}})
+	if !ok {
+		t.Fatal("not cacheable")
+	}
+	if k2, _ := resultCacheKey(q, &zoekt.SearchOptions{Trace: true}); k1 != k2 {
+		t.Errorf("tracing changed the key: %q != %q", k1, k2)
+	}
+	if k2, _ := resultCacheKey(q, &zoekt.SearchOptions{Client: "dashboards"}); k1 != k2 {
+		t.Errorf("the client changed the key: %q != %q", k1, k2)
+	}
+	if k2, _ := resultCacheKey(q, &zoekt.SearchOptions{MaxDocDisplayCount: 1}); k1 == k2 {
+		t.Errorf("options didn't change the key %q", k1)
+	}
+
+	// Content-only regexps match fewer files than regexps that match
+	// file names too.
+	for i, s := range []string{"content:a.*b", "a.*b"} {
+		re, err := query.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, _ := resultCacheKey(re, &zoekt.SearchOptions{})
+		if i > 0 && k == k1 {
+			t.Errorf("content:a.*b and a.*b have the same key %q", k)
+		}
+		k1 = k
+	}
+
+	set := func(names ...string) query.Q {
+		s := &query.RepoSet{Set: map[string]bool{}}
+		for _, n := range names {
+			s.Set[n] = true
+		}
+		return query.NewAnd(s, q)
+	}
+	// The String of sets of more than 5 repositories only has their size.
+	k1, _ = resultCacheKey(set("a", "b", "c", "d", "e", "f"), &zoekt.SearchOptions{})
+	k2, _ := resultCacheKey(set("a", "b", "c", "d", "e", "g"), &zoekt.SearchOptions{})
+	if k1 == k2 {
+		t.Errorf("different repository sets have the same key %q", k1)
+	}
+
+	branches := query.NewAnd(q, &query.BranchesRepos{})
+	if _, ok := resultCacheKey(branches, &zoekt.SearchOptions{}); ok {
+		t.Error("queries with BranchesRepos shouldn't be cached")
+	}
+}
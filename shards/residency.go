@@ -0,0 +1,388 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shards
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/query"
+)
+
+var (
+	metricShardsResident = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_shards_resident",
+		Help: "The number of loaded shards that are currently open.",
+	})
+	metricShardsResidentBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_shards_resident_bytes",
+		Help: "The estimated memory used by the open shards, including their mapped files.",
+	})
+	metricShardsReopenedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_shards_reopened_total",
+		Help: "The total number of closed shards opened again for a search.",
+	})
+	metricShardsEvictedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_shards_evicted_total",
+		Help: "The total number of shards closed to stay within the resident budget.",
+	})
+)
+
+// residencyManager keeps the open shards within a memory budget. Shards are
+// closed in least recently used order, and opened again when searched.
+type residencyManager struct {
+	// maxBytes is the budget for the memory of the open shards. Shards in
+	// use are never closed, so it may be exceeded while they are searched.
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	lru   *list.List // of *lazyShard, most recently used first
+}
+
+// newResidencyManager returns a manager with a budget of maxBytes, or nil if
+// maxBytes is 0.
+func newResidencyManager(maxBytes int64) *residencyManager {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &residencyManager{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+	}
+}
+
+// lazyShard is a shard that is only open while it is in the residency
+// budget. It keeps the repository metadata of the shard, so it can be ranked
+// and listed while closed.
+type lazyShard struct {
+	m    *residencyManager
+	path string
+
+	// fi is the file loaded from path. A newer file at path doesn't match
+	// the repository metadata we keep, so it isn't opened in place of fi;
+	// the watcher loads it as a new shard.
+	fi os.FileInfo
+
+	// repos are the repositories of the shard, with their stats.
+	repos []*zoekt.RepoListEntry
+
+	// cost is the estimated memory used by the open shard.
+	cost int64
+
+	// openMu serializes opening the shard.
+	openMu sync.Mutex
+
+	// The fields below are protected by m.mu.
+	searcher zoekt.Searcher // nil while closed
+	elem     *list.Element  // in m.lru while open
+	inUse    int
+	closed   bool
+}
+
+// load opens the shard at path and adds it to the budget, which may close
+// other shards. verifyChecksums is passed to loadShard.
+func (m *residencyManager) load(path string, verifyChecksums bool) (*lazyShard, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadShard(path, verifyChecksums)
+	if err != nil {
+		return nil, err
+	}
+	rl, err := s.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	l := &lazyShard{
+		m:    m,
+		path: path,
+		fi:   fi,
+		cost: fi.Size(),
+	}
+	for _, r := range rl.Repos {
+		// Copy, since r points into the shard's memory.
+		r := *r
+		l.repos = append(l.repos, &r)
+		l.cost += r.Stats.IndexBytes
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.open(l, s)
+	return l, nil
+}
+
+// open adds l to the budget as the most recently used shard. It must be
+// called with m.mu held.
+func (m *residencyManager) open(l *lazyShard, s zoekt.Searcher) {
+	l.searcher = s
+	l.elem = m.lru.PushFront(l)
+	m.bytes += l.cost
+	m.evict()
+}
+
+// close closes the searcher of l and removes it from the budget. It must be
+// called with m.mu held.
+func (m *residencyManager) close(l *lazyShard) {
+	l.searcher.Close()
+	l.searcher = nil
+	m.lru.Remove(l.elem)
+	l.elem = nil
+	m.bytes -= l.cost
+}
+
+// evict closes the least recently used shards that aren't in use until the
+// open shards are within the budget. It must be called with m.mu held.
+func (m *residencyManager) evict() {
+	for e := m.lru.Back(); e != nil && m.bytes > m.maxBytes; {
+		l := e.Value.(*lazyShard)
+		e = e.Prev()
+		if l.inUse > 0 {
+			continue
+		}
+		m.close(l)
+		metricShardsEvictedTotal.Inc()
+	}
+	m.updateMetrics()
+}
+
+// updateMetrics must be called with m.mu held.
+func (m *residencyManager) updateMetrics() {
+	metricShardsResident.Set(float64(m.lru.Len()))
+	metricShardsResidentBytes.Set(float64(m.bytes))
+}
+
+// acquire returns the searcher of l, opening it if needed. The caller must
+// call release when it no longer references the searcher's memory.
+func (l *lazyShard) acquire() (zoekt.Searcher, error) {
+	m := l.m
+	m.mu.Lock()
+	if l.closed {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("shard %s is closed", l.path)
+	}
+	l.inUse++
+	if l.searcher != nil {
+		m.lru.MoveToFront(l.elem)
+		s := l.searcher
+		m.mu.Unlock()
+		return s, nil
+	}
+	m.mu.Unlock()
+
+	// Open without holding m.mu, so searches of open shards don't wait for
+	// the disk.
+	l.openMu.Lock()
+	defer l.openMu.Unlock()
+
+	m.mu.Lock()
+	s := l.searcher
+	m.mu.Unlock()
+	if s != nil {
+		// Opened by a concurrent search.
+		return s, nil
+	}
+
+	// Any checksums were verified when the shard was loaded.
+	s, err := l.reopen()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	metricShardsReopenedTotal.Inc()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l.closed {
+		// Closed while we were opening it.
+		s.Close()
+		l.inUse--
+		return nil, fmt.Errorf("shard %s is closed", l.path)
+	}
+	m.open(l, s)
+	return s, nil
+}
+
+// errShardReplaced is returned when opening a shard whose file was replaced
+// since it was loaded.
+var errShardReplaced = errors.New("shard file was replaced")
+
+func (l *lazyShard) reopen() (zoekt.Searcher, error) {
+	fi, err := os.Stat(l.path)
+	if err != nil {
+		return nil, err
+	}
+	if !os.SameFile(l.fi, fi) {
+		return nil, errShardReplaced
+	}
+	return loadShard(l.path, false)
+}
+
+func (l *lazyShard) release() {
+	m := l.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.inUse--
+	if l.inUse > 0 {
+		return
+	}
+	if l.closed {
+		if l.searcher != nil {
+			m.close(l)
+			m.updateMetrics()
+		}
+		return
+	}
+	m.evict()
+}
+
+func (l *lazyShard) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s, err := l.acquire()
+	if errors.Is(err, errShardReplaced) {
+		// Until the watcher loads the new file, the shard can't be
+		// searched. Report it like a crash, so the result is known to
+		// be incomplete.
+		log.Printf("skipping %s: %v", l, err)
+		return &zoekt.SearchResult{Stats: zoekt.Stats{Crashes: 1}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	sr, err := s.Search(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	// The shard may be closed once released, so the result can't
+	// reference its memory.
+	copyFiles(sr)
+	return sr, nil
+}
+
+// List answers queries on repository metadata without opening the shard.
+func (l *lazyShard) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	minimal := opts != nil && opts.Minimal
+
+	var rl zoekt.RepoList
+	if minimal {
+		rl.Minimal = make(map[uint32]*zoekt.MinimalRepoListEntry, len(l.repos))
+	}
+	for _, r := range l.repos {
+		include, ok := evalRepoQuery(q, &r.Repository)
+		if !ok {
+			// q depends on the contents of the shard.
+			return l.listOpen(ctx, q, opts)
+		}
+		if !include {
+			continue
+		}
+
+		rl.Stats.Add(&r.Stats)
+		if id := r.Repository.ID; id != 0 && minimal {
+			rl.Minimal[id] = &zoekt.MinimalRepoListEntry{
+				HasSymbols: r.Repository.HasSymbols,
+				Branches:   r.Repository.Branches,
+			}
+		} else {
+			rl.Repos = append(rl.Repos, r)
+		}
+	}
+	return &rl, nil
+}
+
+func (l *lazyShard) listOpen(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	s, err := l.acquire()
+	if errors.Is(err, errShardReplaced) {
+		log.Printf("skipping %s: %v", l, err)
+		return &zoekt.RepoList{Crashes: 1}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	rl, err := s.List(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the entries, since they point into the shard's memory.
+	for i, r := range rl.Repos {
+		r := *r
+		rl.Repos[i] = &r
+	}
+	return rl, nil
+}
+
+// evalRepoQuery evaluates q for the files of repo, if it only depends on
+// the repository. Otherwise, it returns false for ok.
+func evalRepoQuery(q query.Q, repo *zoekt.Repository) (include, ok bool) {
+	q = query.Map(q, func(q query.Q) query.Q {
+		switch r := q.(type) {
+		case *query.Repo:
+			return &query.Const{Value: r.Regexp.MatchString(repo.Name)}
+		case *query.RepoRegexp:
+			return &query.Const{Value: r.Regexp.MatchString(repo.Name)}
+		case *query.RepoSet:
+			return &query.Const{Value: r.Set[repo.Name]}
+		case *query.BranchesRepos:
+			for _, br := range r.List {
+				if !br.Repos.Contains(repo.ID) {
+					continue
+				}
+				for _, b := range repo.Branches {
+					if b.Name == br.Branch {
+						return &query.Const{Value: true}
+					}
+				}
+			}
+			return &query.Const{Value: false}
+		}
+		return q
+	})
+	if c, ok := query.Simplify(q).(*query.Const); ok {
+		return c.Value, true
+	}
+	return false, false
+}
+
+// Close closes the shard, once the ongoing searches are done.
+func (l *lazyShard) Close() {
+	m := l.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.closed = true
+	if l.searcher != nil && l.inUse == 0 {
+		m.close(l)
+		m.updateMetrics()
+	}
+}
+
+func (l *lazyShard) String() string {
+	return fmt.Sprintf("lazy(%s)", l.path)
+}
@@ -0,0 +1,233 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shards
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/grafana/regexp"
+
+	"github.com/google/zoekt"
+	"github.com/google/zoekt/query"
+)
+
+// writeShards writes a shard with a file "f.go" containing "needle" for
+// each name, and returns their paths.
+func writeShards(t *testing.T, names ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i, name := range names {
+		b := testIndexBuilder(t, &zoekt.Repository{ID: uint32(i + 1), Name: name},
+			zoekt.Document{Name: "f.go", Content: []byte("needle in " + name)})
+		var buf bytes.Buffer
+		if err := b.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%s.zoekt", name))
+		if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// resident returns the paths of the open shards of m, most recently used
+// first.
+func resident(m *residencyManager) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var paths []string
+	for e := m.lru.Front(); e != nil; e = e.Next() {
+		paths = append(paths, e.Value.(*lazyShard).path)
+	}
+	return paths
+}
+
+func TestResidencyManager(t *testing.T) {
+	paths := writeShards(t, "repo-a", "repo-b", "repo-c")
+
+	// Size the budget for two shards.
+	probe := newResidencyManager(1 << 40)
+	l, err := probe.load(paths[0], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	m := newResidencyManager(2*l.cost + l.cost/2)
+
+	shards := make([]*lazyShard, len(paths))
+	for i, p := range paths {
+		if shards[i], err = m.load(p, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff([]string{paths[2], paths[1]}, resident(m)); diff != "" {
+		t.Fatalf("resident after load (-want +got):\n%s", diff)
+	}
+
+	// Searching a closed shard opens it again, closing the least recently
+	// used one.
+	sr, err := shards[0].Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 1 || string(sr.Files[0].LineMatches[0].Line) != "needle in repo-a" {
+		t.Fatalf("got %+v, want the file of repo-a", sr.Files)
+	}
+	if diff := cmp.Diff([]string{paths[0], paths[2]}, resident(m)); diff != "" {
+		t.Fatalf("resident after search (-want +got):\n%s", diff)
+	}
+
+	// Repository queries are listed from the metadata of closed shards.
+	rl, err := shards[1].List(context.Background(), &query.Repo{Regexp: regexp.MustCompile("repo-b")}, &zoekt.ListOptions{Minimal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rl.Minimal[2]; !ok || len(rl.Minimal) != 1 {
+		t.Errorf("got %v, want repo-b", rl.Minimal)
+	}
+	rl, err = shards[1].List(context.Background(), &query.RepoSet{Set: map[string]bool{"repo-a": true}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 0 {
+		t.Errorf("got %v, want no repos", rl.Repos)
+	}
+	if diff := cmp.Diff([]string{paths[0], paths[2]}, resident(m)); diff != "" {
+		t.Fatalf("resident after List (-want +got):\n%s", diff)
+	}
+
+	// Other queries need the shard's contents.
+	rl, err = shards[1].List(context.Background(), &query.Substring{Pattern: "repo-b"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 || rl.Repos[0].Repository.Name != "repo-b" {
+		t.Errorf("got %v, want repo-b", rl.Repos)
+	}
+	if diff := cmp.Diff([]string{paths[1], paths[0]}, resident(m)); diff != "" {
+		t.Fatalf("resident after content List (-want +got):\n%s", diff)
+	}
+
+	for _, l := range shards {
+		l.Close()
+	}
+	if got := resident(m); len(got) != 0 || m.bytes != 0 {
+		t.Errorf("after Close: %d bytes in %v", m.bytes, got)
+	}
+	if _, err := shards[0].Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{}); err == nil {
+		t.Error("searched a closed shard")
+	}
+}
+
+func TestResidencyManager_InUse(t *testing.T) {
+	paths := writeShards(t, "repo-a", "repo-b")
+
+	// A budget smaller than any shard only keeps the shards in use open.
+	m := newResidencyManager(1)
+	a, err := m.load(paths[0], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resident(m); len(got) != 0 {
+		t.Fatalf("got %v open, want none", got)
+	}
+
+	if _, err := a.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.load(paths[1], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{paths[0]}, resident(m)); diff != "" {
+		t.Fatalf("resident while in use (-want +got):\n%s", diff)
+	}
+
+	// Closing a shard in use waits for the release.
+	a.Close()
+	if diff := cmp.Diff([]string{paths[0]}, resident(m)); diff != "" {
+		t.Fatalf("resident after Close (-want +got):\n%s", diff)
+	}
+	a.release()
+	if got := resident(m); len(got) != 0 || m.bytes != 0 {
+		t.Errorf("after release: %d bytes in %v", m.bytes, got)
+	}
+	b.Close()
+}
+
+func TestResidencyManager_Replaced(t *testing.T) {
+	paths := writeShards(t, "repo-a", "repo-b")
+
+	// The budget closes the shard right after loading it.
+	m := newResidencyManager(1)
+	l, err := m.load(paths[0], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A reindex renames another file onto the shard's path.
+	if err := os.Rename(paths[1], paths[0]); err != nil {
+		t.Fatal(err)
+	}
+	sr, err := l.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 0 || sr.Stats.Crashes != 1 {
+		t.Errorf("got %d files and %d crashes, want none and 1", len(sr.Files), sr.Stats.Crashes)
+	}
+}
+
+func TestShardedSearcher_Residency(t *testing.T) {
+	paths := writeShards(t, "repo-a", "repo-b", "repo-c")
+
+	ss := newShardedSearcher(2)
+	tl := &loader{ss: ss, residency: newResidencyManager(1)}
+	tl.load(paths, nil)
+	defer ss.Close()
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, string(f.LineMatches[0].Line))
+	}
+	sort.Strings(got)
+	want := []string{"needle in repo-a", "needle in repo-b", "needle in repo-c"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Search (-want +got):\n%s", diff)
+	}
+
+	rl, err := ss.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 3 {
+		t.Errorf("got %d repos, want 3", len(rl.Repos))
+	}
+}
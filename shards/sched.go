@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,9 +20,47 @@ import (
 // scheduler is for managing concurrent searches.
 type scheduler interface {
 	// Acquire blocks until a normal process is created (ie for a search
-	// request). See process documentation. It will only return an error if the
-	// context expires.
-	Acquire(ctx context.Context) (*process, error)
+	// request) for client, see zoekt.SearchOptions.Client. See process
+	// documentation. It will only return an error if the context expires.
+	Acquire(ctx context.Context, client string) (*process, error)
+}
+
+// SchedulerOptions configures how the scheduler shares the search capacity
+// between clients, see zoekt.SearchOptions.Client.
+type SchedulerOptions struct {
+	// Default is the quota of clients that aren't in Clients.
+	Default ClientQuota
+
+	// Clients are the quotas of individual clients. The scheduler metrics
+	// are reported for each of them; other clients are reported as
+	// "default".
+	Clients map[string]ClientQuota
+}
+
+// ClientQuota is the share of the search capacity of a client.
+type ClientQuota struct {
+	// Weight is the share of the capacity of the client while other
+	// clients wait, relative to their weights. If 0, the weight is 1.
+	Weight float64
+
+	// MaxConcurrent limits the searches of the client in each of the
+	// interactive and batch queues. If 0, only the capacity of the queue
+	// limits the client.
+	MaxConcurrent int
+}
+
+// quota returns the quota of client, and the name to report it as in
+// metrics.
+func (o *SchedulerOptions) quota(client string) (ClientQuota, string) {
+	q, ok := o.Clients[client]
+	label := client
+	if !ok {
+		q, label = o.Default, "default"
+	}
+	if q.Weight <= 0 {
+		q.Weight = 1
+	}
+	return q, label
 }
 
 // The ZOEKTSCHED environment variable controls variables within the
@@ -44,8 +83,9 @@ var zoektSched = parseTuneables(os.Getenv("ZOEKTSCHED"))
 
 // newScheduler returns a scheduler for use in searches. It will return a
 // multiScheduler unless that has been disabled with the environment variable
-// SCHED_DISABLE. If so it will an equivalent scheduler as upstream zoekt.
-func newScheduler(capacity int64) scheduler {
+// SCHED_DISABLE. If so it will an equivalent scheduler as upstream zoekt,
+// which ignores opts.
+func newScheduler(capacity int64, opts SchedulerOptions) scheduler {
 	if zoektSched["disable"] == 1 {
 		log.Println("ZOEKTSCHED=disable=1 specified. Using old zoekt scheduler.")
 		return &semaphoreScheduler{
@@ -53,7 +93,7 @@ func newScheduler(capacity int64) scheduler {
 			capacity: capacity,
 		}
 	}
-	return newMultiScheduler(capacity)
+	return newMultiScheduler(capacity, opts)
 }
 
 // multiScheduler is for managing concurrent searches. Its goals are:
@@ -88,6 +128,9 @@ func newScheduler(capacity int64) scheduler {
 // process starts as fast, but is downgraded to slow after a period of time.
 // time. Downgrading relies on a process co-operatively deciding to downgrade.
 //
+// Within each semaphore, clients share the capacity by their weights, see
+// sema.
+//
 // We intentionally keep the algorithm simple, but have a general interface to
 // allow improvements as we learn more.
 type multiScheduler struct {
@@ -99,7 +142,7 @@ type multiScheduler struct {
 	interactiveDuration time.Duration
 }
 
-func newMultiScheduler(capacity int64) *multiScheduler {
+func newMultiScheduler(capacity int64, opts SchedulerOptions) *multiScheduler {
 	batchdiv := zoektSched["batchdiv"]
 	if batchdiv == 0 {
 		// Burst up to 1/4 of interactive capacity for batch.
@@ -121,35 +164,35 @@ func newMultiScheduler(capacity int64) *multiScheduler {
 	}
 
 	return &multiScheduler{
-		semInteractive: newSema(capacity, "interactive"),
-		semBatch:       newSema(batchCap, "batch"),
+		semInteractive: newSema(capacity, "interactive", opts),
+		semBatch:       newSema(batchCap, "batch", opts),
 
 		interactiveDuration: time.Duration(interactiveseconds) * time.Second,
 	}
 }
 
 // Acquire implements scheduler.Acquire.
-func (s *multiScheduler) Acquire(ctx context.Context) (*process, error) {
+func (s *multiScheduler) Acquire(ctx context.Context, client string) (*process, error) {
 	// Start in interactive. yieldFunc will switch us to batch. sem can be nil
 	// if we fail while switching to batch. nil value prevents us releasing
 	// twice.
 	sem := s.semInteractive
 
-	if err := sem.Acquire(ctx); err != nil {
+	if err := sem.Acquire(ctx, client); err != nil {
 		return nil, err
 	}
 
 	return &process{
 		releaseFunc: func() {
 			if sem != nil {
-				sem.Release()
+				sem.Release(client)
 				sem = nil
 			}
 		},
 		yieldTimer: newDeadlineTimer(time.Now().Add(s.interactiveDuration)),
 		yieldFunc: func(ctx context.Context) error {
 			if sem != nil {
-				sem.Release()
+				sem.Release(client)
 				sem = nil
 			}
 
@@ -157,7 +200,7 @@ func (s *multiScheduler) Acquire(ctx context.Context) (*process, error) {
 			// clean it up. If this fails we assume the process will stop running
 			// (ctx has expired).
 			semNext := s.semBatch
-			if err := semNext.Acquire(ctx); err != nil {
+			if err := semNext.Acquire(ctx, client); err != nil {
 				return err
 			}
 
@@ -177,7 +220,7 @@ type semaphoreScheduler struct {
 }
 
 // Acquire implements scheduler.Acquire.
-func (s *semaphoreScheduler) Acquire(ctx context.Context) (*process, error) {
+func (s *semaphoreScheduler) Acquire(ctx context.Context, client string) (*process, error) {
 	return s.acquire(ctx, 1)
 }
 
@@ -338,6 +381,9 @@ func parseTuneables(v string) map[string]int {
 //
 //   - exclusive queued
 //   - exclusive running
+//
+// The interactive and batch states are also tracked per client, see
+// SchedulerOptions.Clients.
 var (
 	metricSched = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "zoekt_shards_sched",
@@ -347,20 +393,57 @@ var (
 		Name: "zoekt_shards_sched_total",
 		Help: "The total number of zoekt scheduler processes in a state.",
 	}, []string{"type", "state"})
+	metricSchedClient = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zoekt_shards_sched_client",
+		Help: "The current number of zoekt scheduler processes of a client in a state.",
+	}, []string{"client", "type", "state"})
+	metricSchedClientTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_shards_sched_client_total",
+		Help: "The total number of zoekt scheduler processes of a client in a state.",
+	}, []string{"client", "type", "state"})
 )
 
 // sema is a semaphore which tracks its state in prometheus.
+//
+// When it is full, waiting clients get the free slots by start-time fair
+// queueing: every slot a client gets advances its virtual time by 1/weight,
+// and the waiting client with the earliest virtual time goes next. A client
+// that was idle starts at the virtual time of the last slot given out, so it
+// can't save up time. Searches of a client run in the order they arrived.
 type sema struct {
-	sem *semaphore.Weighted
+	capacity int64
+	typ      string
+	opts     SchedulerOptions
+
+	mu      sync.Mutex
+	running int64
+	vtime   float64
+	// clients has the clients that are running or waiting.
+	clients map[string]*semaClient
 
 	metricQueued        *gaugeCounter
 	metricRunning       *gaugeCounter
 	metricTimedoutTotal prometheus.Counter
 }
 
-func newSema(capacity int64, typ string) *sema {
+type semaClient struct {
+	name  string
+	quota ClientQuota
+	label string
+
+	running int
+	// finish is the virtual time after the last slot of the client.
+	finish float64
+	// waiters are closed, in order, when the client gets a slot.
+	waiters []chan struct{}
+}
+
+func newSema(capacity int64, typ string, opts SchedulerOptions) *sema {
 	return &sema{
-		sem: semaphore.NewWeighted(capacity),
+		capacity: capacity,
+		typ:      typ,
+		opts:     opts,
+		clients:  map[string]*semaClient{},
 
 		metricQueued: &gaugeCounter{
 			gauge:   metricSched.WithLabelValues(typ, "queued"),
@@ -374,24 +457,148 @@ func newSema(capacity int64, typ string) *sema {
 	}
 }
 
-func (s *sema) Acquire(ctx context.Context) error {
+func (s *sema) Acquire(ctx context.Context, client string) error {
+	_, label := s.opts.quota(client)
+	clientQueued, clientRunning := s.clientMetrics(label)
+
 	s.metricQueued.Inc()
 	defer s.metricQueued.Dec()
+	clientQueued.Inc()
+	defer clientQueued.Dec()
+
+	s.mu.Lock()
+	c := s.client(client)
+	if len(c.waiters) == 0 && s.canRun(c) {
+		s.grant(c)
+		s.mu.Unlock()
+		s.metricRunning.Inc()
+		clientRunning.Inc()
+		return nil
+	}
+	ready := make(chan struct{})
+	c.waiters = append(c.waiters, ready)
+	s.mu.Unlock()
 
-	err := s.sem.Acquire(ctx, 1)
-	if err != nil {
-		s.metricTimedoutTotal.Inc()
-		return err
+	select {
+	case <-ready:
+		s.metricRunning.Inc()
+		clientRunning.Inc()
+		return nil
+	case <-ctx.Done():
 	}
 
-	s.metricRunning.Inc()
+	s.mu.Lock()
+	select {
+	case <-ready:
+		// We got a slot while giving up, pass it on.
+		s.release(c)
+	default:
+		for i, w := range c.waiters {
+			if w == ready {
+				c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+				break
+			}
+		}
+		s.forget(c)
+	}
+	s.mu.Unlock()
 
-	return nil
+	s.metricTimedoutTotal.Inc()
+	metricSchedClientTotal.WithLabelValues(label, s.typ, "timedout").Inc()
+	return ctx.Err()
 }
 
-func (s *sema) Release() {
-	s.sem.Release(1)
+func (s *sema) Release(client string) {
+	s.mu.Lock()
+	c := s.clients[client]
+	s.release(c)
+	s.mu.Unlock()
+
 	s.metricRunning.Dec()
+	_, clientRunning := s.clientMetrics(c.label)
+	clientRunning.Dec()
+}
+
+// client returns the state of the client named name. s.mu must be held.
+func (s *sema) client(name string) *semaClient {
+	c, ok := s.clients[name]
+	if !ok {
+		quota, label := s.opts.quota(name)
+		c = &semaClient{name: name, quota: quota, label: label}
+		s.clients[name] = c
+	}
+	return c
+}
+
+// clientMetrics returns the metrics of the client reported as label.
+func (s *sema) clientMetrics(label string) (queued, running *gaugeCounter) {
+	queued = &gaugeCounter{
+		gauge:   metricSchedClient.WithLabelValues(label, s.typ, "queued"),
+		counter: metricSchedClientTotal.WithLabelValues(label, s.typ, "queued"),
+	}
+	running = &gaugeCounter{
+		gauge:   metricSchedClient.WithLabelValues(label, s.typ, "running"),
+		counter: metricSchedClientTotal.WithLabelValues(label, s.typ, "running"),
+	}
+	return queued, running
+}
+
+// canRun reports whether c can get a slot now. s.mu must be held.
+func (s *sema) canRun(c *semaClient) bool {
+	return s.running < s.capacity && (c.quota.MaxConcurrent == 0 || c.running < c.quota.MaxConcurrent)
+}
+
+// grant gives a slot to c. s.mu must be held.
+func (s *sema) grant(c *semaClient) {
+	s.running++
+	c.running++
+	start := s.vtime
+	if c.finish > start {
+		start = c.finish
+	}
+	s.vtime = start
+	c.finish = start + 1/c.quota.Weight
+}
+
+// release returns a slot of c and passes the free slots on. s.mu must be
+// held.
+func (s *sema) release(c *semaClient) {
+	s.running--
+	c.running--
+
+	for s.running < s.capacity {
+		var next *semaClient
+		nextStart := 0.0
+		for _, w := range s.clients {
+			if len(w.waiters) == 0 || !s.canRun(w) {
+				continue
+			}
+			start := s.vtime
+			if w.finish > start {
+				start = w.finish
+			}
+			if next == nil || start < nextStart || (start == nextStart && w.name < next.name) {
+				next, nextStart = w, start
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		ready := next.waiters[0]
+		next.waiters = next.waiters[1:]
+		s.grant(next)
+		close(ready)
+	}
+
+	s.forget(c)
+}
+
+// forget drops c once it is neither running nor waiting. s.mu must be held.
+func (s *sema) forget(c *semaClient) {
+	if c.running == 0 && len(c.waiters) == 0 {
+		delete(s.clients, c.name)
+	}
 }
 
 // gaugeCounter is a wrapper around a gauge and a counter. Whenever the gauge
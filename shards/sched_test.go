@@ -52,9 +52,9 @@ func BenchmarkYield(b *testing.B) {
 	// Bencmark of actual yield function
 	b.Run("yield", func(b *testing.B) {
 		ctx := context.Background()
-		sched := newMultiScheduler(1)
+		sched := newMultiScheduler(1, SchedulerOptions{})
 		sched.interactiveDuration = quantum
-		proc, err := sched.Acquire(ctx)
+		proc, err := sched.Acquire(ctx, "")
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -73,9 +73,9 @@ func TestYield(t *testing.T) {
 	quantum := 10 * time.Millisecond
 	deadline := time.Now().Add(quantum)
 
-	sched := newMultiScheduler(1)
+	sched := newMultiScheduler(1, SchedulerOptions{})
 	sched.interactiveDuration = quantum
-	proc, err := sched.Acquire(ctx)
+	proc, err := sched.Acquire(ctx, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,13 +119,13 @@ func TestMultiScheduler(t *testing.T) {
 
 	capacity := 8
 	batchCap := capacity / 4
-	sched := newMultiScheduler(int64(capacity))
+	sched := newMultiScheduler(int64(capacity), SchedulerOptions{})
 	sched.interactiveDuration = 0 // instantly downgrade to batch on call to yield.
 
 	var procs []*process
 	addProc := func() {
 		t.Helper()
-		proc, err := sched.Acquire(ctx)
+		proc, err := sched.Acquire(ctx, "")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -143,7 +143,7 @@ func TestMultiScheduler(t *testing.T) {
 	}
 
 	// We expect this to fail since the queue is at capacity
-	if _, err := sched.Acquire(quickCtx(t)); err == nil {
+	if _, err := sched.Acquire(quickCtx(t), ""); err == nil {
 		t.Fatal("expected first acquire after cap to fail")
 	}
 
@@ -154,7 +154,7 @@ func TestMultiScheduler(t *testing.T) {
 	addProc()
 
 	// We expect this to fail since the queue is at capacity again.
-	if _, err := sched.Acquire(quickCtx(t)); err == nil {
+	if _, err := sched.Acquire(quickCtx(t), ""); err == nil {
 		t.Fatal("expected second acquire after cap to fail")
 	}
 
@@ -176,6 +176,134 @@ func TestMultiScheduler(t *testing.T) {
 	procs = nil
 }
 
+// waitForWaiters waits until n clients wait for sem.
+func waitForWaiters(t *testing.T, sem *sema, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		sem.mu.Lock()
+		waiting := 0
+		for _, c := range sem.clients {
+			waiting += len(c.waiters)
+		}
+		sem.mu.Unlock()
+		if waiting == n {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %d waiters", n)
+}
+
+func TestSemaFairness(t *testing.T) {
+	ctx := context.Background()
+	sem := newSema(1, "test", SchedulerOptions{
+		Clients: map[string]ClientQuota{"b": {Weight: 2}},
+	})
+
+	if err := sem.Acquire(ctx, "hold"); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string)
+	for i := 0; i < 4; i++ {
+		for _, client := range []string{"a", "b", "b"} {
+			go func(client string) {
+				if err := sem.Acquire(ctx, client); err != nil {
+					t.Error(err)
+				}
+				order <- client
+			}(client)
+		}
+	}
+	waitForWaiters(t, sem, 12)
+
+	var got []string
+	holder := "hold"
+	for i := 0; i < 12; i++ {
+		sem.Release(holder)
+		holder = <-order
+		got = append(got, holder)
+	}
+	sem.Release(holder)
+
+	// b has twice the weight of a, so it gets twice as many slots.
+	want := []string{"a", "b", "b", "a", "b", "b", "a", "b", "b", "a", "b", "b"}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", d)
+	}
+	if len(sem.clients) != 0 {
+		t.Errorf("clients weren't forgotten: %v", sem.clients)
+	}
+}
+
+func TestSemaMaxConcurrent(t *testing.T) {
+	ctx := context.Background()
+	sem := newSema(3, "test", SchedulerOptions{
+		Default: ClientQuota{MaxConcurrent: 2},
+		Clients: map[string]ClientQuota{"a": {MaxConcurrent: 1}},
+	})
+
+	if err := sem.Acquire(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sem.Acquire(quickCtx(t), "a"); err == nil {
+		t.Fatal("expected acquire over the limit of a to fail")
+	}
+	for i := 0; i < 2; i++ {
+		if err := sem.Acquire(ctx, "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Waiting for the limit of a doesn't block b.
+	done := make(chan error)
+	go func() { done <- sem.Acquire(ctx, "a") }()
+	waitForWaiters(t, sem, 1)
+	sem.Release("b")
+	if err := sem.Acquire(quickCtx(t), "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	sem.Release("a")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"a", "b", "c"} {
+		sem.Release(c)
+	}
+	if sem.running != 0 || len(sem.clients) != 0 {
+		t.Errorf("got %d running and clients %v, want none", sem.running, sem.clients)
+	}
+}
+
+func TestSemaCancel(t *testing.T) {
+	ctx := context.Background()
+	sem := newSema(1, "test", SchedulerOptions{})
+	if err := sem.Acquire(ctx, "hold"); err != nil {
+		t.Fatal(err)
+	}
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	canceled := make(chan error)
+	go func() { canceled <- sem.Acquire(canceledCtx, "a") }()
+	waitForWaiters(t, sem, 1)
+	done := make(chan error)
+	go func() { done <- sem.Acquire(ctx, "b") }()
+	waitForWaiters(t, sem, 2)
+
+	cancel()
+	if err := <-canceled; err == nil {
+		t.Fatal("expected canceled acquire to fail")
+	}
+	sem.Release("hold")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	sem.Release("b")
+	if sem.running != 0 || len(sem.clients) != 0 {
+		t.Errorf("got %d running and clients %v, want none", sem.running, sem.clients)
+	}
+}
+
 func quickCtx(t *testing.T) context.Context {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
 	t.Cleanup(cancel)
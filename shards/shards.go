@@ -31,6 +31,7 @@ import (
 	"golang.org/x/sync/semaphore"
 
 	"github.com/google/zoekt"
+	"github.com/google/zoekt/authz"
 	"github.com/google/zoekt/query"
 	"github.com/google/zoekt/stream"
 	"github.com/google/zoekt/trace"
@@ -51,6 +52,10 @@ var (
 		Name: "zoekt_shards_load_failed_total",
 		Help: "The total number of shard loads that failed",
 	})
+	metricShardsChecksumFailedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_shards_checksum_failed_total",
+		Help: "The total number of shard loads that failed because of a checksum mismatch",
+	})
 
 	metricSearchRunning = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "zoekt_search_running",
@@ -174,6 +179,16 @@ type rankedShard struct {
 	repos []*zoekt.Repository
 }
 
+// shardSnapshot is a set of loaded shards. Each call to replace publishes a
+// new snapshot with the next generation, and each search uses one snapshot
+// throughout, so it never sees a partial replacement.
+type shardSnapshot struct {
+	generation uint64
+
+	// shards are sorted by decreasing priority.
+	shards []*rankedShard
+}
+
 type shardedSearcher struct {
 	// Limit the number of parallel queries. Since searching is
 	// CPU bound, we can't do better than #CPU queries in
@@ -181,26 +196,76 @@ type shardedSearcher struct {
 	// pressure.
 	sched scheduler
 
-	mu     sync.Mutex // protects writes to shards
-	shards map[string]*rankedShard
+	mu         sync.Mutex // protects writes to shards and generation
+	shards     map[string]*rankedShard
+	generation uint64
+
+	// snapshot holds the current *shardSnapshot.
+	snapshot atomic.Value
 
-	ranked atomic.Value
+	// cache holds the results of recent searches, if not nil.
+	cache *resultCache
 }
 
 func newShardedSearcher(n int64) *shardedSearcher {
 	ss := &shardedSearcher{
 		shards: make(map[string]*rankedShard),
-		sched:  newScheduler(n),
+		sched:  newScheduler(n, SchedulerOptions{}),
 	}
 	return ss
 }
 
+// Options configures the searchers returned by
+// NewDirectorySearcherWithOptions.
+type Options struct {
+	// CacheEntries is the number of search results to keep in memory.
+	// Repeated searches with the same query and SearchOptions return the
+	// cached result until the loaded shards change, see
+	// SearchOptions.NoCache. If 0, results aren't cached.
+	CacheEntries int
+
+	// CacheBytes limits the estimated size of the cached results. If 0,
+	// only CacheEntries limits the cache.
+	CacheBytes int
+
+	// If set, searches and lists only see the repositories that
+	// Authorizer allows for the identity of their context, see
+	// authz.WithIdentity.
+	Authorizer authz.Authorizer
+
+	// Scheduler configures how clients share the search capacity.
+	Scheduler SchedulerOptions
+
+	// MaxResidentBytes limits the estimated memory of the open shards,
+	// including their mapped files. The least recently searched shards
+	// are closed to stay within the limit, keeping only their repository
+	// metadata, and opened again when searched. If 0, all shards stay
+	// open.
+	MaxResidentBytes int64
+
+	// VerifyChecksums reads each shard completely when loading it, to
+	// verify its section checksums. Shards that don't match aren't loaded.
+	// This makes loading as expensive as reading the whole index.
+	VerifyChecksums bool
+}
+
 // NewDirectorySearcher returns a searcher instance that loads all
 // shards corresponding to a glob into memory.
 func NewDirectorySearcher(dir string) (zoekt.Streamer, error) {
-	ss := newShardedSearcher(int64(runtime.GOMAXPROCS(0)))
+	return NewDirectorySearcherWithOptions(dir, Options{})
+}
+
+// NewDirectorySearcherWithOptions is like NewDirectorySearcher, configured
+// by opts.
+func NewDirectorySearcherWithOptions(dir string, opts Options) (zoekt.Streamer, error) {
+	n := int64(runtime.GOMAXPROCS(0))
+	ss := newShardedSearcher(n)
+	ss.sched = newScheduler(n, opts.Scheduler)
+	ss.cache = newResultCache(opts.CacheEntries, opts.CacheBytes)
 	tl := &loader{
-		ss: ss,
+		ss:              ss,
+		residency:       newResidencyManager(opts.MaxResidentBytes),
+		verifyChecksums: opts.VerifyChecksums,
 	}
 	dw, err := NewDirectoryWatcher(dir, tl)
 	if err != nil {
@@ -212,7 +277,11 @@ func NewDirectorySearcher(dir string) (zoekt.Streamer, error) {
 		directoryWatcher: dw,
 	}
 
-	return &typeRepoSearcher{Streamer: ds}, nil
+	var s zoekt.Streamer = &typeRepoSearcher{Streamer: ds}
+	if opts.Authorizer != nil {
+		s = authz.NewSearcher(s, opts.Authorizer)
+	}
+	return s, nil
 }
 
 type directorySearcher struct {
@@ -230,17 +299,29 @@ func (s *directorySearcher) Close() {
 
 type loader struct {
 	ss *shardedSearcher
+
+	// If set, shards are loaded as lazyShards within its budget.
+	residency *residencyManager
+
+	// verifyChecksums is passed to loadShard.
+	verifyChecksums bool
 }
 
-func (tl *loader) load(keys ...string) {
+func (tl *loader) load(keys, dropped []string) {
 	var (
 		mu     sync.Mutex     // synchronizes writes to the shards map
 		wg     sync.WaitGroup // used to wait for all shards to load
 		sem    = semaphore.NewWeighted(int64(runtime.GOMAXPROCS(0)))
-		shards = make(map[string]zoekt.Searcher, len(keys))
+		shards = make(map[string]zoekt.Searcher, len(keys)+len(dropped))
 	)
 
-	log.Printf("loading %d shard(s): %s", len(keys), humanTruncateList(keys, 5))
+	for _, key := range dropped {
+		shards[key] = nil
+	}
+
+	if len(keys) > 0 {
+		log.Printf("loading %d shard(s): %s", len(keys), humanTruncateList(keys, 5))
+	}
 
 	lastProgress := time.Now()
 	for i, key := range keys {
@@ -257,7 +338,7 @@ func (tl *loader) load(keys ...string) {
 			defer sem.Release(1)
 			defer wg.Done()
 
-			shard, err := loadShard(key)
+			shard, err := tl.loadShard(key)
 			if err != nil {
 				metricShardsLoadFailedTotal.Inc()
 				log.Printf("reloading: %s, err %v ", key, err)
@@ -273,15 +354,15 @@ func (tl *loader) load(keys ...string) {
 
 	wg.Wait()
 
+	// Replace the shards at once, so searches see all of them or none.
 	tl.ss.replace(shards)
 }
 
-func (tl *loader) drop(keys ...string) {
-	shards := make(map[string]zoekt.Searcher, len(keys))
-	for _, key := range keys {
-		shards[key] = nil
+func (tl *loader) loadShard(key string) (zoekt.Searcher, error) {
+	if tl.residency != nil {
+		return tl.residency.load(key, tl.verifyChecksums)
 	}
-	tl.ss.replace(shards)
+	return loadShard(key, tl.verifyChecksums)
 }
 
 func (ss *shardedSearcher) String() string {
@@ -423,6 +504,36 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 		}
 		tr.Finish()
 	}()
+	order, err := zoekt.ParseSortOrder(string(opts.SortBy))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := parseCursor(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil && cursor.Order != order {
+		return nil, fmt.Errorf("cursor is for sort order %q, not %q", cursor.Order, order)
+	}
+
+	var cacheKey string
+	cacheable := false
+	if ss.cache != nil {
+		cacheKey, cacheable = resultCacheKey(q, opts)
+	}
+	if cacheable {
+		start := time.Now()
+		if cached := ss.cache.get(ss.getSnapshot().generation, cacheKey); cached != nil {
+			tr.LazyPrintf("cache hit")
+			cached.Wait = 0
+			cached.Duration = time.Since(start)
+			return cached, nil
+		}
+	}
+
+	// reqCtx is only done if the request is, unlike ctx which is also
+	// canceled once TotalMaxMatchCount is reached.
+	reqCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -432,7 +543,7 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	}
 
 	start := time.Now()
-	proc, err := ss.sched.Acquire(ctx)
+	proc, err := ss.sched.Acquire(ctx, opts.Client)
 	if err != nil {
 		return nil, err
 	}
@@ -441,8 +552,19 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	aggregate.Wait = time.Since(start)
 	start = time.Now()
 
-	done, err := ss.streamSearch(ctx, proc, q, opts, stream.SenderFunc(func(r *zoekt.SearchResult) {
+	// Take the snapshot once acquired, so we don't search shards that were
+	// replaced while we waited.
+	snap := ss.getSnapshot()
+	done, err := ss.streamSearch(ctx, proc, snap.shards, q, opts, stream.SenderFunc(func(r *zoekt.SearchResult) {
 		aggregate.Stats.Add(r.Stats)
+		aggregate.Explanations = append(aggregate.Explanations, r.Explanations...)
+		if r.Facets != nil {
+			if aggregate.Facets == nil {
+				aggregate.Facets = &zoekt.Facets{}
+			}
+			aggregate.Facets.Add(r.Facets)
+		}
+		zoekt.AddCaptures(&aggregate.Captures, r.Captures)
 
 		if len(r.Files) > 0 {
 			aggregate.Files = append(aggregate.Files, r.Files...)
@@ -453,6 +575,12 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 			for k, v := range r.LineFragments {
 				aggregate.LineFragments[k] = v
 			}
+			for k, v := range r.CommitURLs {
+				if aggregate.CommitURLs == nil {
+					aggregate.CommitURLs = map[string]string{}
+				}
+				aggregate.CommitURLs[k] = v
+			}
 		}
 
 		if cancel != nil && opts.TotalMaxMatchCount > 0 && aggregate.Stats.MatchCount > opts.TotalMaxMatchCount {
@@ -465,13 +593,33 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 		return nil, err
 	}
 
-	zoekt.SortFilesByScore(aggregate.Files)
+	var dates map[string]time.Time
+	if order == zoekt.SortByCommitDate {
+		dates = latestCommitDates(snap.shards)
+	}
+	zoekt.SortFiles(aggregate.Files, order, dates)
+	if opts.CollapseDuplicates {
+		aggregate.Files = zoekt.CollapseDuplicates(aggregate.Files)
+	}
+	if cursor != nil {
+		aggregate.Files = cursor.Filter(aggregate.Files)
+	}
 	if max := opts.MaxDocDisplayCount; max > 0 && len(aggregate.Files) > max {
 		aggregate.Files = aggregate.Files[:max]
+		if order != zoekt.SortByCommitDate {
+			aggregate.NextCursor = zoekt.NewCursor(&aggregate.Files[max-1], order).String()
+		}
 	}
 	copyFiles(aggregate)
 
 	aggregate.Duration = time.Since(start)
+
+	// Don't cache results that are incomplete because of the request.
+	timedOut := opts.MaxWallTime > 0 && aggregate.Duration >= opts.MaxWallTime
+	if cacheable && aggregate.Crashes == 0 && !timedOut && reqCtx.Err() == nil {
+		ss.cache.add(snap.generation, cacheKey, aggregate)
+		tr.LazyPrintf("cached result")
+	}
 	return aggregate, nil
 }
 
@@ -485,8 +633,28 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 		tr.Finish()
 	}()
 
+	if order, err := zoekt.ParseSortOrder(string(opts.SortBy)); err != nil {
+		return err
+	} else if order != zoekt.SortByScore {
+		// Batches can only be sorted by score as they arrive.
+		sr, err := ss.Search(ctx, q, opts)
+		if err != nil {
+			return err
+		}
+		sender.Send(sr)
+		return nil
+	}
+
+	cursor, err := parseCursor(opts)
+	if err != nil {
+		return err
+	}
+	if cursor != nil && cursor.Order != zoekt.SortByScore {
+		return fmt.Errorf("cursor is for sort order %q, not %q", cursor.Order, zoekt.SortByScore)
+	}
+
 	start := time.Now()
-	proc, err := ss.sched.Acquire(ctx)
+	proc, err := ss.sched.Acquire(ctx, opts.Client)
 	if err != nil {
 		return err
 	}
@@ -498,7 +666,10 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 		},
 	})
 
-	done, err := ss.streamSearch(ctx, proc, q, opts, stream.SenderFunc(func(event *zoekt.SearchResult) {
+	done, err := ss.streamSearch(ctx, proc, ss.getShards(), q, opts, stream.SenderFunc(func(event *zoekt.SearchResult) {
+		if cursor != nil {
+			event.Files = cursor.Filter(event.Files)
+		}
 		copyFiles(event)
 		sender.Send(event)
 	}))
@@ -506,14 +677,37 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	return err
 }
 
+// latestCommitDates returns the LatestCommitDate of the repositories in
+// shards by name.
+func latestCommitDates(shards []*rankedShard) map[string]time.Time {
+	dates := map[string]time.Time{}
+	for _, s := range shards {
+		for _, r := range s.repos {
+			if r.LatestCommitDate.After(dates[r.Name]) {
+				dates[r.Name] = r.LatestCommitDate
+			}
+		}
+	}
+	return dates
+}
+
+// parseCursor returns the cursor of opts, or nil if it has none.
+func parseCursor(opts *zoekt.SearchOptions) (*zoekt.Cursor, error) {
+	if opts.Cursor == "" {
+		return nil, nil
+	}
+	return zoekt.ParseCursor(opts.Cursor)
+}
+
 // streamSearch is an internal helper since both Search and StreamSearch are largely similiar.
+// It searches shards, which must be the shards of a single snapshot.
 //
 // done must always be called, even if err is non-nil. The SearchResults sent
 // via sender contain references to the underlying mmap data that the garbage
 // collector can't see. Calling done informs the garbage collector it is free
 // to collect those shards. The caller must call copyFiles on any
 // SearchResults it returns/streams out before calling done.
-func (ss *shardedSearcher) streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) (done func(), err error) {
+func (ss *shardedSearcher) streamSearch(ctx context.Context, proc *process, shards []*rankedShard, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) (done func(), err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.streamSearch", "")
 	tr.LazyLog(q, true)
 	tr.LazyPrintf("opts: %+v", opts)
@@ -531,7 +725,6 @@ func (ss *shardedSearcher) streamSearch(ctx context.Context, proc *process, q qu
 		tr.Finish()
 	}()
 
-	shards := ss.getShards()
 	tr.LazyPrintf("before selectRepoSet shards:%d", len(shards))
 	shards, q = selectRepoSet(shards, q)
 	tr.LazyPrintf("after selectRepoSet shards:%d %s", len(shards), q)
@@ -672,6 +865,10 @@ func sendByRepository(result *zoekt.SearchResult, sender zoekt.Sender) {
 
 	send := func(repoName string, a, b int) {
 		zoekt.SortFilesByScore(result.Files[a:b])
+		var commitURLs map[string]string
+		if u, ok := result.CommitURLs[repoName]; ok {
+			commitURLs = map[string]string{repoName: u}
+		}
 		sender.Send(&zoekt.SearchResult{
 			// No stats. Stats must be aggregateable, hence we sent them separately.
 			Progress: zoekt.Progress{
@@ -681,6 +878,7 @@ func sendByRepository(result *zoekt.SearchResult, sender zoekt.Sender) {
 			Files:         result.Files[a:b],
 			RepoURLs:      map[string]string{repoName: result.RepoURLs[repoName]},
 			LineFragments: map[string]string{repoName: result.LineFragments[repoName]},
+			CommitURLs:    commitURLs,
 		})
 	}
 
@@ -699,7 +897,7 @@ func sendByRepository(result *zoekt.SearchResult, sender zoekt.Sender) {
 		}
 	}
 	send(curRepoName, startIndex, endIndex+1)
-	sender.Send(&zoekt.SearchResult{Stats: result.Stats})
+	sender.Send(&zoekt.SearchResult{Stats: result.Stats, Explanations: result.Explanations, Facets: result.Facets, Captures: result.Captures})
 }
 
 func observeMetrics(sr *zoekt.SearchResult) {
@@ -803,7 +1001,7 @@ func (ss *shardedSearcher) List(ctx context.Context, r query.Q, opts *zoekt.List
 		isAll = c.Value
 	}
 
-	proc, err := ss.sched.Acquire(ctx)
+	proc, err := ss.sched.Acquire(ctx, "")
 	if err != nil {
 		return nil, err
 	}
@@ -894,8 +1092,16 @@ func reportListAllMetrics(repos []*zoekt.RepoListEntry) {
 // getShards returns the currently loaded shards. The shards are sorted by decreasing
 // rank and should not be mutated.
 func (s *shardedSearcher) getShards() []*rankedShard {
-	ranked, _ := s.ranked.Load().([]*rankedShard)
-	return ranked
+	return s.getSnapshot().shards
+}
+
+// getSnapshot returns the current snapshot of the loaded shards.
+func (s *shardedSearcher) getSnapshot() *shardSnapshot {
+	snap, _ := s.snapshot.Load().(*shardSnapshot)
+	if snap == nil {
+		return &shardSnapshot{}
+	}
+	return snap
 }
 
 func mkRankedShard(s zoekt.Searcher) *rankedShard {
@@ -997,12 +1203,19 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 		return ranked[i].repos[0].Name < ranked[j].repos[0].Name
 	})
 
-	s.ranked.Store(ranked)
+	s.generation++
+	s.snapshot.Store(&shardSnapshot{generation: s.generation, shards: ranked})
+	if s.cache != nil {
+		// Free the results of older snapshots.
+		s.cache.clear(s.generation)
+	}
 
 	metricShardsLoaded.Set(float64(len(ranked)))
 }
 
-func loadShard(fn string) (zoekt.Searcher, error) {
+// loadShard opens the shard at fn. If verifyChecksums is set, it first reads
+// the whole file to verify its section checksums.
+func loadShard(fn string, verifyChecksums bool) (zoekt.Searcher, error) {
 	f, err := os.Open(fn)
 	if err != nil {
 		return nil, err
@@ -1012,6 +1225,13 @@ func loadShard(fn string) (zoekt.Searcher, error) {
 	if err != nil {
 		return nil, err
 	}
+	if verifyChecksums {
+		if err := zoekt.VerifyChecksums(iFile); err != nil {
+			iFile.Close()
+			metricShardsChecksumFailedTotal.Inc()
+			return nil, fmt.Errorf("VerifyChecksums(%s): %v", fn, err)
+		}
+	}
 	s, err := zoekt.NewSearcher(iFile)
 	if err != nil {
 		iFile.Close()
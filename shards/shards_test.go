@@ -21,11 +21,14 @@ import (
 	"hash/fnv"
 	"log"
 	"math"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"testing/quick"
 	"time"
@@ -62,7 +65,7 @@ func TestCrashResilience(t *testing.T) {
 	log.SetOutput(out)
 	defer log.SetOutput(os.Stderr)
 	ss := newShardedSearcher(2)
-	ss.ranked.Store([]*rankedShard{{Searcher: &crashSearcher{}}})
+	ss.snapshot.Store(&shardSnapshot{shards: []*rankedShard{{Searcher: &crashSearcher{}}}})
 
 	q := &query.Substring{Pattern: "hoi"}
 	opts := &zoekt.SearchOptions{}
@@ -478,6 +481,322 @@ func TestShardedSearcher_List(t *testing.T) {
 	}
 }
 
+func TestShardedSearcher_Explain(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{Name: "repo-a"}, zoekt.Document{Name: "a.go", Content: []byte("needle")})),
+		"2": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{Name: "repo-b"}, zoekt.Document{Name: "b.go", Content: []byte("haystack")})),
+	})
+
+	q := &query.Substring{Pattern: "needle"}
+	res, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{Explain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(res.Files))
+	}
+	if len(res.Explanations) != 2 {
+		t.Fatalf("got %d explanations, want one per shard: %+v", len(res.Explanations), res.Explanations)
+	}
+
+	skipped := 0
+	for _, e := range res.Explanations {
+		if e.Skipped != "" {
+			skipped++
+		}
+	}
+	if skipped != 1 {
+		t.Errorf("got %d skipped shards, want 1: %+v", skipped, res.Explanations)
+	}
+}
+
+func TestShardedSearcher_CollapseDuplicates(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 1, Name: "upstream"}, zoekt.Document{Name: "lib.go", Content: []byte("needle 1")})),
+		"2": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 2, Name: "fork"}, zoekt.Document{Name: "vendor/lib.go", Content: []byte("needle 1")})),
+		"3": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 3, Name: "other"}, zoekt.Document{Name: "lib.go", Content: []byte("needle 2")})),
+	})
+
+	q := &query.Substring{Pattern: "needle"}
+	res, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 3 {
+		t.Fatalf("got %d files without collapsing, want 3", len(res.Files))
+	}
+
+	res, err = ss.Search(context.Background(), q, &zoekt.SearchOptions{CollapseDuplicates: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 2 {
+		t.Fatalf("got %d files, want 2", len(res.Files))
+	}
+
+	// Each group of files with the same content, sorted.
+	var got [][]string
+	for _, f := range res.Files {
+		group := []string{f.Repository + ":" + f.FileName}
+		for _, d := range f.Duplicates {
+			group = append(group, d.Repository+":"+d.FileName)
+		}
+		sort.Strings(group)
+		got = append(got, group)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+	want := [][]string{{"fork:vendor/lib.go", "upstream:lib.go"}, {"other:lib.go"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestShardedSearcher_Cursor(t *testing.T) {
+	ss := newShardedSearcher(2)
+	shards := map[string]zoekt.Searcher{}
+	for i := 0; i < 3; i++ {
+		repo := &zoekt.Repository{ID: uint32(i + 1), Name: fmt.Sprintf("repo-%d", i)}
+		var docs []zoekt.Document
+		for j := 0; j < 3; j++ {
+			docs = append(docs, zoekt.Document{
+				Name:    fmt.Sprintf("f%d", j),
+				Content: []byte(strings.Repeat("needle ", j+1)),
+			})
+		}
+		shards[repo.Name] = searcherForTest(t, testIndexBuilder(t, repo, docs...))
+	}
+	ss.replace(shards)
+
+	ctx := context.Background()
+	q := &query.Substring{Pattern: "needle"}
+	all, err := ss.Search(ctx, q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Files) != 9 || all.NextCursor != "" {
+		t.Fatalf("got %d files, cursor %q, want 9 files and no cursor", len(all.Files), all.NextCursor)
+	}
+
+	name := func(f zoekt.FileMatch) string { return f.Repository + "/" + f.FileName }
+	var want []string
+	for _, f := range all.Files {
+		want = append(want, name(f))
+	}
+
+	var got []string
+	opts := &zoekt.SearchOptions{MaxDocDisplayCount: 4}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		res, err := ss.Search(ctx, q, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range res.Files {
+			got = append(got, name(f))
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		opts.Cursor = res.NextCursor
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pages: got %v, want %v", got, want)
+	}
+
+	// Streamed results skip the same files.
+	opts = &zoekt.SearchOptions{Cursor: zoekt.NewCursor(&all.Files[4], zoekt.SortByScore).String()}
+	var streamed []string
+	err = ss.StreamSearch(ctx, q, opts, stream.SenderFunc(func(sr *zoekt.SearchResult) {
+		for _, f := range sr.Files {
+			streamed = append(streamed, name(f))
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(streamed)
+	wantStreamed := append([]string{}, want[5:]...)
+	sort.Strings(wantStreamed)
+	if !reflect.DeepEqual(streamed, wantStreamed) {
+		t.Errorf("streamed: got %v, want %v", streamed, wantStreamed)
+	}
+
+	if _, err := ss.Search(ctx, q, &zoekt.SearchOptions{Cursor: "bogus!"}); err == nil {
+		t.Error("Search succeeded with an invalid cursor")
+	}
+}
+
+func TestShardedSearcher_Facets(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 1, Name: "repo-a"},
+			zoekt.Document{Name: "a/f1.go", Content: []byte("needle")},
+			zoekt.Document{Name: "b/f2.go", Content: []byte("needle")})),
+		"2": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 2, Name: "repo-b"},
+			zoekt.Document{Name: "a/f3.go", Content: []byte("needle")})),
+	})
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{Facets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Facets == nil {
+		t.Fatal("no facets")
+	}
+	if want := map[string]int{"repo-a": 2, "repo-b": 1}; !reflect.DeepEqual(res.Facets.Repositories, want) {
+		t.Errorf("got repositories %v, want %v", res.Facets.Repositories, want)
+	}
+	if want := map[string]int{"a": 2, "b": 1}; !reflect.DeepEqual(res.Facets.Directories, want) {
+		t.Errorf("got directories %v, want %v", res.Facets.Directories, want)
+	}
+}
+
+func TestShardedSearcher_SortBy(t *testing.T) {
+	now := time.Now()
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 1, Name: "old", LatestCommitDate: now.Add(-time.Hour)},
+			zoekt.Document{Name: "a", Content: []byte("needle")},
+			zoekt.Document{Name: "c", Content: []byte("needle needle")})),
+		"2": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 2, Name: "new", LatestCommitDate: now},
+			zoekt.Document{Name: "b", Content: []byte("needle")})),
+	})
+
+	ctx := context.Background()
+	q := &query.Substring{Pattern: "needle"}
+	names := func(files []zoekt.FileMatch) []string {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Repository+"/"+f.FileName)
+		}
+		return names
+	}
+
+	for order, want := range map[zoekt.SortOrder][]string{
+		zoekt.SortByRepository: {"new/b", "old/a", "old/c"},
+		zoekt.SortByPath:       {"old/a", "new/b", "old/c"},
+		zoekt.SortByCommitDate: {"new/b", "old/a", "old/c"},
+	} {
+		res, err := ss.Search(ctx, q, &zoekt.SearchOptions{SortBy: order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(res.Files); !reflect.DeepEqual(got, want) {
+			t.Errorf("Search %s: got %v, want %v", order, got, want)
+		}
+
+		var streamed []string
+		err = ss.StreamSearch(ctx, q, &zoekt.SearchOptions{SortBy: order}, stream.SenderFunc(func(sr *zoekt.SearchResult) {
+			streamed = append(streamed, names(sr.Files)...)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(streamed, want) {
+			t.Errorf("StreamSearch %s: got %v, want %v", order, streamed, want)
+		}
+	}
+
+	// Pages by path.
+	first, err := ss.Search(ctx, q, &zoekt.SearchOptions{SortBy: zoekt.SortByPath, MaxDocDisplayCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ss.Search(ctx, q, &zoekt.SearchOptions{SortBy: zoekt.SortByPath, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(res.Files), []string{"old/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second page: got %v, want %v", got, want)
+	}
+
+	// A cursor is only valid for its order.
+	if _, err := ss.Search(ctx, q, &zoekt.SearchOptions{Cursor: first.NextCursor}); err == nil {
+		t.Error("Search accepted a cursor for another order")
+	}
+	if _, err := ss.Search(ctx, q, &zoekt.SearchOptions{SortBy: "size"}); err == nil {
+		t.Error("Search accepted an unknown sort order")
+	}
+}
+
+func TestShardedSearcher_StreamSortBy(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 1, Name: "a"},
+			zoekt.Document{Name: "z", Content: []byte("needle needle needle")},
+			zoekt.Document{Name: "x", Content: []byte("needle")})),
+		"2": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{ID: 2, Name: "b"},
+			zoekt.Document{Name: "y", Content: []byte("needle needle")})),
+	})
+
+	s := httptest.NewServer(stream.Server(ss))
+	defer s.Close()
+	cl := stream.NewClient(s.URL, nil)
+
+	var events int
+	var got []string
+	err := cl.StreamSearch(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{SortBy: zoekt.SortByPath}, stream.SenderFunc(func(sr *zoekt.SearchResult) {
+		if len(sr.Files) == 0 {
+			return
+		}
+		events++
+		for _, f := range sr.Files {
+			got = append(got, f.Repository+"/"+f.FileName)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The files arrive in path order, all in one event.
+	if want := []string{"a/x", "b/y", "a/z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if events != 1 {
+		t.Errorf("got %d events with files, want 1", events)
+	}
+}
+
+func TestLoadShardChecksum(t *testing.T) {
+	b := testIndexBuilder(t, &zoekt.Repository{Name: "repo"}, zoekt.Document{Name: "f", Content: []byte("needle in a haystack")})
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.zoekt")
+	if err := os.WriteFile(good, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadShard(good, true)
+	if err != nil {
+		t.Fatalf("loadShard(good): %v", err)
+	}
+	s.Close()
+
+	data[bytes.Index(data, []byte("haystack"))] = 'H'
+	bad := filepath.Join(dir, "bad.zoekt")
+	if err := os.WriteFile(bad, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadShard(bad, true); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("loadShard(bad): got %v, want checksum mismatch", err)
+	}
+
+	// Without verification, the shard loads without reading it completely.
+	s, err = loadShard(bad, false)
+	if err != nil {
+		t.Fatalf("loadShard(bad) without verification: %v", err)
+	}
+	s.Close()
+}
+
 func testIndexBuilder(t testing.TB, repo *zoekt.Repository, docs ...zoekt.Document) *zoekt.IndexBuilder {
 	b, err := zoekt.NewIndexBuilder(repo)
 	if err != nil {
@@ -987,3 +1306,33 @@ func testShardedSearch(t *testing.T, q query.Q, ib *zoekt.IndexBuilder) []zoekt.
 	sres, _ := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
 	return sres.Files
 }
+
+func TestLoaderReplacesAtOnce(t *testing.T) {
+	paths := writeShards(t, "repo-a", "repo-b", "repo-c")
+	ss := newShardedSearcher(2)
+	defer ss.Close()
+	tl := &loader{ss: ss}
+
+	repos := func() []string {
+		var names []string
+		for _, s := range ss.getShards() {
+			names = append(names, s.repos[0].Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	tl.load(paths[:2], nil)
+	if got := ss.getSnapshot().generation; got != 1 {
+		t.Errorf("got generation %d, want 1", got)
+	}
+
+	// Dropping and loading shards publishes one snapshot.
+	tl.load(paths[2:], paths[:1])
+	if got := ss.getSnapshot().generation; got != 2 {
+		t.Errorf("got generation %d, want 2", got)
+	}
+	if diff := cmp.Diff([]string{"repo-b", "repo-c"}, repos()); diff != "" {
+		t.Errorf("loaded repos (-want +got):\n%s", diff)
+	}
+}
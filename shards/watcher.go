@@ -30,9 +30,9 @@ import (
 )
 
 type shardLoader interface {
-	// Load a new file.
-	load(filenames ...string)
-	drop(filenames ...string)
+	// load loads the new or changed files and unloads the dropped ones.
+	// Searches see either none or all of the changes.
+	load(filenames, dropped []string)
 }
 
 type DirectoryWatcher struct {
@@ -126,22 +126,15 @@ func (s *DirectoryWatcher) scan() error {
 			continue
 		}
 
-		fi, err := os.Lstat(fn)
+		mtime, err := zoekt.ShardModTime(fn)
 		if err != nil {
 			continue
 		}
-
-		ts[fn] = fi.ModTime()
-
-		fiMeta, err := os.Lstat(fn + ".meta")
-		if err != nil {
-			continue
-		}
-		if fiMeta.ModTime().After(fi.ModTime()) {
-			ts[fn] = fiMeta.ModTime()
-		}
+		ts[fn] = mtime
 	}
 
+	s.holdIncomplete(ts, latest)
+
 	var toLoad []string
 	for k, mtime := range ts {
 		if t, ok := s.timestamps[k]; !ok || t != mtime {
@@ -163,17 +156,106 @@ func (s *DirectoryWatcher) scan() error {
 		log.Printf("unloading %d shard(s): %s", len(toDrop), humanTruncateList(toDrop, 5))
 	}
 
-	s.loader.drop(toDrop...)
-
-	if len(toLoad) == 0 {
+	if len(toLoad) == 0 && len(toDrop) == 0 {
 		return nil
 	}
 
-	s.loader.load(toLoad...)
+	s.loader.load(toLoad, toDrop)
 
 	return nil
 }
 
+// holdIncomplete replaces the shards in ts of each repository whose shards
+// don't match its zoekt.ShardSet, ie. that are being written, by the shards
+// loaded before, if any. So searches see either the old or the new shards of
+// a repository, never a mix, and a new repository once all its shards are in
+// place. Repositories without a shard set, like compound shards, are left as
+// they are, and so are repositories whose shard set is older than their
+// shards, which were written by something that doesn't update it.
+func (s *DirectoryWatcher) holdIncomplete(ts map[string]time.Time, latest map[string]int) {
+	repos := map[string]map[string]time.Time{}
+	for fn, mtime := range ts {
+		name, _ := versionFromPath(fn)
+		if repos[name] == nil {
+			repos[name] = map[string]time.Time{}
+		}
+		repos[name][fn] = mtime
+	}
+
+	loaded := map[string][]string{}
+	for fn := range s.timestamps {
+		name, _ := versionFromPath(fn)
+		if _, ok := repos[name]; ok {
+			loaded[name] = append(loaded[name], fn)
+		}
+	}
+
+	for name, shards := range repos {
+		if !s.changed(shards, len(loaded[name])) {
+			continue
+		}
+		setPath := fmt.Sprintf("%s_v%d.shards", name, latest[name])
+		set, err := zoekt.ReadShardSet(setPath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Printf("ignoring shard set: %v", err)
+			continue
+		}
+		if shardSetStale(setPath, shards) {
+			log.Printf("ignoring shard set %s, which is older than its shards", setPath)
+			continue
+		}
+		paths := make([]string, 0, len(shards))
+		for fn := range shards {
+			paths = append(paths, fn)
+		}
+		if set.Complete(paths) {
+			continue
+		}
+
+		log.Printf("waiting for all shards of %s", filepath.Base(name))
+		for fn := range shards {
+			delete(ts, fn)
+		}
+		for _, fn := range loaded[name] {
+			ts[fn] = s.timestamps[fn]
+		}
+	}
+}
+
+// shardSetStale returns whether the shard set at setPath is older than one of
+// shards. Builders write the set after their shards and before renaming them
+// into place, so such a set describes an earlier build. The .meta files are
+// ignored, as they may be updated after the build. Files that can't be
+// read are left to ShardSet.Complete.
+func shardSetStale(setPath string, shards map[string]time.Time) bool {
+	fi, err := os.Stat(setPath)
+	if err != nil {
+		return false
+	}
+	for fn := range shards {
+		if shardFi, err := os.Lstat(fn); err == nil && shardFi.ModTime().After(fi.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// changed returns whether shards differ from the n loaded shards of their
+// repository.
+func (s *DirectoryWatcher) changed(shards map[string]time.Time, n int) bool {
+	if len(shards) != n {
+		return true
+	}
+	for fn, mtime := range shards {
+		if t, ok := s.timestamps[fn]; !ok || t != mtime {
+			return true
+		}
+	}
+	return false
+}
+
 func humanTruncateList(paths []string, max int) string {
 	sort.Strings(paths)
 	var b strings.Builder
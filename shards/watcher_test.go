@@ -18,9 +18,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/zoekt"
 )
 
@@ -29,14 +31,11 @@ type loggingLoader struct {
 	drops chan string
 }
 
-func (l *loggingLoader) load(keys ...string) {
+func (l *loggingLoader) load(keys, dropped []string) {
 	for _, key := range keys {
 		l.loads <- key
 	}
-}
-
-func (l *loggingLoader) drop(keys ...string) {
-	for _, key := range keys {
+	for _, key := range dropped {
 		l.drops <- key
 	}
 }
@@ -236,3 +235,129 @@ func TestHumanTruncateList(t *testing.T) {
 	assert(4, "1, 2, 3, 4")
 	assert(5, "1, 2, 3, 4")
 }
+
+func TestDirWatcherWaitsForShardSet(t *testing.T) {
+	dir := t.TempDir()
+	logger := &loggingLoader{
+		loads: make(chan string, 10),
+		drops: make(chan string, 10),
+	}
+	sw := &DirectoryWatcher{
+		dir:        dir,
+		timestamps: map[string]time.Time{},
+		loader:     logger,
+	}
+
+	shard := func(n int) string {
+		return filepath.Join(dir, fmt.Sprintf("foo_v%d.%05d.zoekt", zoekt.IndexFormatVersion, n))
+	}
+	setPath := filepath.Join(dir, fmt.Sprintf("foo_v%d.shards", zoekt.IndexFormatVersion))
+	write := func(path string, mtime int64) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Unix(mtime, 0), time.Unix(mtime, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSet := func(shards ...string) {
+		t.Helper()
+		set := zoekt.ShardSet{Shards: map[string]zoekt.ShardState{}}
+		for _, shard := range shards {
+			fi, err := os.Lstat(shard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			set.Shards[filepath.Base(shard)] = zoekt.ShardState{ModTime: fi.ModTime()}
+		}
+		if err := zoekt.WriteShardSet(setPath, &set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan := func() (loads, drops []string) {
+		t.Helper()
+		if err := sw.scan(); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			select {
+			case k := <-logger.loads:
+				loads = append(loads, filepath.Base(k))
+			case k := <-logger.drops:
+				drops = append(drops, filepath.Base(k))
+			default:
+				sort.Strings(loads)
+				return loads, drops
+			}
+		}
+	}
+
+	// The first build of a repository renames its shards into place one
+	// at a time, after writing the shard set. None of them is loaded
+	// until all are in place.
+	write(shard(0), 1)
+	write(shard(1), 1)
+	writeSet(shard(0), shard(1))
+	if err := os.Remove(shard(1)); err != nil {
+		t.Fatal(err)
+	}
+	if loads, drops := scan(); len(loads)+len(drops) != 0 {
+		t.Fatalf("got loads %v and drops %v before all shards were written", loads, drops)
+	}
+	write(shard(1), 1)
+	if loads, _ := scan(); len(loads) != 2 {
+		t.Fatalf("got loads %v, want both shards", loads)
+	}
+
+	// The next build leaves a single shard.
+	if err := os.Remove(shard(1)); err != nil {
+		t.Fatal(err)
+	}
+	writeSet(shard(0))
+	if _, drops := scan(); len(drops) != 1 {
+		t.Fatalf("got drops %v, want the second shard", drops)
+	}
+
+	// A build replaces the first shard and adds a second one. Until the
+	// shard set lists them, the old shard stays loaded.
+	write(shard(0), 2)
+	write(shard(1), 2)
+	if loads, drops := scan(); len(loads)+len(drops) != 0 {
+		t.Fatalf("got loads %v and drops %v before the shard set was written", loads, drops)
+	}
+
+	writeSet(shard(0), shard(1))
+	loads, drops := scan()
+	want := []string{filepath.Base(shard(0)), filepath.Base(shard(1))}
+	if diff := cmp.Diff(want, loads); diff != "" || len(drops) != 0 {
+		t.Fatalf("got drops %v and loads (-want +got):\n%s", drops, diff)
+	}
+
+	// Removing the second shard waits for the shard set too.
+	if err := os.Remove(shard(1)); err != nil {
+		t.Fatal(err)
+	}
+	if loads, drops := scan(); len(loads)+len(drops) != 0 {
+		t.Fatalf("got loads %v and drops %v before the shard set was written", loads, drops)
+	}
+	writeSet(shard(0))
+	if _, drops := scan(); len(drops) != 1 {
+		t.Fatalf("got drops %v, want the second shard", drops)
+	}
+
+	// Updating the .meta file after the build doesn't change the shard set.
+	write(shard(0)+".meta", 3)
+	if loads, _ := scan(); len(loads) != 1 {
+		t.Fatalf("got loads %v, want the first shard", loads)
+	}
+
+	// Shards newer than the shard set were written by something that
+	// doesn't update it, so the set is ignored.
+	later := time.Now().Add(time.Hour).Unix()
+	write(shard(0), later)
+	write(shard(1), later)
+	if loads, _ := scan(); len(loads) != 2 {
+		t.Fatalf("got loads %v, want both shards", loads)
+	}
+}
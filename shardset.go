@@ -0,0 +1,144 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ShardSet lists the shards of a repository as a build leaves them. Builders
+// write it before they rename their shards into place, so that readers can
+// tell a complete set of shards from one that is still being replaced.
+type ShardSet struct {
+	// Shards maps the base name of each shard to its state.
+	Shards map[string]ShardState
+}
+
+// ShardState is the state of a shard in a ShardSet.
+type ShardState struct {
+	// ModTime is the modification time of the shard.
+	ModTime time.Time
+
+	// MetaModTime is the modification time of the shard's .meta file, or
+	// zero if the build leaves none.
+	MetaModTime time.Time
+}
+
+// ShardModTime returns the later modification time of the shard at path
+// and its .meta file, which changes whenever either is replaced.
+func ShardModTime(path string) (time.Time, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	mtime := fi.ModTime()
+	if fiMeta, err := os.Lstat(path + ".meta"); err == nil && fiMeta.ModTime().After(mtime) {
+		mtime = fiMeta.ModTime()
+	}
+	return mtime, nil
+}
+
+// Complete returns whether shards, the paths of the shards of a repository,
+// are in the state listed by s. The .meta files may have been updated since,
+// eg. by zoekt-sourcegraph-indexserver, but not be older than the build.
+func (s *ShardSet) Complete(shards []string) bool {
+	if len(shards) != len(s.Shards) {
+		return false
+	}
+	for _, path := range shards {
+		state, ok := s.Shards[filepath.Base(path)]
+		if !ok {
+			return false
+		}
+		fi, err := os.Lstat(path)
+		if err != nil || !fi.ModTime().Equal(state.ModTime) {
+			return false
+		}
+
+		fiMeta, err := os.Lstat(path + ".meta")
+		if err != nil {
+			if !state.MetaModTime.IsZero() {
+				return false
+			}
+			continue
+		}
+		// A .meta file older than the shard is left over from an earlier
+		// build.
+		oldest := state.MetaModTime
+		if oldest.Before(state.ModTime) {
+			oldest = state.ModTime
+		}
+		if fiMeta.ModTime().Before(oldest) {
+			return false
+		}
+	}
+	return true
+}
+
+// shardSetPath returns the path of the shard set of the shard at path, eg.
+// repo_v16.shards for repo_v16.00000.zoekt, or "" if path isn't named like
+// a shard.
+func shardSetPath(path string) string {
+	base := strings.TrimSuffix(path, ".zoekt")
+	dot := strings.LastIndexByte(base, '.')
+	if base == path || dot < 0 {
+		return ""
+	}
+	return base[:dot] + ".shards"
+}
+
+// ReadShardSet reads the shard set at path.
+func ReadShardSet(path string) (*ShardSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s ShardSet
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid shard set %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// WriteShardSet atomically replaces the shard set at path by s.
+func WriteShardSet(path string, s *ShardSet) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o666 &^ umask); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SortOrder is an order of search results, see SearchOptions.SortBy.
+type SortOrder string
+
+const (
+	// SortByScore puts the highest scoring files first. It is the
+	// default.
+	SortByScore SortOrder = "score"
+
+	// SortByRepository orders files by repository, then by path.
+	SortByRepository SortOrder = "repo"
+
+	// SortByPath orders files by path, then by repository.
+	SortByPath SortOrder = "path"
+
+	// SortByCommitDate puts files of the most recently changed
+	// repositories, by Repository.LatestCommitDate, first. Files of a
+	// repository are ordered by path.
+	SortByCommitDate SortOrder = "date"
+)
+
+// ParseSortOrder returns the SortOrder named s. The empty string is
+// SortByScore.
+func ParseSortOrder(s string) (SortOrder, error) {
+	switch o := SortOrder(s); o {
+	case "":
+		return SortByScore, nil
+	case SortByScore, SortByRepository, SortByPath, SortByCommitDate:
+		return o, nil
+	}
+	return "", fmt.Errorf("unknown sort order %q, want one of score, repo, path or date", s)
+}
+
+// less reports whether a comes before b in the order. Ties are broken by
+// repository and path, so the order is the same across searches. dates maps
+// repository names to their LatestCommitDate, and is only used by
+// SortByCommitDate.
+func (o SortOrder) less(a, b *FileMatch, dates map[string]time.Time) bool {
+	switch o {
+	case SortByPath:
+		if a.FileName != b.FileName {
+			return a.FileName < b.FileName
+		}
+		return a.Repository < b.Repository
+	case SortByCommitDate:
+		if da, db := dates[a.Repository], dates[b.Repository]; !da.Equal(db) {
+			return da.After(db)
+		}
+	case SortByRepository:
+	default:
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+	}
+	if a.Repository != b.Repository {
+		return a.Repository < b.Repository
+	}
+	return a.FileName < b.FileName
+}
+
+// SortFiles sorts ms in the given order. dates maps repository names to their
+// LatestCommitDate, and is only needed for SortByCommitDate.
+func SortFiles(ms []FileMatch, order SortOrder, dates map[string]time.Time) {
+	sort.Slice(ms, func(i, j int) bool {
+		return order.less(&ms[i], &ms[j], dates)
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zoekt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortFiles(t *testing.T) {
+	files := []FileMatch{
+		{Score: 1, Repository: "old", FileName: "b"},
+		{Score: 3, Repository: "new", FileName: "c"},
+		{Score: 2, Repository: "old", FileName: "a"},
+		{Score: 2, Repository: "new", FileName: "b"},
+	}
+	now := time.Now()
+	dates := map[string]time.Time{"old": now.Add(-time.Hour), "new": now}
+
+	for order, want := range map[SortOrder][]string{
+		SortByScore:      {"new/c", "new/b", "old/a", "old/b"},
+		SortByRepository: {"new/b", "new/c", "old/a", "old/b"},
+		SortByPath:       {"old/a", "new/b", "old/b", "new/c"},
+		SortByCommitDate: {"new/b", "new/c", "old/a", "old/b"},
+	} {
+		SortFiles(files, order, dates)
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Repository+"/"+f.FileName)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", order, got, want)
+		}
+	}
+
+	// By path, the cursor skips files with smaller paths, whatever their
+	// score.
+	SortFiles(files, SortByPath, nil)
+	after := NewCursor(&files[1], SortByPath).Filter(append([]FileMatch{}, files...))
+	if !reflect.DeepEqual(after, files[2:]) {
+		t.Errorf("got %v after cursor, want %v", after, files[2:])
+	}
+}
+
+func TestParseSortOrder(t *testing.T) {
+	for in, want := range map[string]SortOrder{
+		"":      SortByScore,
+		"score": SortByScore,
+		"repo":  SortByRepository,
+		"path":  SortByPath,
+		"date":  SortByCommitDate,
+	} {
+		if got, err := ParseSortOrder(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseSortOrder("size"); err == nil {
+		t.Error("ParseSortOrder succeeded for unknown order")
+	}
+}
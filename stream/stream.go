@@ -29,6 +29,11 @@ func (e eventType) string() string {
 }
 
 // Server returns an http.Handler which is the server side of StreamSearch.
+//
+// Results are streamed as the shards return them only when sorting by score.
+// Any other SearchOptions.SortBy, such as the sort parameter of
+// zoekt-webserver, turns off incremental results: all files arrive in a
+// single event at the end of the search.
 func Server(searcher zoekt.Streamer) http.Handler {
 	registerGob()
 	return &handler{Searcher: searcher}
@@ -87,8 +92,9 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err = h.Searcher.StreamSearch(ctx, args.Q, args.Opts, SenderFunc(func(event *zoekt.SearchResult) {
 		// We don't want to send events over the wire if they just contain stats and no
 		// file matches. Hence, in case we didn't find any results, we will just
-		// aggregate the stats.
-		if len(event.Files) == 0 {
+		// aggregate the stats. Explanations, facets and captures are always
+		// sent.
+		if len(event.Files) == 0 && len(event.Explanations) == 0 && event.Facets == nil && len(event.Captures) == 0 {
 			aggStats.Add(event.Stats)
 			return
 		}
@@ -43,7 +43,14 @@ const IndexFormatVersion = 16
 // 10: Compound shards; more flexible TOC format.
 // 11: Bloom filters for file names & contents
 // 12: go-enry for identifying file languages
-const FeatureVersion = 12
+// 13: Branch masks wider than 64 bits for repositories with many branches
+// 14: 32-bit repository indexes in compound shards
+// 15: Optional block compressed file contents
+// 16: Per-section CRC checksums
+// 17: File owners, eg. from CODEOWNERS
+// 18: Commit message documents
+// 19: Diff documents, separate from commit messages
+const FeatureVersion = 19
 
 // WriteMinFeatureVersion and ReadMinFeatureVersion constrain forwards and backwards
 // compatibility. For example, if a new way to encode filenameNgrams on disk is
@@ -70,6 +77,12 @@ const NextIndexFormatVersion = 17
 
 type indexTOC struct {
 	fileContents compoundSection
+
+	// contentBlocks and contentBoundaries replace fileContents if the
+	// contents are compressed.
+	contentBlocks     compoundSection
+	contentBoundaries simpleSection
+
 	fileNames    compoundSection
 	fileSections compoundSection
 	postings     compoundSection
@@ -84,8 +97,9 @@ type indexTOC struct {
 	symbolKindMap  compoundSection
 	symbolMetaData simpleSection
 
-	branchMasks simpleSection
-	subRepos    simpleSection
+	branchMasks     simpleSection
+	branchMasksWide simpleSection
+	subRepos        simpleSection
 
 	nameNgramText    simpleSection
 	namePostings     compoundSection
@@ -100,6 +114,23 @@ type indexTOC struct {
 	nameBloom    simpleSection
 
 	repos simpleSection
+
+	// ownerNames lists the owners by ID, and fileOwners holds the sorted
+	// owner IDs of each document. Both are empty if no document has owners.
+	ownerNames compoundSection
+	fileOwners compoundSection
+
+	// fileCommits holds the JSON encoded Commit of each commit message
+	// document, and an empty item for other documents. It is empty if there
+	// are no commit message documents.
+	fileCommits compoundSection
+
+	// fileDiffs holds the sorted IDs of the commit documents that are
+	// diffs, as sized deltas.
+	fileDiffs simpleSection
+
+	// sectionChecksums holds a CRC for each of the other sections.
+	sectionChecksums simpleSection
 }
 
 func (t *indexTOC) sections() []section {
@@ -182,6 +213,14 @@ func (t *indexTOC) sectionsTaggedList() []taggedSection {
 		{"repos", &t.repos},
 		{"nameBloom", &t.nameBloom},
 		{"contentBloom", &t.contentBloom},
+		{"branchMasksWide", &t.branchMasksWide},
+		{"contentBlocks", &t.contentBlocks},
+		{"contentBoundaries", &t.contentBoundaries},
+		{"ownerNames", &t.ownerNames},
+		{"fileOwners", &t.fileOwners},
+		{"fileCommits", &t.fileCommits},
+		{"fileDiffs", &t.fileDiffs},
+		{"sectionChecksums", &t.sectionChecksums},
 	}
 }
 
@@ -29,6 +29,9 @@ type LastInput struct {
 	Query string
 	Num   int
 
+	// Sort is the sort order of the results, if not by score.
+	Sort string
+
 	// If set, focus on the search box.
 	AutoFocus bool
 }
@@ -41,6 +44,19 @@ type ResultInput struct {
 	Stats       zoekt.Stats
 	Duration    time.Duration
 	FileMatches []*FileMatch
+
+	// Explanations is set for JSON requests with explain=1.
+	Explanations []zoekt.ShardExplanation `json:",omitempty"`
+
+	// Facets is set for JSON requests with facets=1.
+	Facets *zoekt.Facets `json:",omitempty"`
+
+	// Captures is set for JSON requests with captures=1.
+	Captures map[int]map[string]int `json:",omitempty"`
+
+	// NextCursor is set if there are more files. Passing it as the cursor
+	// parameter returns the next page.
+	NextCursor string `json:",omitempty"`
 }
 
 // FileMatch holds the per file data provided to search results template
@@ -53,6 +69,14 @@ type FileMatch struct {
 	// of the first match.
 	DuplicateID string
 
+	// Duplicates are the other files with the same content, if
+	// duplicates are collapsed.
+	Duplicates []DuplicateFile `json:",omitempty"`
+
+	// Patch is a unified diff that replaces the matches, for JSON
+	// requests with replace=TEMPLATE.
+	Patch string `json:",omitempty"`
+
 	Branches []string
 	Matches  []Match
 	URL      string
@@ -62,6 +86,14 @@ type FileMatch struct {
 	ScoreDebug string  `json:"-"`
 }
 
+// DuplicateFile is a file with the same content as a FileMatch.
+type DuplicateFile struct {
+	FileName string
+	Repo     string
+	Branches []string
+	URL      string
+}
+
 // Match holds the per line data provided to the search results template
 type Match struct {
 	URL      string
@@ -83,6 +115,9 @@ type Fragment struct {
 	Pre   string
 	Match string
 	Post  string
+
+	// Submatches is set for JSON requests with captures=1.
+	Submatches []zoekt.Submatch `json:",omitempty"`
 }
 
 // SearchBoxInput is provided to the SearchBox template.
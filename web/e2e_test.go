@@ -23,6 +23,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
@@ -30,6 +31,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/zoekt"
+	"github.com/google/zoekt/authz"
 	"github.com/google/zoekt/query"
 )
 
@@ -588,6 +590,66 @@ func TestContextLines(t *testing.T) {
 	}
 }
 
+func TestFormatJsonReplace(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	if err := b.Add(zoekt.Document{
+		Name:    "f.go",
+		Content: []byte("func empty() {\n}\n"),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	mux, err := NewMux(&Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	})
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// The match spans two lines, and is replaced as a whole.
+	req := "/search?format=json&replace=X&q=" + url.QueryEscape(`multiline:yes empty.*?\}`)
+	checkResultMatches(t, ts, req, Expectation{
+		"multiline replace",
+		FileMatch{
+			FileName: "f.go",
+			Repo:     "name",
+			Matches: []Match{{
+				FileName: "f.go",
+				LineNum:  1,
+				Fragments: []Fragment{{
+					Pre:   "func ",
+					Match: "empty() {\n}",
+				}},
+			}},
+		},
+	})
+
+	res, err := http.Get(ts.URL + req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var result ApiSearchResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a/f.go\n" +
+		"+++ b/f.go\n" +
+		"@@ -1,2 +1,1 @@\n" +
+		"-func empty() {\n" +
+		"-}\n" +
+		"+func X\n"
+	if got := result.Result.FileMatches[0].Patch; got != want {
+		t.Errorf("got patch %q, want %q", got, want)
+	}
+}
+
 func matchesPartiallyEqual(a, b []Match) bool {
 	if len(a) != len(b) {
 		return false
@@ -841,6 +903,133 @@ func TestDupResult(t *testing.T) {
 	}
 }
 
+func TestCollapseDuplicates(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Add(zoekt.Document{
+			Name:    fmt.Sprintf("file%d", i),
+			Content: []byte("bla"),
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	s := searcherForTest(t, b)
+	srv := Server{
+		Searcher: s,
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/search?q=bla&collapse=1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resultBytes, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	result := string(resultBytes)
+	if strings.Contains(result, "Duplicate result") {
+		t.Errorf("got duplicate result in %s", result)
+	}
+	if got, want := strings.Count(result, "Also in: "), 1; got != want {
+		t.Errorf("got %d duplicate lists, want %d in %s", got, want, result)
+	}
+	if !strings.Contains(result, "name:file1") {
+		t.Errorf("duplicate file1 not listed in %s", result)
+	}
+}
+
+// userAuthorizer allows the repository "name" only to the identity "alice".
+type userAuthorizer struct{}
+
+func (userAuthorizer) Authorize(ctx context.Context, identity string) (query.Q, error) {
+	if identity == "alice" {
+		return nil, nil
+	}
+	return &query.RepoSet{Set: map[string]bool{"other": true}}, nil
+}
+
+func TestAuthorizer(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	if err := b.Add(zoekt.Document{
+		Name:    "f1",
+		Content: []byte("bla"),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	srv := Server{
+		Searcher:   searcherForTest(t, b),
+		Top:        Top,
+		HTML:       true,
+		Authorizer: userAuthorizer{},
+		Identity:   authz.HeaderIdentity("X-User"),
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		user string
+		path string
+		want string
+	}{
+		{"alice", "/search?q=bla", "name:f1"},
+		{"bob", "/search?q=bla", "Found 0 results"},
+		{"", "/search?q=bla", "Found 0 results"},
+		{"alice", "/search?q=r:", "Found 1 repositories"},
+		{"bob", "/search?q=r:", "Found 0 repositories"},
+		{"alice", "/", "from 1 repositories"},
+		{"bob", "/", "from 0 repositories"},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		resultBytes, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if !strings.Contains(string(resultBytes), tc.want) {
+			t.Errorf("%s as %q: want %q in %s", tc.path, tc.user, tc.want, resultBytes)
+		}
+	}
+}
+
 func TestTruncateLine(t *testing.T) {
 	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
 		Name: "name",
@@ -31,6 +31,7 @@ import (
 	"time"
 
 	"github.com/google/zoekt"
+	"github.com/google/zoekt/authz"
 	"github.com/google/zoekt/query"
 	"github.com/google/zoekt/rpc"
 	"github.com/google/zoekt/stream"
@@ -98,6 +99,21 @@ type Server struct {
 	// domains.
 	HostCustomQueries map[string]string
 
+	// If set, requests only see the repositories that Authorizer allows
+	// for their identity. Requests to /rpc are anonymous, and so are the
+	// searches of an aggregate.Dial front end, so Authorizer belongs on the
+	// front end rather than on servers with RPC enabled.
+	Authorizer authz.Authorizer
+
+	// Identity returns the identity of a request for Authorizer, eg.
+	// authz.HeaderIdentity("X-Forwarded-User") or authz.TLSIdentity.
+	// If nil, all requests are anonymous.
+	Identity func(*http.Request) string
+
+	// ClientHeader names the request header that identifies the client
+	// of searches for scheduling, see zoekt.SearchOptions.Client.
+	ClientHeader string
+
 	// This should contain the following templates: "repolist"
 	// (for the repo search result page), "result" for
 	// the search results, "search" (for the opening page),
@@ -105,6 +121,9 @@ type Server struct {
 	// "print" for the show file functionality.
 	Top *template.Template
 
+	// searcher is Searcher, restricted by Authorizer.
+	searcher zoekt.Streamer
+
 	repolist *template.Template
 	search   *template.Template
 	result   *template.Template
@@ -162,18 +181,23 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 	s.templateCache = map[string]*template.Template{}
 	s.startTime = time.Now()
 
+	s.searcher = s.Searcher
+	if s.Authorizer != nil {
+		s.searcher = authz.NewSearcher(s.Searcher, s.Authorizer)
+	}
+
 	mux := http.NewServeMux()
 
 	if s.HTML {
-		mux.HandleFunc("/robots.txt", s.serveRobots)
-		mux.HandleFunc("/search", s.serveSearch)
-		mux.HandleFunc("/", s.serveSearchBox)
-		mux.HandleFunc("/about", s.serveAbout)
-		mux.HandleFunc("/print", s.servePrint)
+		mux.Handle("/robots.txt", s.withIdentity(http.HandlerFunc(s.serveRobots)))
+		mux.Handle("/search", s.withIdentity(http.HandlerFunc(s.serveSearch)))
+		mux.Handle("/", s.withIdentity(http.HandlerFunc(s.serveSearchBox)))
+		mux.Handle("/about", s.withIdentity(http.HandlerFunc(s.serveAbout)))
+		mux.Handle("/print", s.withIdentity(http.HandlerFunc(s.servePrint)))
 	}
 	if s.RPC {
-		mux.Handle(rpc.DefaultRPCPath, rpc.Server(traceAwareSearcher{s.Searcher}))       // /rpc
-		mux.Handle(stream.DefaultSSEPath, stream.Server(traceAwareSearcher{s.Searcher})) // /stream
+		mux.Handle(rpc.DefaultRPCPath, rpc.Server(traceAwareSearcher{s.searcher}))                       // /rpc
+		mux.Handle(stream.DefaultSSEPath, s.withIdentity(stream.Server(traceAwareSearcher{s.searcher}))) // /stream
 	}
 
 	mux.HandleFunc("/healthz", s.serveHealthz)
@@ -181,11 +205,21 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 	return mux, nil
 }
 
+// withIdentity passes the identity of requests to h in their context.
+func (s *Server) withIdentity(h http.Handler) http.Handler {
+	if s.Identity == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(authz.WithIdentity(r.Context(), s.Identity(r))))
+	})
+}
+
 func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
 	q := &query.Const{Value: true}
 	opts := &zoekt.SearchOptions{ShardMaxMatchCount: 1, TotalMaxMatchCount: 1, MaxDocDisplayCount: 1}
 
-	result, err := s.Searcher.Search(r.Context(), q, opts)
+	result, err := s.searcher.Search(r.Context(), q, opts)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("not ready: %v", err), http.StatusInternalServerError)
 		return
@@ -282,13 +316,33 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 				return nil, fmt.Errorf("Number of context lines must be between 0 and 10")
 			}
 		}
+		sOpts.Explain = qvals.Get("explain") == "1"
+		sOpts.Facets = qvals.Get("facets") == "1"
+		sOpts.CaptureGroups = qvals.Get("captures") == "1"
+		if qvals.Has("replace") {
+			// Line matches break matches on newlines, which
+			// ReplaceDiff would replace piecewise.
+			sOpts.Whole = true
+			sOpts.CaptureGroups = true
+			sOpts.ChunkMatches = true
+		}
+		if sOpts.CaptureGroups {
+			// Parse again, keeping the groups.
+			if q, err = query.ParseWithCaptures(queryStr); err != nil {
+				return nil, err
+			}
+		}
 	}
 	sOpts.NumContextLines = numCtxLines
 
 	sOpts.SetDefaults()
+	sOpts.NoCache = qvals.Get("nocache") == "1"
+	if s.ClientHeader != "" {
+		sOpts.Client = r.Header.Get(s.ClientHeader)
+	}
 
 	ctx := r.Context()
-	if result, err := s.Searcher.Search(ctx, q, &zoekt.SearchOptions{EstimateDocCount: true}); err != nil {
+	if result, err := s.searcher.Search(ctx, q, &zoekt.SearchOptions{EstimateDocCount: true, NoCache: sOpts.NoCache, Client: sOpts.Client}); err != nil {
 		return nil, err
 	} else if numdocs := result.ShardFilesConsidered; numdocs > 10000 {
 		// If the search touches many shards and many files, we
@@ -314,27 +368,50 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 	}
 	sOpts.MaxDocDisplayCount = num
 	sOpts.DebugScore = debugScore
+	sOpts.UseBM25Scoring = qvals.Get("bm25") == "1"
+	sOpts.CollapseDuplicates = qvals.Get("collapse") == "1"
+	sOpts.Cursor = qvals.Get("cursor")
+	if sOpts.SortBy, err = zoekt.ParseSortOrder(qvals.Get("sort")); err != nil {
+		return nil, err
+	}
 
-	result, err := s.Searcher.Search(ctx, q, &sOpts)
+	result, err := s.searcher.Search(ctx, q, &sOpts)
 	if err != nil {
 		return nil, err
 	}
+	if sOpts.CollapseDuplicates {
+		// Searchers other than the sharded searcher return duplicates.
+		result.Files = zoekt.CollapseDuplicates(result.Files)
+	}
 
 	fileMatches, err := s.formatResults(result, queryStr, s.Print)
 	if err != nil {
 		return nil, err
 	}
+	if sOpts.Whole {
+		template := qvals.Get("replace")
+		for i := range result.Files {
+			if fileMatches[i].Patch, err = zoekt.ReplaceDiff(&result.Files[i], template); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	res := ResultInput{
 		Last: LastInput{
 			Query:     queryStr,
 			Num:       num,
+			Sort:      qvals.Get("sort"),
 			AutoFocus: true,
 		},
-		Stats:       result.Stats,
-		Query:       q.String(),
-		QueryStr:    queryStr,
-		FileMatches: fileMatches,
+		Stats:        result.Stats,
+		Query:        q.String(),
+		QueryStr:     queryStr,
+		FileMatches:  fileMatches,
+		Explanations: result.Explanations,
+		Facets:       result.Facets,
+		Captures:     result.Captures,
+		NextCursor:   result.NextCursor,
 	}
 	if res.Stats.Wait < res.Stats.Duration/10 {
 		// Suppress queueing stats if they are neglible.
@@ -353,6 +430,11 @@ func (s *Server) servePrint(w http.ResponseWriter, r *http.Request) {
 const statsStaleNess = 30 * time.Second
 
 func (s *Server) fetchStats(ctx context.Context) (*zoekt.RepoStats, error) {
+	if s.Authorizer != nil {
+		// The stats depend on the identity of the request.
+		return s.listStats(ctx)
+	}
+
 	s.lastStatsMu.Lock()
 	stats := s.lastStats
 	if time.Since(s.lastStatsTS) > statsStaleNess {
@@ -364,24 +446,33 @@ func (s *Server) fetchStats(ctx context.Context) (*zoekt.RepoStats, error) {
 		return stats, nil
 	}
 
-	repos, err := s.Searcher.List(ctx, &query.Const{Value: true}, nil)
+	stats, err := s.listStats(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	stats = &zoekt.RepoStats{}
+	s.lastStatsMu.Lock()
+	s.lastStatsTS = time.Now()
+	s.lastStats = stats
+	s.lastStatsMu.Unlock()
+
+	return stats, nil
+}
+
+// listStats returns the stats of all repositories.
+func (s *Server) listStats(ctx context.Context) (*zoekt.RepoStats, error) {
+	repos, err := s.searcher.List(ctx, &query.Const{Value: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &zoekt.RepoStats{}
 	names := map[string]struct{}{}
 	for _, r := range repos.Repos {
 		stats.Add(&r.Stats)
 		names[r.Repository.Name] = struct{}{}
 	}
 	stats.Repos = len(names)
-
-	s.lastStatsMu.Lock()
-	s.lastStatsTS = time.Now()
-	s.lastStats = stats
-	s.lastStatsMu.Unlock()
-
 	return stats, nil
 }
 
@@ -471,7 +562,7 @@ func (s *Server) serveRobots(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) serveListReposErr(q query.Q, qStr string, r *http.Request) (*RepoListInput, error) {
 	ctx := r.Context()
-	repos, err := s.Searcher.List(ctx, q, nil)
+	repos, err := s.searcher.List(ctx, q, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -603,7 +694,7 @@ func (s *Server) servePrintErr(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	ctx := r.Context()
-	result, err := s.Searcher.Search(ctx, q, &sOpts)
+	result, err := s.searcher.Search(ctx, q, &sOpts)
 	if err != nil {
 		return err
 	}
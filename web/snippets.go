@@ -30,12 +30,18 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 
 	templateMap := map[string]*template.Template{}
 	fragmentMap := map[string]*template.Template{}
+	commitMap := map[string]*template.Template{}
 	if !localPrint {
 		for repo, str := range result.RepoURLs {
 			if str != "" {
 				templateMap[repo] = s.getTemplate(str)
 			}
 		}
+		for repo, str := range result.CommitURLs {
+			if str != "" {
+				commitMap[repo] = s.getTemplate(str)
+			}
+		}
 		for repo, str := range result.LineFragments {
 			if str != "" {
 				fragmentMap[repo] = s.getTemplate(str)
@@ -88,6 +94,17 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 		return buf.String()
 	}
 
+	getCommitURL := func(repo, sha string) string {
+		var buf bytes.Buffer
+		if err := commitMap[repo].Execute(&buf, map[string]string{
+			"Version": sha,
+		}); err != nil {
+			log.Printf("commit url template: %v", err)
+			return ""
+		}
+		return buf.String()
+	}
+
 	// hash => result-id
 	seenFiles := map[string]string{}
 	for _, f := range result.Files {
@@ -107,23 +124,44 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			seenFiles[string(f.Checksum)] = fMatch.ResultID
 		}
 
-		if f.SubRepositoryName != "" {
+		if f.Commit != nil && commitMap[f.Repository] != nil {
+			fMatch.URL = getCommitURL(f.Repository, f.Commit.SHA)
+		} else if f.SubRepositoryName != "" {
 			fn := strings.TrimPrefix(fMatch.FileName[len(f.SubRepositoryPath):], "/")
 			fMatch.URL = getURL(f.SubRepositoryName, fn, f.Branches, f.Version)
 		} else {
 			fMatch.URL = getURL(f.Repository, f.FileName, f.Branches, f.Version)
 		}
 
-		for _, m := range f.LineMatches {
-			fragment := getFragment(f.Repository, m.LineNumber)
+		for _, d := range f.Duplicates {
+			dup := DuplicateFile{
+				FileName: d.FileName,
+				Repo:     d.Repository,
+				Branches: d.Branches,
+			}
+			if d.SubRepositoryName != "" {
+				fn := strings.TrimPrefix(d.FileName[len(d.SubRepositoryPath):], "/")
+				dup.URL = getURL(d.SubRepositoryName, fn, d.Branches, d.Version)
+			} else {
+				dup.URL = getURL(d.Repository, d.FileName, d.Branches, d.Version)
+			}
+			fMatch.Duplicates = append(fMatch.Duplicates, dup)
+		}
+
+		matchURL := func(lineNum int) string {
+			fragment := getFragment(f.Repository, lineNum)
 			if !strings.HasPrefix(fragment, "#") && !strings.HasPrefix(fragment, ";") {
 				// TODO - remove this is backward compatibility glue.
 				fragment = "#" + fragment
 			}
+			return fMatch.URL + fragment
+		}
+
+		for _, m := range f.LineMatches {
 			md := Match{
 				FileName: f.FileName,
 				LineNum:  m.LineNumber,
-				URL:      fMatch.URL + fragment,
+				URL:      matchURL(m.LineNumber),
 
 				Score:      m.Score,
 				ScoreDebug: m.DebugScore,
@@ -138,8 +176,9 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 				e := l + f.MatchLength
 
 				frag := Fragment{
-					Pre:   string(line[lastEnd:l]),
-					Match: string(line[l:e]),
+					Pre:        string(line[lastEnd:l]),
+					Match:      string(line[l:e]),
+					Submatches: f.Submatches,
 				}
 				if i == len(m.LineFragments)-1 {
 					frag.Post = string(m.Line[e:])
@@ -150,6 +189,41 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			}
 			fMatch.Matches = append(fMatch.Matches, md)
 		}
+
+		// Chunks are searched for replace, see serveSearchErr. Each
+		// becomes a match of its first line.
+		for _, m := range f.ChunkMatches {
+			lineNum := int(m.ContentStart.LineNumber)
+			md := Match{
+				FileName: f.FileName,
+				LineNum:  lineNum,
+				URL:      matchURL(lineNum),
+
+				Score:      m.Score,
+				ScoreDebug: m.DebugScore,
+			}
+
+			lastEnd := 0
+			for i, r := range m.Ranges {
+				l := int(r.Start.ByteOffset - m.ContentStart.ByteOffset)
+				e := int(r.End.ByteOffset - m.ContentStart.ByteOffset)
+
+				frag := Fragment{
+					Pre:   string(m.Content[lastEnd:l]),
+					Match: string(m.Content[l:e]),
+				}
+				if m.Submatches != nil {
+					frag.Submatches = m.Submatches[i]
+				}
+				if i == len(m.Ranges)-1 {
+					frag.Post = string(m.Content[e:])
+				}
+
+				md.Fragments = append(md.Fragments, frag)
+				lastEnd = e
+			}
+			fMatch.Matches = append(fMatch.Matches, md)
+		}
 		fmatches = append(fmatches, &fMatch)
 	}
 	return fmatches, nil
@@ -157,6 +157,7 @@ document.onkeydown=function(e){
           <dt><a href="search?q=needle">needle</a></dt><dd>search for "needle"</dd>
           <dt><a href="search?q=thread+or+needle">thread or needle</a></dt><dd>search for either "thread" or "needle"</dd>
           <dt><a href="search?q=class+needle">class needle</a></span></dt><dd>search for files containing both "class" and "needle"</dd>
+          <dt><a href="search?q=near:5%28lock+unlock%29">near:5(lock unlock)</a></dt><dd>search for files where "lock" and "unlock" are at most 5 lines apart</dd>
           <dt><a href="search?q=class+Needle">class Needle</a></dt><dd>search for files containing both "class" (case insensitive) and "Needle" (case sensitive)</dd>
           <dt><a href="search?q=class+Needle+case:yes">class Needle case:yes</a></dt><dd>search for files containing "class" and "Needle", both case sensitively</dd>
           <dt><a href="search?q=%22class Needle%22">"class Needle"</a></dt><dd>search for files with the phrase "class Needle"</dd>
@@ -166,9 +167,13 @@ document.onkeydown=function(e){
           <dt><a href="search?q=f:%5C.c%24">f:\.c$</a></dt><dd>search for files whose name ends with ".c"</dd>
           <dt><a href="search?q=path+-file:java">path -file:java</a></dt><dd>search for the word "path" excluding files whose name contains "java"</dd>
           <dt><a href="search?q=foo.*bar">foo.*bar</a></dt><dd>search for the regular expression "foo.*bar"</dd>
+          <dt><a href="search?q=foo.*bar+multiline:yes">foo.*bar multiline:yes</a></dt><dd>search for "foo" followed by "bar", possibly on a later line</dd>
           <dt><a href="search?q=-%28Path File%29 Stream">-(Path File) Stream</a></dt><dd>search "Stream", but exclude files containing both "Path" and "File"</dd>
           <dt><a href="search?q=-Path%5c+file+Stream">-Path\ file Stream</a></dt><dd>search "Stream", but exclude files containing "Path File"</dd>
           <dt><a href="search?q=sym:data">sym:data</a></span></dt><dd>search for symbol definitions containing "data"</dd>
+          <dt><a href="search?q=needle+owner:%40org%2Fteam">needle owner:@org/team</a></dt><dd>search for "needle" in files owned by "@org/team" in CODEOWNERS</dd>
+          <dt><a href="search?q=type:commit+CVE">type:commit CVE</a></dt><dd>search for "CVE" in commit messages, if they were indexed</dd>
+          <dt><a href="search?q=added:lock%5C%28">added:lock\(</a></dt><dd>search for calls to "lock" added by recent commits, if diffs were indexed</dd>
           <dt><a href="search?q=phone+r:droid">phone r:droid</a></dt><dd>search for "phone" in repositories whose name contains "droid"</dd>
           <dt><a href="search?q=phone+archived:no">phone archived:no</a></dt><dd>search for "phone" in repositories that are not archived</dd>
           <dt><a href="search?q=phone+b:master">phone b:master</a></dt><dd>for Git repos, find "phone" in files in branches whose name contains "master".</dd>
@@ -216,7 +221,8 @@ document.onkeydown=function(e){
       {{ $fileCount := len .FileMatches }}
       Found {{.Stats.MatchCount}} results in {{.Stats.FileCount}} files{{if or (lt $fileCount .Stats.FileCount) (or (gt .Stats.ShardsSkipped 0) (gt .Stats.FilesSkipped 0)) }},
         showing top {{ $fileCount }} files (<a rel="nofollow"
-           href="search?q={{.Last.Query}}&num={{More .Last.Num}}">show more</a>).
+           href="search?q={{.Last.Query}}&num={{More .Last.Num}}{{if .Last.Sort}}&sort={{.Last.Sort}}{{end}}">show more</a>{{if .NextCursor}}, <a rel="nofollow"
+           href="search?q={{.Last.Query}}&num={{.Last.Num}}&cursor={{.NextCursor}}{{if .Last.Sort}}&sort={{.Last.Sort}}{{end}}">next page</a>{{end}}).
       {{else}}.{{end}}
     </h5>
     {{range .FileMatches}}
@@ -233,6 +239,7 @@ document.onkeydown=function(e){
                    title="restrict search to files written in {{.Language}}"
                    onclick="zoektAddQ('lang:{{.Language}}')" class="label label-primary">language {{.Language}}</button></span>{{end}}
               {{if .DuplicateID}}<a class="label label-dup" href="#{{.DuplicateID}}">Duplicate result</a>{{end}}
+              {{if .Duplicates}}<br><span style="font-weight: normal">Also in: {{range $i, $d := .Duplicates}}{{if $i}}, {{end}}<a href="{{$d.URL}}">{{$d.Repo}}:{{$d.FileName}}</a>{{end}}</span>{{end}}
             </small>
           </th>
         </tr>
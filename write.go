@@ -106,7 +106,11 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 	w := &writer{w: buffered}
 	toc := indexTOC{}
 
-	toc.fileContents.writeStrings(w, b.contentStrings)
+	if b.CompressContents {
+		writeContentBlocks(w, b.contentStrings, &toc.contentBlocks, &toc.contentBoundaries)
+	} else {
+		toc.fileContents.writeStrings(w, b.contentStrings)
+	}
 	toc.newlines.start(w)
 	for _, f := range b.contentStrings {
 		toc.newlines.addItem(w, toSizedDeltas(newLinesIndices(f.data)))
@@ -129,10 +133,44 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 
 	toc.branchMasks.start(w)
 	for _, m := range b.branchMasks {
-		w.U64(m)
+		w.U64(m.word(0))
 	}
 	toc.branchMasks.end(w)
 
+	// Branches beyond the first 64 go into a separate section, so shards
+	// without such repositories stay readable by older versions.
+	minReaderVersion := WriteMinFeatureVersion
+	if words := b.branchMaskWords(); words > 1 {
+		toc.branchMasksWide.start(w)
+		for _, m := range b.branchMasks {
+			for i := 1; i < words; i++ {
+				w.U64(m.word(i))
+			}
+		}
+		toc.branchMasksWide.end(w)
+		// 13 is the first feature version reading branchMasksWide.
+		minReaderVersion = 13
+	}
+	if len(b.repoList) > 1<<16 {
+		// 14 is the first feature version reading repo indexes wider
+		// than 16 bits.
+		minReaderVersion = 14
+	}
+	if b.CompressContents {
+		// 15 is the first feature version reading contentBlocks.
+		minReaderVersion = 15
+	}
+	if b.hasCommits {
+		// 18 is the first feature version that tells commit documents
+		// from files; older versions would return them as files.
+		minReaderVersion = 18
+	}
+	if len(b.diffDocs) > 0 {
+		// 19 is the first feature version that tells diffs from commit
+		// messages.
+		minReaderVersion = 19
+	}
+
 	toc.fileSections.start(w)
 	for _, s := range b.docSections {
 		toc.fileSections.addItem(w, marshalDocSections(s))
@@ -170,9 +208,39 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 	w.Write(marshalDocSections(b.runeDocSections))
 	toc.runeDocSections.end(w)
 
+	if len(b.ownerMap) > 0 {
+		toc.ownerNames.writeMap(w, b.ownerMap)
+		toc.fileOwners.start(w)
+		for _, ids := range b.fileOwners {
+			toc.fileOwners.addItem(w, toSizedDeltas(ids))
+		}
+		toc.fileOwners.end(w)
+	}
+
+	if b.hasCommits {
+		toc.fileCommits.start(w)
+		for _, c := range b.fileCommits {
+			var item []byte
+			if c != nil {
+				var err error
+				if item, err = json.Marshal(c); err != nil {
+					return err
+				}
+			}
+			toc.fileCommits.addItem(w, item)
+		}
+		toc.fileCommits.end(w)
+	}
+
+	if len(b.diffDocs) > 0 {
+		toc.fileDiffs.start(w)
+		w.Write(toSizedDeltas(b.diffDocs))
+		toc.fileDiffs.end(w)
+	}
+
 	if next {
 		toc.repos.start(w)
-		w.Write(toSizedDeltas16(b.repos))
+		w.Write(toSizedDeltas(b.repos))
 		toc.repos.end(w)
 	}
 
@@ -185,7 +253,7 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 		IndexFormatVersion:    b.indexFormatVersion,
 		IndexTime:             indexTime,
 		IndexFeatureVersion:   b.featureVersion,
-		IndexMinReaderVersion: WriteMinFeatureVersion,
+		IndexMinReaderVersion: minReaderVersion,
 		PlainASCII:            b.contentPostings.isPlainASCII && b.namePostings.isPlainASCII,
 		LanguageMap:           b.languageMap,
 		ZoektVersion:          Version,
@@ -207,6 +275,8 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 		}
 	}
 
+	writeSectionChecksums(w, &toc)
+
 	var tocSection simpleSection
 
 	tocSection.start(w)